@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/goccy/go-json"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sparta142/goblade"
+	"github.com/sparta142/goblade/ffxiv"
+	"github.com/sparta142/goblade/net"
+	"github.com/sparta142/goblade/oodle"
+	"github.com/spf13/cobra"
+)
+
+// The longest line of JSON to read, as a multiple of --max-bundle-size,
+// which is enough for the largest Bundle with its payload encoded in base64.
+const maxLineSizeFactor = 4
+
+var errDecompressFailed = errors.New("failed to decompress some bundles")
+
+var decompressCmd = &cobra.Command{
+	Use:   "decompress FILENAME",
+	Short: "Decompress the deferred Oodle payloads in goblade output, or decode a capture file",
+	Long: "Decompress the Oodle payloads of bundles that were output with --defer-oodle, " +
+		"and write the stream with every bundle decoded. Everything else in the stream is " +
+		"written unchanged. FILENAME may be goblade's JSON Lines output, \"-\" to read it from " +
+		"stdin, or a pcap-compatible file to decode as the file command does.",
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	RunE: func(_ *cobra.Command, args []string) error {
+		if err := captureOptions().Validate(); err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		if err := oodle.Setup(); err != nil {
+			return fmt.Errorf("set up oodle decompression: %w", err)
+		}
+		defer oodle.Shutdown()
+
+		if args[0] == "-" {
+			return decompressLines(os.Stdin, os.Stdout)
+		}
+
+		f, err := os.Open(args[0])
+		if err != nil {
+			return fmt.Errorf("open input file: %w", err)
+		}
+		defer f.Close()
+
+		r := bufio.NewReader(f)
+		if net.IsCapture(r) {
+			return decodeCaptureFile(args[0])
+		}
+
+		return decompressLines(r, os.Stdout)
+	},
+}
+
+// Decodes a capture file with deferral disabled, now that Oodle is set up.
+func decodeCaptureFile(filename string) error {
+	opts := captureOptions()
+	opts.DeferOodle = false
+
+	return runCapture(opts, goblade.WithFile(filename))
+}
+
+// Copies JSON Lines from r to w, decompressing every deferred Bundle.
+// Lines that fail to decompress are copied unchanged.
+func decompressLines(r io.Reader, w io.Writer) error {
+	// The Oodle state isn't changed by decoding,
+	// so one decoder can serve every connection in the stream
+	decoder, err := oodle.NewDecoder()
+	if err != nil {
+		return fmt.Errorf("create oodle decoder: %w", err)
+	}
+	defer decoder.Close()
+
+	encode := newEncoder(w)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineSizeFactor*maxBundleSize)
+
+	var decompressed, failed int
+
+	for line := 1; scanner.Scan(); line++ {
+		event, err := decompressLine(scanner.Bytes(), decoder)
+
+		switch {
+		case err != nil:
+			log.WithError(err).Warnf("Failed to decompress bundle on line %d", line)
+			failed++
+
+		case event != nil:
+			encode(event)
+			decompressed++
+
+			continue
+		}
+
+		if _, err := w.Write(append(scanner.Bytes(), '\n')); err != nil {
+			return fmt.Errorf("write line: %w", err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read lines: %w", err)
+	}
+
+	log.WithFields(log.Fields{
+		"decompressed": decompressed,
+		"failed":       failed,
+	}).Info("Finished decompressing")
+
+	if failed > 0 {
+		return fmt.Errorf("%w: %d bundles", errDecompressFailed, failed)
+	}
+
+	return nil
+}
+
+// Decompresses a line of JSON if it is a deferred Bundle,
+// or returns nil if it is anything else.
+func decompressLine(line []byte, decoder oodle.Decoder) (*goblade.BundleEvent, error) {
+	var probe struct {
+		Type       goblade.EventType `json:"type"`
+		Compressed bool              `json:"compressed"`
+	}
+
+	err := json.Unmarshal(line, &probe)
+	if err != nil || !probe.Compressed || (probe.Type != "" && probe.Type != goblade.TypeBundle) {
+		return nil, nil //nolint:nilerr,nilnil // Not a deferred Bundle, so pass it on
+	}
+
+	var event goblade.BundleEvent
+	if err := json.Unmarshal(line, &event); err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	if err := event.Bundle.Decompress(decoder, maxBundleSize); err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	ffxiv.DefaultResolver().ResolveBundle(&event.Bundle)
+	opcodes.Load().ResolveBundle(&event.Bundle, event.FromServer)
+
+	return &event, nil
+}
+
+func init() {
+	rootCmd.AddCommand(decompressCmd)
+}
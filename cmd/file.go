@@ -1,11 +1,7 @@
 package cmd
 
 import (
-	"fmt"
-
-	log "github.com/sirupsen/logrus"
-
-	"github.com/google/gopacket/pcap"
+	"github.com/sparta142/goblade"
 	"github.com/spf13/cobra"
 )
 
@@ -15,16 +11,7 @@ var fileCmd = &cobra.Command{
 	Args:                  cobra.ExactArgs(1),
 	DisableFlagsInUseLine: true,
 	RunE: func(_ *cobra.Command, args []string) error {
-		handle, err := pcap.OpenOffline(args[0])
-		if err != nil {
-			return fmt.Errorf("open offline pcap file: %w", err)
-		}
-		defer handle.Close()
-
-		log.Infof("Parsing capture file: %s", args[0])
-		handlePackets(handle)
-
-		return nil
+		return runCapture(captureOptions(), goblade.WithFile(args[0]))
 	},
 }
 
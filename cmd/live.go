@@ -1,103 +1,120 @@
 package cmd
 
 import (
-	"errors"
-	"fmt"
+	"context"
+	"io"
 	"os"
 
 	"github.com/goccy/go-json"
 
 	log "github.com/sirupsen/logrus"
 
-	"github.com/google/gopacket/pcap"
-	"github.com/jackpal/gateway"
-	"github.com/sparta142/goblade/ffxiv"
+	"github.com/sparta142/goblade"
 	"github.com/sparta142/goblade/net"
 	"github.com/spf13/cobra"
 )
 
-// Flag indicating that a network interface is loopback.
-const pcapIfLoopback = uint32(0x00000001)
-
-const defaultSnaplen = 2048
-
-var errNoDefaultInterface = errors.New("no default interface found")
-
-var promiscuous bool
+var (
+	promiscuous bool
+	snaplen     = net.DefaultSnaplen
+)
 
 var liveCmd = &cobra.Command{
-	Use:                   "live [--promiscuous] [INTERFACE]",
+	Use:                   "live [--promiscuous] [--snaplen BYTES] [INTERFACE]",
 	Short:                 "Decode traffic from a network interface in real time",
 	Args:                  cobra.MaximumNArgs(1),
 	DisableFlagsInUseLine: true,
 	RunE: func(_ *cobra.Command, args []string) error {
-		var ifname string
-
-		if len(args) == 0 {
-			var err error
-			ifname, err = getDefaultInterfaceName()
-
-			if err != nil {
-				return err
-			}
-
-			log.Infof("Capturing on default device: %s", ifname)
-		} else {
-			ifname = args[0]
-			log.Infof("Capturing on specified device: %s", ifname)
+		var device string
+		if len(args) > 0 {
+			device = args[0]
 		}
 
-		handle, err := pcap.OpenLive(ifname, defaultSnaplen, promiscuous, pcap.BlockForever)
-		if err != nil {
-			return fmt.Errorf("open live pcap device: %w", err)
-		}
-		defer handle.Close()
-		handlePackets(handle)
+		// Pick up opcode changes without restarting the capture
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go watchOpcodes(ctx)
+
+		// Only live captures have a snaplen, since files were captured with theirs
+		opts := captureOptions()
+		opts.Snaplen = snaplen
 
-		return nil
+		return runCapture(opts, goblade.WithInterface(device), goblade.WithPromiscuous(promiscuous))
 	},
 }
 
-// Gets the name of the non-loopback network interface for the default gateway.
-func getDefaultInterfaceName() (string, error) {
-	ip, err := gateway.DiscoverInterface()
-	if err != nil {
-		return "", fmt.Errorf("discover default network interface ip: %w", err)
+// Runs a capture from the source with the capture options and the opcode
+// flags, writing its events to stdout.
+func runCapture(captureOpts net.Options, source ...goblade.Option) error {
+	opts := append([]goblade.Option{goblade.WithCaptureOptions(captureOpts)}, source...)
+	opts = append(opts,
+		goblade.WithOpcodes(&opcodes),
+		goblade.WithOpcodeLoader(loadOpcodes),
+	)
+
+	if region == regionAuto {
+		opts = append(opts, goblade.WithRegionDetection())
 	}
 
-	devs, err := pcap.FindAllDevs()
+	capture, err := goblade.New(opts...)
 	if err != nil {
-		return "", fmt.Errorf("find all network interfaces: %w", err)
+		return err //nolint:wrapcheck
 	}
 
-	for _, iface := range devs {
-		for _, addr := range iface.Addresses {
-			if ip.Equal(addr.IP) && (iface.Flags&pcapIfLoopback) == 0 {
-				return iface.Name, nil
-			}
+	if metricsAddr != "" {
+		server, err := serveMetrics(metricsAddr, capture.Counters())
+		if err != nil {
+			return err
 		}
+		defer server.Close()
 	}
 
-	return "", errNoDefaultInterface
-}
+	errc := make(chan error, 1)
 
-func handlePackets(handle *pcap.Handle) {
-	bundles := make(chan ffxiv.Bundle)
 	go func() {
-		err := net.Capture(handle, bundles)
-		if err != nil {
-			log.Fatal(err)
-		}
+		errc <- capture.Run(context.Background())
 	}()
 
-	e := json.NewEncoder(os.Stdout)
+	encode := newEncoder(os.Stdout)
+
+	for event := range capture.Events() {
+		switch event := event.(type) {
+		case *goblade.DecodeError:
+			if strict {
+				log.WithError(event).Fatal("Error in TCP flow")
+			}
+
+			log.WithError(event).Warn("Error in TCP flow")
+
+		case *goblade.LatencyMeasured:
+			log.Debugf("Round-trip time to %s is %s", event.Server, event.RTT)
+
+		case *goblade.FlowOpened:
+			log.Debugf("Connection %d opened from %s to %s", event.ID, event.Client, event.Server)
+
+		case *goblade.FlowClosed:
+			log.Debugf("Connection %d from %s to %s closed", event.ID, event.Client, event.Server)
+
+		case *goblade.DataGap:
+			log.Warnf("Lost %d bytes from %s to %s", event.Length, event.Src, event.Dst)
+		}
+
+		encode(event)
+	}
+
+	return <-errc
+}
+
+// Creates a function that writes values to w as JSON Lines,
+// exiting if that fails.
+func newEncoder(w io.Writer) func(v any) {
+	e := json.NewEncoder(w)
 	e.SetEscapeHTML(false)
 	e.SetIndent("", "")
 
-	for bnd := range bundles {
-		err := e.EncodeWithOption(bnd, json.DisableNormalizeUTF8())
-		if err != nil {
-			log.WithError(err).Fatal("Failed to encode bundle")
+	return func(v any) {
+		if err := e.EncodeWithOption(v, json.DisableNormalizeUTF8()); err != nil {
+			log.WithError(err).Fatal("Failed to encode output")
 		}
 	}
 }
@@ -111,4 +128,11 @@ func init() {
 		false,
 		"capture all network traffic instead of just this computer's",
 	)
+
+	liveCmd.Flags().IntVar(
+		&snaplen,
+		"snaplen",
+		snaplen,
+		"the most bytes to capture of each packet",
+	)
 }
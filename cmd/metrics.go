@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	stdnet "net"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sparta142/goblade/net"
+)
+
+// How long a metrics request may take to send its headers.
+const metricsReadHeaderTimeout = 10 * time.Second
+
+// Serves the counters at /metrics on addr, in the Prometheus text format.
+// The listener is opened before returning, so that a bad or busy address
+// is reported straight away.
+func serveMetrics(addr string, counters *net.Counters) (*http.Server, error) {
+	listener, err := stdnet.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen for metrics requests: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", net.MetricsHandler(counters))
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: metricsReadHeaderTimeout,
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.WithError(err).Error("Failed to serve metrics")
+		}
+	}()
+
+	log.Infof("Serving metrics at http://%s/metrics", listener.Addr())
+
+	return server, nil
+}
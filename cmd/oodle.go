@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"text/tabwriter"
+
+	"github.com/sparta142/goblade/oodle"
+	"github.com/spf13/cobra"
+)
+
+var errOodleCheckFailed = errors.New("oodle decompression is not available")
+
+var oodleCmd = &cobra.Command{
+	Use:   "oodle",
+	Short: "Inspect Oodle decompression",
+}
+
+var oodleCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check that Oodle decompression is available and working",
+	Long: "Set up Oodle decompression as the other commands would, report which backend is used, " +
+		"and decode an embedded sample with it.",
+	Args:                  cobra.NoArgs,
+	DisableFlagsInUseLine: true,
+	RunE: func(*cobra.Command, []string) error {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		defer w.Flush()
+
+		fmt.Fprintf(w, "Platform:\t%s/%s\n", runtime.GOOS, runtime.GOARCH)
+
+		if err := oodle.Setup(); err != nil {
+			fmt.Fprintf(w, "Setup:\tfailed: %v\n", err)
+			return errOodleCheckFailed
+		}
+		defer oodle.Shutdown()
+
+		backend := oodle.CurrentBackend()
+		fmt.Fprintf(w, "Setup:\tok\n")
+		fmt.Fprintf(w, "Backend:\t%v\n", backend)
+
+		if err := oodle.SelfTest(backend); err != nil {
+			fmt.Fprintf(w, "Self-test:\tfailed: %v\n", err)
+			return errOodleCheckFailed
+		}
+
+		fmt.Fprintf(w, "Self-test:\tok\n")
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(oodleCmd)
+	oodleCmd.AddCommand(oodleCheckCmd)
+}
@@ -0,0 +1,364 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"go/format"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sparta142/goblade/ffxiv"
+	"github.com/spf13/cobra"
+)
+
+// How often to check the opcode files for changes.
+const opcodesPollInterval = 2 * time.Second
+
+// Loads the opcode table for a region from the opcodes file,
+// or the embedded opcodes if there isn't one, then applies any overrides.
+func loadOpcodes(region ffxiv.Region) (ffxiv.OpcodeTable, error) {
+	var (
+		table ffxiv.OpcodeTable
+		err   error
+	)
+
+	if opcodesPath != "" {
+		table, err = ffxiv.LoadOpcodes(opcodesPath, region)
+	} else {
+		table, err = ffxiv.GetOpcodes(region)
+	}
+
+	if err != nil {
+		return ffxiv.OpcodeTable{}, err
+	}
+
+	if overridesPath != "" {
+		overrides, err := ffxiv.LoadOpcodeOverrides(overridesPath)
+		if err != nil {
+			return ffxiv.OpcodeTable{}, err
+		}
+
+		table = table.WithOverrides(overrides)
+	}
+
+	return table, nil
+}
+
+// Reloads the opcode table whenever the opcodes or overrides file changes,
+// until the context is cancelled. A table that fails to load is logged
+// and the previous table is kept.
+func watchOpcodes(ctx context.Context) {
+	paths := make([]string, 0, 2)
+	for _, path := range []string{opcodesPath, overridesPath} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	if len(paths) == 0 {
+		return
+	}
+
+	modTimes := make([]time.Time, len(paths))
+	for i, path := range paths {
+		modTimes[i], _ = modTime(path)
+	}
+
+	ticker := time.NewTicker(opcodesPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed := false
+		for i, path := range paths {
+			t, err := modTime(path)
+			if err != nil {
+				log.WithError(err).Debug("Failed to check opcodes file")
+				continue
+			}
+
+			if !t.Equal(modTimes[i]) {
+				modTimes[i] = t
+				changed = true
+			}
+		}
+
+		if !changed {
+			continue
+		}
+
+		// Keep the region of the current table, which may have been detected
+		table, err := loadOpcodes(opcodes.Load().Region)
+		if err != nil {
+			log.WithError(err).Warn("Failed to reload opcodes, keeping the current table")
+			continue
+		}
+
+		opcodes.Store(table)
+		log.Infof("Reloaded %s opcodes (version %s)", table.Region, table.Version)
+	}
+}
+
+// Gets the time a file was last modified.
+func modTime(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("stat %s: %w", path, err)
+	}
+
+	return info.ModTime(), nil
+}
+
+// The diff argument naming the opcodes embedded at build time.
+const embeddedOpcodesArg = "embedded"
+
+var errBadExportFormat = errors.New("format must be csv, json or go")
+
+var (
+	exportFormat  = "csv"
+	exportPackage = "opcodes"
+)
+
+var opcodesCmd = &cobra.Command{
+	Use:   "opcodes",
+	Short: "Inspect, search, export and compare opcode tables",
+	Long: "Inspect, search, export and compare opcode tables. The table is chosen with " +
+		"--region, --opcodes and --opcode-overrides, as for capturing.",
+	Example: strings.Join([]string{
+		"goblade opcodes list",
+		"goblade opcodes lookup 0x038f",
+		"goblade opcodes lookup --region KR PlayerSetup",
+		"goblade opcodes export --format go --package opcodes",
+		"goblade opcodes diff embedded ./opcodes.json",
+	}, "\n"),
+}
+
+var opcodesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List every opcode in the table",
+	Args:  cobra.NoArgs,
+	RunE: func(*cobra.Command, []string) error {
+		return printEntries(opcodes.Load().Entries())
+	},
+}
+
+var opcodesLookupCmd = &cobra.Command{
+	Use:   "lookup NAME|NUMBER",
+	Short: "Find opcodes by number, or by a case-insensitive part of their name",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		entries := lookupOpcodes(opcodes.Load(), args[0])
+		if len(entries) == 0 {
+			log.Warnf("No opcodes match %q", args[0])
+			return nil
+		}
+
+		return printEntries(entries)
+	},
+}
+
+var opcodesExportCmd = &cobra.Command{
+	Use:   "export [--format csv|json|go]",
+	Short: "Write the table to stdout as CSV, FFXIVOpcodes JSON or Go constants",
+	Args:  cobra.NoArgs,
+	RunE: func(*cobra.Command, []string) error {
+		var (
+			data []byte
+			err  error
+		)
+
+		table := opcodes.Load()
+
+		switch strings.ToLower(exportFormat) {
+		case "csv":
+			data, err = exportCSV(table)
+		case "json":
+			data, err = ffxiv.MarshalOpcodes(*table)
+		case "go":
+			data, err = exportGo(table, exportPackage)
+		default:
+			return errBadExportFormat
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Stdout.Write(data); err != nil {
+			return fmt.Errorf("write export: %w", err)
+		}
+
+		return nil
+	},
+}
+
+var opcodesDiffCmd = &cobra.Command{
+	Use:   "diff A B",
+	Short: "Show the opcodes renumbered, added and removed going from table A to table B",
+	Long: "Show the opcodes renumbered, added and removed going from table A to table B. " +
+		"Each table is a FFXIVOpcodes JSON file, or \"" + embeddedOpcodesArg + "\" for the built-in opcodes. " +
+		"Both are read for --region, and --opcode-overrides is not applied.",
+	Args: cobra.ExactArgs(2),
+	RunE: func(_ *cobra.Command, args []string) error {
+		from, err := loadOpcodesArg(args[0])
+		if err != nil {
+			return err
+		}
+
+		to, err := loadOpcodesArg(args[1])
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, change := range ffxiv.DiffOpcodes(&from, &to) {
+			switch change.Kind {
+			case ffxiv.OpcodeRenumbered:
+				fmt.Fprintf(w, "~\t%s\t%s\t%s -> %s\n",
+					change.IpcType, change.Name, formatOpcode(change.OldOpcode), formatOpcode(change.NewOpcode))
+			case ffxiv.OpcodeAdded:
+				fmt.Fprintf(w, "+\t%s\t%s\t%s\n", change.IpcType, change.Name, formatOpcode(change.NewOpcode))
+			case ffxiv.OpcodeRemoved:
+				fmt.Fprintf(w, "-\t%s\t%s\t%s\n", change.IpcType, change.Name, formatOpcode(change.OldOpcode))
+			}
+		}
+
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("write diff: %w", err)
+		}
+
+		return nil
+	},
+}
+
+// Loads a table named on the command line, for the requested region.
+func loadOpcodesArg(arg string) (ffxiv.OpcodeTable, error) {
+	if arg == embeddedOpcodesArg {
+		return ffxiv.GetOpcodes(initialRegion())
+	}
+
+	return ffxiv.LoadOpcodes(arg, initialRegion())
+}
+
+// Finds the opcodes equal to query if it is a number,
+// or otherwise whose names contain it, ignoring case.
+func lookupOpcodes(table *ffxiv.OpcodeTable, query string) []ffxiv.OpcodeEntry {
+	var matches []ffxiv.OpcodeEntry
+
+	number, err := strconv.ParseInt(query, 0, 32)
+	isNumber := err == nil
+	query = strings.ToLower(query)
+
+	for _, entry := range table.Entries() {
+		if isNumber && int64(entry.Opcode) == number ||
+			!isNumber && strings.Contains(strings.ToLower(entry.Name), query) {
+			matches = append(matches, entry)
+		}
+	}
+
+	return matches
+}
+
+// Prints opcode entries to stdout as an aligned table.
+func printEntries(entries []ffxiv.OpcodeEntry) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", entry.IpcType, formatOpcode(entry.Opcode), entry.Opcode, entry.Name)
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write opcodes: %w", err)
+	}
+
+	return nil
+}
+
+func formatOpcode(opcode int) string {
+	return fmt.Sprintf("0x%04x", opcode)
+}
+
+// Encodes the table as CSV with a header row.
+func exportCSV(table *ffxiv.OpcodeTable) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"type", "name", "opcode"})
+
+	for _, entry := range table.Entries() {
+		_ = w.Write([]string{string(entry.IpcType), entry.Name, formatOpcode(entry.Opcode)})
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("encode csv: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Encodes the table as a Go source file declaring a constant for each opcode,
+// named after its IpcType and opcode name.
+func exportGo(table *ffxiv.OpcodeTable, pkg string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "// Code generated by goblade opcodes export. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "// Opcodes for region %s, version %s.\n", table.Region, table.Version)
+	fmt.Fprintf(&buf, "package %s\n\n", pkg)
+
+	var ipcType ffxiv.IpcType
+	for _, entry := range table.Entries() {
+		if entry.IpcType != ipcType {
+			if ipcType != "" {
+				buf.WriteString(")\n\n")
+			}
+
+			ipcType = entry.IpcType
+			fmt.Fprintf(&buf, "// %s opcodes.\nconst (\n", ipcType)
+		}
+
+		fmt.Fprintf(&buf, "%s = %s\n", goIdentifier(string(entry.IpcType)+"_"+entry.Name), formatOpcode(entry.Opcode))
+	}
+
+	if ipcType != "" {
+		buf.WriteString(")\n")
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format go source: %w", err)
+	}
+
+	return src, nil
+}
+
+// Replaces the characters of s that can't appear in a Go identifier.
+func goIdentifier(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return r
+		}
+
+		return '_'
+	}, s)
+}
+
+func init() {
+	rootCmd.AddCommand(opcodesCmd)
+	opcodesCmd.AddCommand(opcodesListCmd, opcodesLookupCmd, opcodesExportCmd, opcodesDiffCmd)
+
+	opcodesExportCmd.Flags().StringVar(&exportFormat, "format", exportFormat, "the output format (csv, json or go)")
+	opcodesExportCmd.Flags().StringVar(&exportPackage, "package", exportPackage, "the package name for Go output")
+}
@@ -3,17 +3,43 @@ package cmd
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/inconshreveable/mousetrap"
 	log "github.com/sirupsen/logrus"
 	"github.com/sparta142/goblade/ffxiv"
+	"github.com/sparta142/goblade/net"
+	"github.com/sparta142/goblade/oodle"
 	"github.com/spf13/cobra"
 )
 
 var (
 	verbose = false
+	strict  = false
 	region  = string(ffxiv.RegionGlobal)
-	opcodes ffxiv.OpcodeTable //nolint:unused
+
+	opcodesPath   = ""
+	overridesPath = ""
+	opcodes       ffxiv.OpcodeStore
+	serversPath   = ""
+
+	gameExePath      = ""
+	oodleLibraryPath = ""
+	oodleLibrary     *oodle.Library
+	deferOodle       = false
+
+	statsInterval time.Duration
+	metricsAddr   = ""
+
+	maxPagesPerConnection = net.DefaultMaxBufferedPagesPerConnection
+	maxPagesTotal         = net.DefaultMaxBufferedPagesTotal
+	flushInterval         = net.DefaultFlushInterval
+	flushStreamAge        = net.DefaultFlushStreamAge
+	pipeBufferSize        = net.DefaultPipeBufferSize
+	maxBundleSize         = net.DefaultMaxBundleSize
+	queueSize             = net.DefaultQueueSize
+	queuePolicy           = ""
+	filter                = ""
 )
 
 // Version info from ldflags.
@@ -31,6 +57,10 @@ var rootCmd = &cobra.Command{
 		"goblade live",
 		"goblade live enp0s2",
 		"goblade file ./packets.pcapng",
+		"goblade synth --loss 0.01 ./synthetic.pcapng",
+		"goblade opcodes lookup 0x038f",
+		"goblade decompress ./deferred.jsonl",
+		"goblade oodle check",
 	}, "\n"),
 	CompletionOptions: cobra.CompletionOptions{
 		DisableDefaultCmd: true,
@@ -40,13 +70,66 @@ var rootCmd = &cobra.Command{
 			log.SetLevel(log.DebugLevel)
 		}
 
+		// Replace the known data centers and worlds, if requested
+		if serversPath != "" {
+			resolver, err := ffxiv.LoadResolver(serversPath)
+			cobra.CheckErr(err)
+			ffxiv.SetDefaultResolver(resolver)
+		}
+
+		oodle.Configure(oodle.Config{GameExe: gameExePath})
+
+		// Decompress Oodle with a shared library instead of the game, if requested
+		if oodleLibraryPath != "" {
+			var err error
+			oodleLibrary, err = oodle.OpenLibrary(oodleLibraryPath)
+			cobra.CheckErr(err)
+			oodle.Use(oodleLibrary)
+		}
+
 		// Load the opcode table for the requested region
-		var err error
-		opcodes, err = ffxiv.GetOpcodes(ffxiv.Region(region))
+		table, err := loadOpcodes(initialRegion())
 		cobra.CheckErr(err)
+		opcodes.Store(table)
+	},
+	PersistentPostRun: func(*cobra.Command, []string) {
+		if oodleLibrary != nil {
+			oodle.Use(nil)
+			_ = oodleLibrary.Close()
+		}
 	},
 }
 
+// The --region value for detecting the region from the first connection.
+const regionAuto = "auto"
+
+// Gets the region to load opcodes for before any connection is seen.
+// When detecting the region, the global opcodes are used until then.
+func initialRegion() ffxiv.Region {
+	if region == regionAuto {
+		return ffxiv.RegionGlobal
+	}
+
+	return ffxiv.Region(region)
+}
+
+// Gets the capture options from the flags that every capture command has.
+func captureOptions() net.Options {
+	return net.Options{
+		DeferOodle:                    deferOodle,
+		StatsInterval:                 statsInterval,
+		MaxBufferedPagesPerConnection: maxPagesPerConnection,
+		MaxBufferedPagesTotal:         maxPagesTotal,
+		FlushInterval:                 flushInterval,
+		FlushStreamAge:                flushStreamAge,
+		PipeBufferSize:                pipeBufferSize,
+		MaxBundleSize:                 maxBundleSize,
+		QueueSize:                     queueSize,
+		QueuePolicy:                   net.QueuePolicy(queuePolicy),
+		Filter:                        filter,
+	}
+}
+
 func Execute() {
 	log.StandardLogger().Formatter = &log.TextFormatter{
 		TimestampFormat: "2006-01-02T15:04:05.000Z07:00",
@@ -70,11 +153,144 @@ func init() {
 		"log more information to stderr",
 	)
 
+	rootCmd.PersistentFlags().BoolVar(
+		&strict,
+		"strict",
+		strict,
+		"exit on the first error in a TCP flow instead of reporting it and carrying on",
+	)
+
 	rootCmd.PersistentFlags().StringVarP(
 		&region,
 		"region",
 		"r",
 		region,
-		"the opcode region to decode IPCs for",
+		"the opcode region to decode IPCs for, or \""+regionAuto+"\" to detect it from the first connection "+
+			"(the built-in networks are all "+string(ffxiv.RegionGlobal)+", so detecting other regions "+
+			"needs --servers with their networks; without them, a warning is logged and only "+
+			string(ffxiv.RegionGlobal)+" traffic is captured)",
+	)
+
+	rootCmd.PersistentFlags().StringVar(
+		&opcodesPath,
+		"opcodes",
+		opcodesPath,
+		"read opcodes from a FFXIVOpcodes JSON file instead of the built-in ones",
+	)
+
+	rootCmd.PersistentFlags().StringVar(
+		&overridesPath,
+		"opcode-overrides",
+		overridesPath,
+		"patch individual opcodes from a JSON file of {\"IpcType\": {\"Name\": opcode}}",
+	)
+
+	rootCmd.PersistentFlags().StringVar(
+		&serversPath,
+		"servers",
+		serversPath,
+		"read data center networks and worlds from a JSON file instead of the built-in ones",
+	)
+
+	rootCmd.PersistentFlags().StringVar(
+		&gameExePath,
+		"game-exe",
+		gameExePath,
+		"decompress Oodle with this game executable instead of finding one (Windows only)",
+	)
+
+	rootCmd.PersistentFlags().StringVar(
+		&oodleLibraryPath,
+		"oodle-library",
+		oodleLibraryPath,
+		"decompress Oodle with a shared library exporting the OodleNetwork1 API (Linux only)",
+	)
+
+	rootCmd.PersistentFlags().BoolVar(
+		&deferOodle,
+		"defer-oodle",
+		deferOodle,
+		"output bundles that can't be Oodle-decompressed with their payloads still compressed, for the decompress command",
+	)
+
+	rootCmd.PersistentFlags().DurationVar(
+		&statsInterval,
+		"stats-interval",
+		statsInterval,
+		"output capture statistics this often, and when the capture ends (e.g. 30s; 0 to only log them at the end)",
+	)
+
+	rootCmd.PersistentFlags().StringVar(
+		&metricsAddr,
+		"metrics-addr",
+		metricsAddr,
+		"serve Prometheus metrics at /metrics on this address (e.g. :9137)",
+	)
+
+	rootCmd.PersistentFlags().IntVar(
+		&maxPagesPerConnection,
+		"max-pages-per-connection",
+		maxPagesPerConnection,
+		"the most out-of-order TCP segments to buffer for one connection before giving up on missing data "+
+			"(0 for no limit)",
+	)
+
+	rootCmd.PersistentFlags().IntVar(
+		&maxPagesTotal,
+		"max-pages-total",
+		maxPagesTotal,
+		"the most out-of-order TCP segments to buffer for all connections before giving up on missing data "+
+			"(0 for no limit)",
+	)
+
+	rootCmd.PersistentFlags().DurationVar(
+		&flushInterval,
+		"flush-interval",
+		flushInterval,
+		"how often to look for connections with old out-of-order data",
+	)
+
+	rootCmd.PersistentFlags().DurationVar(
+		&flushStreamAge,
+		"flush-stream-age",
+		flushStreamAge,
+		"how old out-of-order data must be before giving up on the data missing before it",
+	)
+
+	rootCmd.PersistentFlags().IntVar(
+		&pipeBufferSize,
+		"pipe-buffer-size",
+		pipeBufferSize,
+		"the bytes to buffer between reassembling and decoding each direction of a connection",
+	)
+
+	rootCmd.PersistentFlags().IntVar(
+		&maxBundleSize,
+		"max-bundle-size",
+		maxBundleSize,
+		"the largest bundle to decode or decompress, in bytes, both as sent and once decompressed",
+	)
+
+	rootCmd.PersistentFlags().IntVar(
+		&queueSize,
+		"queue-size",
+		queueSize,
+		"the most results of each kind to hold while waiting for them to be output",
+	)
+
+	rootCmd.PersistentFlags().StringVar(
+		&queuePolicy,
+		"queue-policy",
+		queuePolicy,
+		"what to do with new results when the output can't keep up: "+
+			"block (stalls the capture), drop-oldest or drop-newest "+
+			"(default block for files, "+string(net.DefaultQueuePolicy)+" when live)",
+	)
+
+	rootCmd.PersistentFlags().StringVar(
+		&filter,
+		"filter",
+		filter,
+		"decode the packets matching this BPF filter instead of traffic with known data centers",
 	)
 }
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sparta142/goblade/ffxiv"
+	"github.com/sparta142/goblade/synth"
+	"github.com/spf13/cobra"
+)
+
+var errBadCompression = errors.New("compression must be none or zlib")
+
+var (
+	synthConfig      = synth.DefaultConfig()
+	synthOpcodes     = []string{"0x009c", "0x038f"}
+	synthCompression = "zlib"
+	synthClient      = synthConfig.Client.String()
+	synthServer      = synthConfig.Server.String()
+)
+
+var synthCmd = &cobra.Command{
+	Use:   "synth [flags] FILENAME",
+	Short: "Generate a synthetic pcapng capture of FFXIV traffic",
+	Long: "Generate a synthetic pcapng capture containing one TCP connection carrying " +
+		"randomized FFXIV bundles, optionally with simulated loss, reordering and retransmission. " +
+		"The output can be decoded with the file command.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(_ *cobra.Command, args []string) error {
+		cfg, err := buildSynthConfig()
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Create(args[0])
+		if err != nil {
+			return fmt.Errorf("create output file: %w", err)
+		}
+		defer f.Close()
+
+		summary, err := synth.Generate(f, cfg)
+		if err != nil {
+			return fmt.Errorf("generate capture: %w", err)
+		}
+
+		log.WithFields(log.Fields{
+			"packets":       summary.Packets,
+			"bundles":       summary.Bundles,
+			"lost":          summary.Lost,
+			"reordered":     summary.Reordered,
+			"retransmitted": summary.Retransmitted,
+		}).Infof("Wrote synthetic capture to %s", args[0])
+
+		return nil
+	},
+}
+
+// Applies the string-typed flags to synthConfig.
+func buildSynthConfig() (synth.Config, error) {
+	cfg := synthConfig
+	cfg.Opcodes = make([]uint16, len(synthOpcodes))
+
+	for i, s := range synthOpcodes {
+		opcode, err := strconv.ParseUint(s, 0, 16)
+		if err != nil {
+			return cfg, fmt.Errorf("parse opcode %q: %w", s, err)
+		}
+
+		cfg.Opcodes[i] = uint16(opcode)
+	}
+
+	switch strings.ToLower(synthCompression) {
+	case "none":
+		cfg.Compression = ffxiv.CompressionNone
+	case "zlib":
+		cfg.Compression = ffxiv.CompressionZlib
+	default:
+		return cfg, errBadCompression
+	}
+
+	var err error
+
+	if cfg.Client, err = netip.ParseAddrPort(synthClient); err != nil {
+		return cfg, fmt.Errorf("parse client endpoint: %w", err)
+	}
+
+	if cfg.Server, err = netip.ParseAddrPort(synthServer); err != nil {
+		return cfg, fmt.Errorf("parse server endpoint: %w", err)
+	}
+
+	if !ffxiv.IsFinalFantasyIP(cfg.Server.Addr().AsSlice()) {
+		log.Warnf("Server address %s is not a known FFXIV address, so goblade won't capture it", cfg.Server.Addr())
+	}
+
+	return cfg, nil
+}
+
+func init() {
+	rootCmd.AddCommand(synthCmd)
+
+	flags := synthCmd.Flags()
+	flags.IntVar(&synthConfig.Bundles, "bundles", synthConfig.Bundles, "the number of bundles to generate")
+	flags.Float64Var(&synthConfig.Rate, "rate", synthConfig.Rate, "the average number of bundles per second")
+	flags.StringSliceVar(&synthOpcodes, "opcodes", synthOpcodes, "the IPC opcodes to choose from")
+	flags.IntVar(&synthConfig.MinSize, "min-size", synthConfig.MinSize, "the minimum IPC payload size, in bytes")
+	flags.IntVar(&synthConfig.MaxSize, "max-size", synthConfig.MaxSize, "the maximum IPC payload size, in bytes")
+	flags.IntVar(&synthConfig.MaxSegments, "max-segments", synthConfig.MaxSegments, "the maximum segments per bundle")
+	flags.Uint32Var(&synthConfig.ActorID, "actor", synthConfig.ActorID, "the actor ID of the player character")
+	flags.StringVar(&synthCompression, "compression", synthCompression, "how to compress bundles (none or zlib)")
+	flags.IntVar(&synthConfig.MSS, "mss", synthConfig.MSS, "the maximum bundle bytes per TCP segment")
+	flags.Float64Var(&synthConfig.Loss, "loss", synthConfig.Loss, "the probability of losing a data segment")
+	flags.Float64Var(&synthConfig.Reorder, "reorder", synthConfig.Reorder, "the probability of reordering a data segment")
+	flags.Float64Var(&synthConfig.Retransmit, "retransmit", synthConfig.Retransmit,
+		"the probability of retransmitting a data segment")
+	flags.Int64Var(&synthConfig.Seed, "seed", synthConfig.Seed, "the random seed")
+	flags.StringVar(&synthClient, "client", synthClient, "the client endpoint (IPv4:port)")
+	flags.StringVar(&synthServer, "server", synthServer, "the server endpoint (IPv4:port)")
+}
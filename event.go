@@ -0,0 +1,199 @@
+package goblade
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/goccy/go-json"
+
+	"github.com/sparta142/goblade/ffxiv"
+	"github.com/sparta142/goblade/net"
+)
+
+var errNotObject = errors.New("goblade: event doesn't marshal to a JSON object")
+
+// EventType is the kind of an Event, which is the "type" field of its JSON.
+type EventType string
+
+const (
+	TypeBundle          EventType = "bundle"
+	TypeFlowOpened      EventType = "flowOpened"
+	TypeFlowClosed      EventType = "flowClosed"
+	TypeDataGap         EventType = "dataGap"
+	TypeDecodeError     EventType = "decodeError"
+	TypeLatencyMeasured EventType = "latencyMeasured"
+	TypeRegionDetected  EventType = "regionDetected"
+	TypeStatsSnapshot   EventType = "statsSnapshot"
+	TypeOodleStatus     EventType = "oodleStatus"
+)
+
+// Event is something reported by a Capture. It is one of *BundleEvent,
+// *FlowOpened, *FlowClosed, *DataGap, *DecodeError, *LatencyMeasured,
+// *RegionDetected, *StatsSnapshot or *OodleStatus, so it can be handled
+// with a type switch. Each marshals to a JSON object with its fields and
+// a "type" field holding its EventType.
+type Event interface {
+	// Gets the kind of the event.
+	Type() EventType
+
+	event()
+}
+
+// BundleEvent is a decoded Bundle, with the flow that it was sent on.
+type BundleEvent struct {
+	net.FlowBundle
+}
+
+// The JSON of a BundleEvent, with the Bundle's fields alongside the flow's.
+type bundleEventJSON struct {
+	ConnectionID uint64         `json:"connectionId"`
+	Src          netip.AddrPort `json:"src"`
+	Dst          netip.AddrPort `json:"dst"`
+	FromServer   bool           `json:"fromServer"`
+	*ffxiv.Bundle
+}
+
+func (e *BundleEvent) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e.Type(), &bundleEventJSON{e.ConnectionID, e.Src, e.Dst, e.FromServer, &e.Bundle})
+}
+
+// Unmarshals a BundleEvent from its JSON, or from the JSON of a Bundle on its own.
+func (e *BundleEvent) UnmarshalJSON(data []byte) error {
+	v := bundleEventJSON{Bundle: &e.Bundle}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("unmarshal bundle event: %w", err)
+	}
+
+	e.ConnectionID = v.ConnectionID
+	e.Src = v.Src
+	e.Dst = v.Dst
+	e.FromServer = v.FromServer
+
+	return nil
+}
+
+// FlowOpened is a connection that was seen for the first time.
+type FlowOpened struct {
+	net.ConnectionOpened
+}
+
+func (e *FlowOpened) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e.Type(), &e.ConnectionOpened)
+}
+
+// FlowClosed is a connection that has closed, once everything sent on it
+// has been decoded.
+type FlowClosed struct {
+	net.ConnectionClosed
+}
+
+func (e *FlowClosed) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e.Type(), &e.ConnectionClosed)
+}
+
+// DataGap is data that was never captured, and so is missing from a flow.
+type DataGap struct {
+	net.DataGap
+}
+
+func (e *DataGap) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e.Type(), &e.DataGap)
+}
+
+// DecodeError is an error decoding a flow, which doesn't stop the capture.
+type DecodeError struct {
+	net.FlowError
+}
+
+func (e *DecodeError) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e.Type(), &e.FlowError)
+}
+
+// LatencyMeasured is a latency measured from a keep-alive exchange.
+type LatencyMeasured struct {
+	net.Latency
+}
+
+func (e *LatencyMeasured) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e.Type(), &e.Latency)
+}
+
+// RegionDetected is the region detected from the first connection, which
+// is only reported when detecting the region (see WithRegionDetection).
+type RegionDetected struct {
+	net.RegionDetection
+}
+
+func (e *RegionDetected) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e.Type(), &e.RegionDetection)
+}
+
+// StatsSnapshot is a snapshot of the capture's counters, which is only
+// reported if there is a stats interval (see WithStatsInterval).
+type StatsSnapshot struct {
+	net.Stats
+}
+
+func (e *StatsSnapshot) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e.Type(), &e.Stats)
+}
+
+// OodleStatus is whether Oodle-compressed bundles can be decoded, which
+// is reported once the capture has set up decompression.
+type OodleStatus struct {
+	net.OodleStatus
+}
+
+func (e *OodleStatus) MarshalJSON() ([]byte, error) {
+	return marshalEvent(e.Type(), &e.OodleStatus)
+}
+
+// Marshals v, which must marshal to a JSON object, with a "type" field first.
+func marshalEvent(t EventType, v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal %s event: %w", t, err)
+	}
+
+	if len(data) < 2 || data[0] != '{' {
+		return nil, fmt.Errorf("%w: %s", errNotObject, t)
+	}
+
+	out := make([]byte, 0, len(data)+len(t)+len(`{"type":"",`))
+	out = append(out, `{"type":"`...)
+	out = append(out, t...)
+	out = append(out, '"')
+
+	if data[1] != '}' {
+		out = append(out, ',')
+	}
+
+	return append(out, data[1:]...), nil
+}
+
+func (*BundleEvent) Type() EventType     { return TypeBundle }
+func (*FlowOpened) Type() EventType      { return TypeFlowOpened }
+func (*FlowClosed) Type() EventType      { return TypeFlowClosed }
+func (*DataGap) Type() EventType         { return TypeDataGap }
+func (*DecodeError) Type() EventType     { return TypeDecodeError }
+func (*LatencyMeasured) Type() EventType { return TypeLatencyMeasured }
+func (*RegionDetected) Type() EventType  { return TypeRegionDetected }
+func (*StatsSnapshot) Type() EventType   { return TypeStatsSnapshot }
+func (*OodleStatus) Type() EventType     { return TypeOodleStatus }
+
+func (*BundleEvent) event()     {}
+func (*FlowOpened) event()      {}
+func (*FlowClosed) event()      {}
+func (*DataGap) event()         {}
+func (*DecodeError) event()     {}
+func (*LatencyMeasured) event() {}
+func (*RegionDetected) event()  {}
+func (*StatsSnapshot) event()   {}
+func (*OodleStatus) event()     {}
+
+var (
+	_ json.Marshaler   = (*BundleEvent)(nil)
+	_ json.Unmarshaler = (*BundleEvent)(nil)
+	_ error            = (*DecodeError)(nil)
+)
@@ -7,12 +7,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"sync"
 	"time"
 	"unsafe"
 
 	"github.com/klauspost/compress/zlib"
 	"github.com/sparta142/goblade/oodle"
+	"golang.org/x/exp/slices"
 )
 
 type EncodingType uint8
@@ -25,6 +27,19 @@ const (
 	CompressionOodle = CompressionType(2)
 )
 
+func (c CompressionType) String() string {
+	switch c {
+	case CompressionNone:
+		return "None"
+	case CompressionZlib:
+		return "Zlib"
+	case CompressionOodle:
+		return "Oodle"
+	default:
+		return fmt.Sprint(uint8(c))
+	}
+}
+
 var (
 	// Magic bytes indicating that a Bundle contains IPC segments.
 	IpcMagicBytes = []byte{82, 82, 160, 65, 255, 93, 70, 226, 127, 42, 100, 77, 123, 153, 196, 117}
@@ -34,25 +49,53 @@ var (
 )
 
 var (
-	ErrBadMagicBytes  = errors.New("ffxiv: bad magic bytes")
-	ErrBadCompression = errors.New("ffxiv: bad compression type")
-	ErrNotEnoughData  = errors.New("ffxiv: not enough data")
+	ErrBadMagicBytes   = errors.New("ffxiv: bad magic bytes")
+	ErrBadCompression  = errors.New("ffxiv: bad compression type")
+	ErrNotEnoughData   = errors.New("ffxiv: not enough data")
+	ErrTooLarge        = errors.New("ffxiv: too large")
+	ErrBadLength       = errors.New("ffxiv: bad length")
+	ErrStillCompressed = errors.New("ffxiv: payload is still compressed")
+
+	ErrImplausibleHeader = errors.New("ffxiv: implausible bundle header")
 )
 
+// The default limit on the size of a Bundle, both as sent and once its
+// payload is decompressed.
+const DefaultMaxBundleSize = 1 << 20
+
 const (
-	bundleLengthOffset  = 24
-	bundleLengthSize    = unsafe.Sizeof(*(*uint32)(nil))
-	bundleHeaderSize    = 40
-	maxUncompressedSize = 1 << 16
+	bundleLengthOffset = 24
+	bundleLengthSize   = unsafe.Sizeof(*(*uint32)(nil))
+	bundleHeaderSize   = 40
+
+	// Lengths beyond this are assumed to be garbage, rather than a Bundle
+	// that is merely too large to decode.
+	maxPlausibleLength = 1 << 26
+
+	// The initial capacity of pooled payload buffers.
+	initialPayloadSize = 1 << 16
+)
+
+// How far a Bundle's epoch may be from the time it was captured,
+// allowing for skew between the server's clock and ours.
+const epochTolerance = 24 * time.Hour
+
+// The range of plausible Bundle epochs when the capture time is unknown,
+// from the release of FINAL FANTASY XIV to the year 2100.
+var (
+	minPlausibleEpoch = time.Date(2010, time.September, 22, 0, 0, 0, 0, time.UTC).UnixMilli()
+	maxPlausibleEpoch = time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
 )
 
 // The byte order used by all FFXIV network data.
 var byteOrder = binary.LittleEndian
 
-// Pool of []byte used as scratch space for deserializing Bundle payloads.
+// Pool of *[]byte used as scratch space for deserializing Bundle payloads.
+// Each buffer grows as needed to fit the largest payload it has held.
 var slicePool = sync.Pool{
 	New: func() any {
-		return make([]byte, maxUncompressedSize)
+		buf := make([]byte, 0, initialPayloadSize)
+		return &buf
 	},
 }
 
@@ -64,12 +107,72 @@ type Bundle struct {
 	ConnectionType uint16 `json:"connectionType"`
 
 	// The encoding type of the bundle payload.
-	Encoding EncodingType `json:"-"`
+	Encoding EncodingType `json:"encoding,omitempty"`
+
+	// The compression type of the bundle payload.
+	Compression CompressionType `json:"compression,omitempty"`
 
 	Segments []Segment `json:"segments"`
+
+	// Whether the payload is still compressed, because the Bundle was
+	// decoded with deferred Oodle decompression and no working Oodle
+	// backend. If so, Segments is empty, and Payload and UncompressedLength
+	// hold what Decompress needs to decode them later. Only Oodle payloads
+	// are ever deferred, since the other compression types need no backend.
+	Compressed bool `json:"compressed,omitempty"`
+
+	// The still-compressed payload.
+	Payload []byte `json:"payload,omitempty"`
+
+	// The length of the payload once it is decompressed, as declared in the
+	// header. It is 0 if the header doesn't declare it, as the game does for
+	// uncompressed and zlib payloads.
+	UncompressedLength uint32 `json:"uncompressedLength,omitempty"`
 }
 
+// How a Bundle is decoded.
+type decodeOptions struct {
+	// The lobby session to decrypt segments with, or nil.
+	session *LobbySession
+
+	// The largest Bundle to decode, both as sent and decompressed.
+	maxSize int
+
+	// Decompresses Oodle payloads.
+	oodle oodle.Decompressor
+
+	// Whether to keep Oodle payloads that fail to decompress, rather
+	// than failing to decode their Bundles.
+	deferOodle bool
+
+	// Called with how long each payload took to decompress, or nil.
+	observeDecompress func(CompressionType, time.Duration)
+}
+
+// Gets the options used by UnmarshalBinary.
+func defaultDecodeOptions() decodeOptions {
+	return decodeOptions{maxSize: DefaultMaxBundleSize, oodle: oodle.Current()}
+}
+
+// Decodes a Bundle. Bundles larger than DefaultMaxBundleSize,
+// either as sent or decompressed, are rejected with ErrTooLarge.
 func (b *Bundle) UnmarshalBinary(data []byte) error {
+	opts := defaultDecodeOptions()
+	return b.unmarshal(data, &opts)
+}
+
+// Decodes a Bundle from a lobby connection, decrypting its segments with
+// session. Any SegmentEncryptionInit in the Bundle (re)starts the session.
+func (b *Bundle) UnmarshalWithSession(data []byte, session *LobbySession) error {
+	opts := defaultDecodeOptions()
+	opts.session = session
+
+	return b.unmarshal(data, &opts)
+}
+
+func (b *Bundle) unmarshal(data []byte, opts *decodeOptions) error {
+	maxSize := opts.maxSize
+
 	// Is there enough bytes in data to contain a Bundle header?
 	if len(data) < bundleHeaderSize {
 		return fmt.Errorf("check length for header: %w", ErrNotEnoughData)
@@ -85,58 +188,229 @@ func (b *Bundle) UnmarshalBinary(data []byte) error {
 	// Read the Bundle header
 	b.Epoch = byteOrder.Uint64(data[16:24])
 	b.ConnectionType = byteOrder.Uint16(data[28:30])
-	b.Segments = make([]Segment, byteOrder.Uint16(data[30:32]))
 	b.Encoding = EncodingType(data[32])
+	segmentCount := int(byteOrder.Uint16(data[30:32]))
 
 	// Get the info that describes how to read the payload
-	length := byteOrder.Uint32(data[24:28])
-	compression := CompressionType(data[33])
-	uncompressedLength := byteOrder.Uint32(data[36:40])
-
-	// Is there enough bytes in data to contain the entire Bundle?
-	if len(data) < int(length) {
+	b.Compression = CompressionType(data[33])
+
+	length := PeekBundleLength(data)
+	uncompressedLength := uint64(byteOrder.Uint32(data[36:40]))
+
+	// Is the length sane, and is there enough bytes in data to contain the entire Bundle?
+	switch {
+	case length < bundleHeaderSize:
+		return fmt.Errorf("%w: bundle length %d is shorter than its header", ErrBadLength, length)
+	case length > maxSize:
+		return fmt.Errorf("%w: %d byte bundle exceeds limit of %d", ErrTooLarge, length, maxSize)
+	case len(data) < length:
 		return fmt.Errorf("check length for bundle: %w", ErrNotEnoughData)
+	case uncompressedLength > uint64(maxSize):
+		return fmt.Errorf("%w: %d byte payload exceeds limit of %d", ErrTooLarge, uncompressedLength, maxSize)
 	}
 
-	rental := slicePool.Get()
+	// Grow the scratch buffer to fit the payload, if needed
+	rental := slicePool.Get().(*[]byte) //nolint:forcetypeassert
 	defer slicePool.Put(rental)
 
+	if cap(*rental) < int(uncompressedLength) {
+		*rental = make([]byte, 0, uncompressedLength)
+	}
+
+	b.Compressed = false
+	b.Payload = nil
+	b.UncompressedLength = uint32(uncompressedLength)
+
 	// Decompress the Bundle payload
-	payloadData, err := compression.Decompress(
-		data[bundleHeaderSize:],
-		rental.([]byte)[:uncompressedLength],
+	start := time.Now()
+	payloadData, err := b.Compression.decompress(
+		data[bundleHeaderSize:length],
+		(*rental)[:uncompressedLength],
+		maxSize,
+		opts.oodle,
 	)
+
+	if opts.observeDecompress != nil {
+		opts.observeDecompress(b.Compression, time.Since(start))
+	}
 	if err != nil {
+		if opts.deferOodle && b.Compression == CompressionOodle {
+			// Keep the payload to be decompressed later
+			b.Segments = nil
+			b.Compressed = true
+			b.Payload = slices.Clone(data[bundleHeaderSize:length])
+
+			return nil
+		}
+
 		return fmt.Errorf("decompress payload: %w", err)
 	}
 
+	return b.unmarshalSegments(payloadData, segmentCount, opts.session)
+}
+
+// Reads segmentCount segments from a decompressed payload, which they must
+// fill exactly. If segmentCount is negative, segments are read until the
+// payload is used up.
+func (b *Bundle) unmarshalSegments(payloadData []byte, segmentCount int, session *LobbySession) error {
+	// Every segment needs at least a header, so don't trust the count blindly
+	if segmentCount*segmentHeaderSize > len(payloadData) {
+		return fmt.Errorf("%w: %d segments in a %d byte payload", ErrBadLength, segmentCount, len(payloadData))
+	}
+
 	// Read all segments from the decompressed payload
-	for i := range b.Segments {
-		segment := &b.Segments[i]
+	capacity := segmentCount
+	if capacity < 0 {
+		capacity = 0
+	}
+
+	b.Segments = make([]Segment, 0, capacity)
 
-		if err := segment.UnmarshalBinary(payloadData); err != nil {
+	for len(b.Segments) < segmentCount || segmentCount < 0 && len(payloadData) > 0 {
+		var segment Segment
+
+		if err := segment.unmarshal(payloadData, session); err != nil {
 			return fmt.Errorf("read segment: %w", err)
 		}
 
+		b.Segments = append(b.Segments, segment)
+
 		// Advance payloadData by the size of the Segment we just read
 		payloadData = payloadData[segment.Length:]
 	}
 
 	// Sanity check: the entire payload should have been consumed
 	if len(payloadData) != 0 {
-		panic("did not consume entire payload")
+		return fmt.Errorf("%w: %d bytes left after the last segment", ErrBadLength, len(payloadData))
 	}
 
 	return nil
 }
 
+// Decompresses the payload of a Bundle that was decoded with deferred
+// Oodle decompression, filling in its Segments. It does nothing if the
+// payload isn't compressed. Payloads that decompress to more than maxSize
+// bytes, such as the Decoder's SetMaxBundleSize, are rejected with
+// ErrTooLarge. The segments of lobby Bundles aren't decrypted.
+func (b *Bundle) Decompress(od oodle.Decompressor, maxSize int) error {
+	if !b.Compressed {
+		return nil
+	}
+
+	if int64(b.UncompressedLength) > int64(maxSize) {
+		return fmt.Errorf("%w: %d byte payload exceeds limit of %d",
+			ErrTooLarge, b.UncompressedLength, maxSize)
+	}
+
+	payloadData, err := CompressionOodle.decompress(
+		b.Payload,
+		make([]byte, b.UncompressedLength),
+		maxSize,
+		od,
+	)
+	if err != nil {
+		return fmt.Errorf("decompress payload: %w", err)
+	}
+
+	if err := b.unmarshalSegments(payloadData, -1, nil); err != nil {
+		return err
+	}
+
+	b.Compression = CompressionOodle
+	b.Compressed = false
+	b.Payload = nil
+
+	return nil
+}
+
+// Encodes the Bundle as it would appear on the wire, compressing the
+// payload according to b.Compression. Bundles whose payloads are still
+// compressed can't be encoded until they are decompressed. The uncompressed
+// length is only declared in the header if b.UncompressedLength is, so that
+// decoded Bundles encode to the bytes they were decoded from. Bundles that
+// contain only keep-alive segments are written with KeepAliveMagicBytes, all
+// others with IpcMagicBytes.
+func (b *Bundle) MarshalBinary() ([]byte, error) {
+	if b.Compressed {
+		return nil, ErrStillCompressed
+	}
+
+	if len(b.Segments) > math.MaxUint16 {
+		return nil, fmt.Errorf("%w: %d segments", ErrTooLarge, len(b.Segments))
+	}
+
+	// Encode all segments into the (uncompressed) payload
+	var payload []byte
+
+	for i := range b.Segments {
+		var err error
+		if payload, err = b.Segments[i].appendBinary(payload); err != nil {
+			return nil, fmt.Errorf("write segment: %w", err)
+		}
+	}
+
+	compressed, err := b.Compression.Compress(payload)
+	if err != nil {
+		return nil, fmt.Errorf("compress payload: %w", err)
+	}
+
+	length := bundleHeaderSize + len(compressed)
+	if uint64(length) > math.MaxUint32 || uint64(len(payload)) > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: %d byte bundle", ErrTooLarge, length)
+	}
+
+	// Write the Bundle header, followed by the payload
+	data := make([]byte, bundleHeaderSize, length)
+	copy(data[0:16], b.magicBytes())
+	byteOrder.PutUint64(data[16:24], b.Epoch)
+	byteOrder.PutUint32(data[24:28], uint32(length))
+	byteOrder.PutUint16(data[28:30], b.ConnectionType)
+	byteOrder.PutUint16(data[30:32], uint16(len(b.Segments)))
+	data[32] = byte(b.Encoding)
+	data[33] = byte(b.Compression)
+	byteOrder.PutUint16(data[34:36], 0) // Unused
+
+	if b.UncompressedLength != 0 {
+		byteOrder.PutUint32(data[36:40], uint32(len(payload)))
+	}
+
+	return append(data, compressed...), nil
+}
+
+// Gets the magic bytes that this Bundle should be written with.
+func (b *Bundle) magicBytes() []byte {
+	if len(b.Segments) == 0 {
+		return IpcMagicBytes
+	}
+
+	for i := range b.Segments {
+		if t := b.Segments[i].Type; t != SegmentClientKeepAlive && t != SegmentServerKeepAlive {
+			return IpcMagicBytes
+		}
+	}
+
+	return KeepAliveMagicBytes
+}
+
 // Get the UTC time that this Bundle was sent.
 func (b *Bundle) Time() time.Time {
 	return time.UnixMilli(int64(b.Epoch)).UTC()
 }
 
 // Decompresses src according to this compression type. dst may not be used.
+// Payloads that decompress to more than DefaultMaxBundleSize bytes are
+// rejected with ErrTooLarge. Oodle payloads are decompressed with the
+// current oodle.Decompressor, into all of dst.
 func (c CompressionType) Decompress(src, dst []byte) ([]byte, error) {
+	return c.decompress(src, dst, DefaultMaxBundleSize, oodle.Current())
+}
+
+// Decompresses like Decompress, but with a specific oodle.Decompressor.
+func (c CompressionType) DecompressWith(src, dst []byte, od oodle.Decompressor) ([]byte, error) {
+	return c.decompress(src, dst, DefaultMaxBundleSize, od)
+}
+
+func (c CompressionType) decompress(src, dst []byte, maxSize int, od oodle.Decompressor) ([]byte, error) {
 	switch c {
 	case CompressionNone:
 		return src, nil
@@ -148,15 +422,20 @@ func (c CompressionType) Decompress(src, dst []byte) ([]byte, error) {
 		}
 		defer reader.Close()
 
-		decompressed, err := io.ReadAll(reader)
-		if err != nil {
+		// Read one byte more than allowed, to tell if there was too much
+		buf := bytes.NewBuffer(dst[:0])
+		if _, err := buf.ReadFrom(io.LimitReader(reader, int64(maxSize)+1)); err != nil {
 			return nil, fmt.Errorf("read all from zlib reader: %w", err)
 		}
 
-		return decompressed, nil
+		if buf.Len() > maxSize {
+			return nil, fmt.Errorf("%w: zlib payload exceeds limit of %d", ErrTooLarge, maxSize)
+		}
+
+		return buf.Bytes(), nil
 
 	case CompressionOodle:
-		if err := oodle.Decode(src, dst); err != nil {
+		if err := od.Decode(src, dst); err != nil {
 			return nil, fmt.Errorf("oodle decode: %w", err)
 		}
 
@@ -167,12 +446,114 @@ func (c CompressionType) Decompress(src, dst []byte) ([]byte, error) {
 	}
 }
 
+// Compresses src according to this compression type. Oodle compression
+// is not supported.
+func (c CompressionType) Compress(src []byte) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return src, nil
+
+	case CompressionZlib:
+		var buf bytes.Buffer
+
+		writer := zlib.NewWriter(&buf)
+		if _, err := writer.Write(src); err != nil {
+			return nil, fmt.Errorf("write to zlib writer: %w", err)
+		}
+
+		if err := writer.Close(); err != nil {
+			return nil, fmt.Errorf("close zlib writer: %w", err)
+		}
+
+		return buf.Bytes(), nil
+
+	default:
+		return nil, ErrBadCompression
+	}
+}
+
+// Checks whether data begins with a plausible Bundle header, which tells
+// the start of a real Bundle apart from magic bytes that happen to appear
+// elsewhere in the stream. If captureTime is not zero, the epoch must also
+// be close to it. Only the header is checked, not the payload, and lengths
+// beyond DefaultMaxBundleSize are still plausible.
+func CheckBundleHeader(data []byte, captureTime time.Time) error {
+	if len(data) < bundleHeaderSize {
+		return fmt.Errorf("check length for header: %w", ErrNotEnoughData)
+	}
+
+	if !bytes.HasPrefix(data, IpcMagicBytes) && !bytes.HasPrefix(data, KeepAliveMagicBytes) {
+		return ErrBadMagicBytes
+	}
+
+	epoch := byteOrder.Uint64(data[16:24])
+	length := PeekBundleLength(data)
+	segments := int(byteOrder.Uint16(data[30:32]))
+	compression := CompressionType(data[33])
+	uncompressedLength := int(byteOrder.Uint32(data[36:40]))
+
+	minEpoch, maxEpoch := minPlausibleEpoch, maxPlausibleEpoch
+	if !captureTime.IsZero() {
+		minEpoch = captureTime.Add(-epochTolerance).UnixMilli()
+		maxEpoch = captureTime.Add(epochTolerance).UnixMilli()
+	}
+
+	switch {
+	case epoch > math.MaxInt64 || int64(epoch) < minEpoch || int64(epoch) > maxEpoch:
+		return fmt.Errorf("%w: epoch %d", ErrImplausibleHeader, epoch)
+	case length < bundleHeaderSize || length > maxPlausibleLength:
+		return fmt.Errorf("%w: length %d", ErrImplausibleHeader, length)
+	case segments == 0:
+		return fmt.Errorf("%w: no segments", ErrImplausibleHeader)
+	case compression > CompressionOodle:
+		return fmt.Errorf("%w: compression type %s", ErrImplausibleHeader, compression)
+	}
+
+	// Uncompressed payloads are their own uncompressed length,
+	// and only Oodle payloads are required to state it
+	switch compression {
+	case CompressionNone:
+		if uncompressedLength != 0 && uncompressedLength != length-bundleHeaderSize {
+			return fmt.Errorf("%w: uncompressed length %d", ErrImplausibleHeader, uncompressedLength)
+		}
+
+		uncompressedLength = length - bundleHeaderSize
+
+	case CompressionOodle:
+		if uncompressedLength == 0 {
+			return fmt.Errorf("%w: uncompressed length %d", ErrImplausibleHeader, uncompressedLength)
+		}
+	}
+
+	// Every segment needs at least its header in the uncompressed payload
+	switch {
+	case uncompressedLength > maxPlausibleLength:
+		return fmt.Errorf("%w: uncompressed length %d", ErrImplausibleHeader, uncompressedLength)
+	case uncompressedLength != 0 && segments*segmentHeaderSize > uncompressedLength:
+		return fmt.Errorf("%w: %d segments in %d bytes", ErrImplausibleHeader, segments, uncompressedLength)
+	}
+
+	return nil
+}
+
+// Gets the length of the Bundle that data begins with,
+// or -1 if data is too short to contain it.
 func PeekBundleLength(data []byte) int {
 	if len(data) < int(bundleLengthOffset+bundleLengthSize) {
 		return -1
 	}
 
-	return int(byteOrder.Uint16(data[bundleLengthOffset:]))
+	length := byteOrder.Uint32(data[bundleLengthOffset:])
+
+	// Don't overflow on 32-bit platforms
+	if uint64(length) > math.MaxInt {
+		return math.MaxInt
+	}
+
+	return int(length)
 }
 
-var _ encoding.BinaryUnmarshaler = (*Bundle)(nil)
+var (
+	_ encoding.BinaryMarshaler   = (*Bundle)(nil)
+	_ encoding.BinaryUnmarshaler = (*Bundle)(nil)
+)
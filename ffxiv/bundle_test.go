@@ -1,11 +1,16 @@
 package ffxiv_test
 
 import (
+	"encoding/binary"
 	"testing"
 	"time"
 
+	"github.com/goccy/go-json"
 	"github.com/sparta142/goblade/ffxiv"
+	"github.com/sparta142/goblade/oodle"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slices"
 )
 
 var uncompressedBundleData = []byte{
@@ -158,6 +163,208 @@ func TestUnmarshalBinary_NonCompressedIpc(t *testing.T) {
 	assert.EqualValues(1624314019, ipc.Epoch)
 }
 
+func TestMarshalBinary_NonCompressedIpc(t *testing.T) {
+	t.Parallel()
+
+	var bundle ffxiv.Bundle
+	require.NoError(t, bundle.UnmarshalBinary(uncompressedBundleData))
+
+	// Uncompressed bundles should round-trip byte for byte
+	data, err := bundle.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, uncompressedBundleData, data)
+}
+
+func TestMarshalBinary_FromJSON(t *testing.T) {
+	t.Parallel()
+
+	var bundle ffxiv.Bundle
+	require.NoError(t, bundle.UnmarshalBinary(uncompressedBundleData))
+
+	// Bundles decoded from their JSON should encode to the original bytes
+	text, err := json.Marshal(&bundle)
+	require.NoError(t, err)
+
+	var decoded ffxiv.Bundle
+	require.NoError(t, json.Unmarshal(text, &decoded))
+
+	data, err := decoded.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, uncompressedBundleData, data)
+}
+
+func TestMarshalBinary_CompressedIpc(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	var bundle ffxiv.Bundle
+	require.NoError(t, bundle.UnmarshalBinary(compressedBundleData))
+
+	data, err := bundle.MarshalBinary()
+	require.NoError(t, err)
+
+	// The zlib stream may differ from the game's, but everything else shouldn't
+	assert.Equal(compressedBundleData[:24], data[:24])
+	assert.Equal(compressedBundleData[28:40], data[28:40])
+	assert.EqualValues(len(data), ffxiv.PeekBundleLength(data))
+
+	var decoded ffxiv.Bundle
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(bundle, decoded)
+}
+
+func TestMarshalBinary_UncompressedLength(t *testing.T) {
+	t.Parallel()
+
+	var bundle ffxiv.Bundle
+	require.NoError(t, bundle.UnmarshalBinary(uncompressedBundleData))
+	require.Zero(t, bundle.UncompressedLength)
+
+	// A declared length is written as the length of the current payload
+	bundle.UncompressedLength = 1
+
+	for _, compression := range []ffxiv.CompressionType{ffxiv.CompressionNone, ffxiv.CompressionZlib} {
+		bundle.Compression = compression
+
+		data, err := bundle.MarshalBinary()
+		require.NoError(t, err)
+		assert.EqualValues(t, len(uncompressedBundleData)-40, binary.LittleEndian.Uint32(data[36:40]), compression)
+
+		var decoded ffxiv.Bundle
+		require.NoError(t, decoded.UnmarshalBinary(data))
+		assert.EqualValues(t, len(uncompressedBundleData)-40, decoded.UncompressedLength, compression)
+	}
+}
+
+func TestMarshalBinary_KeepAliveMagic(t *testing.T) {
+	t.Parallel()
+
+	bundle := ffxiv.Bundle{
+		Segments: []ffxiv.Segment{
+			{Type: ffxiv.SegmentClientKeepAlive, Payload: &ffxiv.KeepAlive{ID: 1, Epoch: 2}},
+		},
+	}
+
+	data, err := bundle.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, ffxiv.KeepAliveMagicBytes, data[:16])
+}
+
+func TestMarshalBinary_Oodle(t *testing.T) {
+	t.Parallel()
+
+	bundle := ffxiv.Bundle{Compression: ffxiv.CompressionOodle}
+	_, err := bundle.MarshalBinary()
+	assert.ErrorIs(t, err, ffxiv.ErrBadCompression)
+}
+
+// Pretends to be Oodle by inverting every bit.
+type fakeOodle struct{}
+
+func (fakeOodle) NewDecoder() (oodle.Decoder, error) { //nolint:ireturn
+	return fakeOodle{}, nil
+}
+
+func (fakeOodle) Close() error {
+	return nil
+}
+
+func (fakeOodle) Decode(comp, raw []byte) error {
+	if len(comp) != len(raw) {
+		return oodle.ErrDecompressionFailed
+	}
+
+	for i, b := range comp {
+		raw[i] = ^b
+	}
+
+	return nil
+}
+
+// Compresses an uncompressed Bundle with fakeOodle.
+func fakeOodleBundle(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	data = slices.Clone(data)
+	data[33] = byte(ffxiv.CompressionOodle)
+	binary.LittleEndian.PutUint32(data[36:40], uint32(len(data)-40))
+
+	for i := 40; i < len(data); i++ {
+		data[i] = ^data[i]
+	}
+
+	return data
+}
+
+func TestDecompressWith_Oodle(t *testing.T) {
+	t.Parallel()
+
+	raw, err := ffxiv.CompressionOodle.DecompressWith([]byte{0x00, 0xff}, make([]byte, 2), fakeOodle{})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 0x00}, raw)
+
+	_, err = ffxiv.CompressionOodle.DecompressWith([]byte{0x00, 0xff}, make([]byte, 3), fakeOodle{})
+	assert.ErrorIs(t, err, oodle.ErrDecompressionFailed)
+}
+
+//nolint:paralleltest // Changes the current Oodle decompressor
+func TestUnmarshalBinary_Oodle(t *testing.T) {
+	data := fakeOodleBundle(t, uncompressedBundleData)
+
+	var bundle ffxiv.Bundle
+	assert.ErrorIs(t, bundle.UnmarshalBinary(data), oodle.ErrPlatformNotSupported)
+
+	oodle.Use(fakeOodle{})
+	t.Cleanup(func() { oodle.Use(nil) })
+
+	require.NoError(t, bundle.UnmarshalBinary(data))
+	assert.Equal(t, ffxiv.CompressionOodle, bundle.Compression)
+
+	var expected ffxiv.Bundle
+	require.NoError(t, expected.UnmarshalBinary(uncompressedBundleData))
+	assert.Equal(t, expected.Segments, bundle.Segments)
+}
+
+func FuzzBundle_RoundTrip(f *testing.F) {
+	f.Add(uint64(1624314019411), uint16(0), uint32(0x106d2563), uint16(0x009c), []byte("payload"), false)
+	f.Add(uint64(1624314020072), uint16(1), uint32(0x106d2563), uint16(0x038f), []byte{}, true)
+
+	f.Fuzz(func(t *testing.T, epoch uint64, connType uint16, actor uint32, opcode uint16, data []byte, zlib bool) {
+		bundle := ffxiv.Bundle{
+			Epoch:          epoch,
+			ConnectionType: connType,
+			Encoding:       1,
+			Compression:    ffxiv.CompressionNone,
+			Segments: []ffxiv.Segment{
+				{
+					Length:  uint32(16 + 16 + len(data)),
+					Source:  actor,
+					Target:  actor,
+					Type:    ffxiv.SegmentIpc,
+					Payload: &ffxiv.Ipc{Magic: 0x0014, Type: opcode, Epoch: uint32(epoch / 1000), Data: data},
+				},
+			},
+		}
+
+		if zlib {
+			bundle.Compression = ffxiv.CompressionZlib
+		}
+
+		encoded, err := bundle.MarshalBinary()
+		require.NoError(t, err)
+
+		var decoded ffxiv.Bundle
+		require.NoError(t, decoded.UnmarshalBinary(encoded))
+
+		// A nil and empty Data are indistinguishable on the wire
+		if len(data) == 0 {
+			decoded.Segments[0].Payload.(*ffxiv.Ipc).Data = data
+		}
+
+		assert.Equal(t, bundle, decoded)
+	})
+}
+
 func Benchmark_Bundle_UnmarshalBinary_Uncompressed(b *testing.B) {
 	var bundle ffxiv.Bundle
 	for n := 0; n < b.N; n++ {
@@ -171,3 +378,108 @@ func Benchmark_Bundle_UnmarshalBinary_Compressed(b *testing.B) {
 		_ = bundle.UnmarshalBinary(compressedBundleData)
 	}
 }
+
+func TestCheckBundleHeader(t *testing.T) {
+	t.Parallel()
+
+	// Gets a copy of the uncompressed bundle with a modified header
+	modified := func(modify func(header []byte)) []byte {
+		data := append([]byte(nil), uncompressedBundleData...)
+		modify(data)
+
+		return data
+	}
+
+	sent := time.UnixMilli(1624314019411)
+
+	tests := []struct {
+		name        string
+		data        []byte
+		captureTime time.Time
+		wantErr     error
+	}{
+		{"Uncompressed", uncompressedBundleData, time.Time{}, nil},
+		{"Compressed", compressedBundleData, time.Time{}, nil},
+		{"CapturedNearEpoch", uncompressedBundleData, sent.Add(time.Hour), nil},
+		{"CapturedFarFromEpoch", uncompressedBundleData, sent.Add(48 * time.Hour), ffxiv.ErrImplausibleHeader},
+		{"TooShort", uncompressedBundleData[:39], time.Time{}, ffxiv.ErrNotEnoughData},
+		{"BadMagic", modified(func(h []byte) { h[0] = 0 }), time.Time{}, ffxiv.ErrBadMagicBytes},
+		{"ZeroEpoch", modified(func(h []byte) { copy(h[16:24], make([]byte, 8)) }), time.Time{}, ffxiv.ErrImplausibleHeader},
+		{"ShortLength", modified(func(h []byte) { h[24], h[25] = 39, 0 }), time.Time{}, ffxiv.ErrImplausibleHeader},
+		{"LongLength", modified(func(h []byte) { h[27] = 0x10 }), time.Time{}, ffxiv.ErrImplausibleHeader},
+		{"OversizedLength", modified(func(h []byte) { h[26] = 0x10 }), time.Time{}, nil},
+		{"NoSegments", modified(func(h []byte) { h[30] = 0 }), time.Time{}, ffxiv.ErrImplausibleHeader},
+		{"TooManySegments", modified(func(h []byte) { h[30] = 100 }), time.Time{}, ffxiv.ErrImplausibleHeader},
+		{"BadCompression", modified(func(h []byte) { h[33] = 3 }), time.Time{}, ffxiv.ErrImplausibleHeader},
+		{"OodleWithoutLength", modified(func(h []byte) { h[33] = 2 }), time.Time{}, ffxiv.ErrImplausibleHeader},
+		{"WrongUncompressedLength", modified(func(h []byte) { h[36] = 1 }), time.Time{}, ffxiv.ErrImplausibleHeader},
+		{"CorrectUncompressedLength", modified(func(h []byte) { h[36] = 248 }), time.Time{}, nil},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := ffxiv.CheckBundleHeader(tt.data, tt.captureTime)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnmarshalBinary_Malformed(t *testing.T) {
+	t.Parallel()
+
+	// Gets a copy of the uncompressed bundle with modified data
+	modified := func(modify func(data []byte) []byte) []byte {
+		return modify(append([]byte(nil), uncompressedBundleData...))
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"ShortLength", modified(func(d []byte) []byte { d[24] = 39; d[25] = 0; return d }), ffxiv.ErrBadLength},
+		{"Truncated", uncompressedBundleData[:200], ffxiv.ErrNotEnoughData},
+		{"HugeUncompressedLength", modified(func(d []byte) []byte { d[38] = 0xff; return d }), ffxiv.ErrTooLarge},
+		{"TooManySegments", modified(func(d []byte) []byte { d[30] = 0xff; return d }), ffxiv.ErrBadLength},
+		{"ShortSegment", modified(func(d []byte) []byte { d[40] = 8; return d }), ffxiv.ErrBadLength},
+		{"LongSegment", modified(func(d []byte) []byte { d[41] = 0x10; return d }), ffxiv.ErrNotEnoughData},
+		{"TrailingData", modified(func(d []byte) []byte { d[40] -= 8; return d }), ffxiv.ErrBadLength},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var bundle ffxiv.Bundle
+			assert.ErrorIs(t, bundle.UnmarshalBinary(tt.data), tt.wantErr)
+		})
+	}
+}
+
+func FuzzBundle_UnmarshalBinary(f *testing.F) {
+	f.Add(uncompressedBundleData)
+	f.Add(compressedBundleData)
+	f.Add(encryptionInitBundleData)
+	f.Add(encryptedIpcBundleData)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var bundle ffxiv.Bundle
+		if err := bundle.UnmarshalBinary(data); err != nil {
+			return
+		}
+
+		// Anything that decodes should encode again
+		_, err := bundle.MarshalBinary()
+		assert.NoError(t, err)
+	})
+}
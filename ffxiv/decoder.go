@@ -0,0 +1,495 @@
+package ffxiv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sparta142/goblade/oodle"
+)
+
+const (
+	// The length of the magic bytes at the start of every Bundle.
+	magicSize = 16
+
+	// The minimum number of bytes to read from the underlying reader at once.
+	minReadSize = 4096
+)
+
+// ResyncError is returned by Decoder.Next when it had to skip data to find
+// the start of the next Bundle. It is not fatal, and the next call to Next
+// continues decoding from the Bundle that was found.
+type ResyncError struct {
+	// The stream offset of the first skipped byte.
+	Offset int64
+
+	// The number of bytes that were skipped.
+	Skipped int
+
+	// Whether the skipped bytes are next to data that was lost,
+	// as reported by MarkDataLost.
+	Lost bool
+}
+
+func (e *ResyncError) Error() string {
+	if e.Lost {
+		return fmt.Sprintf("ffxiv: skipped %d bytes at offset %d after data was lost", e.Skipped, e.Offset)
+	}
+
+	return fmt.Sprintf("ffxiv: skipped %d bytes at offset %d to resynchronize", e.Skipped, e.Offset)
+}
+
+// DecodeError is returned by Decoder.Next when a Bundle was found but could
+// not be decoded. It is not fatal, and the Bundle is skipped.
+type DecodeError struct {
+	// The stream offset of the Bundle.
+	Offset int64
+
+	// The length of the Bundle, in bytes.
+	Length int
+
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("ffxiv: decode %d byte bundle at offset %d: %v", e.Length, e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Decoder reads Bundles from a stream of FFXIV data,
+// such as one direction of a reassembled TCP connection.
+type Decoder struct {
+	r    io.Reader
+	opts decodeOptions
+
+	store     []byte  // The backing array of buf
+	buf       []byte  // Buffered data that hasn't been decoded yet
+	pos       int64   // The stream offset of buf[0]
+	err       error   // The error that ended reading, if any
+	skipped   int     // The number of bytes skipped since the last Bundle
+	skipStart int64   // The stream offset of the first skipped byte
+	lost      bool    // Whether the skipped bytes include lost data
+	discard   int     // The number of bytes left in an oversized Bundle
+	gaps      []int64 // Stream offsets of lost data at or after pos, in order
+	marks     []timeMark
+	lastTime  time.Time // The capture time of the last Bundle
+	lastPos   int64     // The stream offset of the last Bundle
+
+	// Set from other goroutines
+	mu           sync.Mutex
+	pendingGaps  []int64
+	pendingMarks []timeMark
+}
+
+// Records the capture time of the data from a stream offset onwards.
+type timeMark struct {
+	offset int64
+	time   time.Time
+}
+
+// Creates a Decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r, opts: defaultDecodeOptions()}
+}
+
+// Sets the lobby session used to decrypt Bundles. If it isn't called,
+// lobby Bundles are decoded without decryption.
+func (d *Decoder) SetSession(session *LobbySession) {
+	d.opts.session = session
+}
+
+// Sets the Decompressor used for Oodle-compressed Bundles, such as an
+// oodle.Decoder for this stream alone. If it isn't called, the shared
+// decoder behind oodle.Decode is used.
+func (d *Decoder) SetOodle(od oodle.Decompressor) {
+	d.opts.oodle = od
+}
+
+// Sets whether Oodle-compressed Bundles that fail to decompress, such as
+// when there is no working Oodle backend, are still returned with their
+// payloads compressed, to be decompressed later by Bundle.Decompress.
+func (d *Decoder) SetDeferOodle(deferOodle bool) {
+	d.opts.deferOodle = deferOodle
+}
+
+// Sets a function to call with how long each Bundle's payload took to
+// decompress, whether or not that succeeded. If f is nil, nothing is called.
+func (d *Decoder) SetDecompressObserver(f func(c CompressionType, elapsed time.Duration)) {
+	d.opts.observeDecompress = f
+}
+
+// Sets the largest Bundle that will be decoded, both as sent and once its
+// payload is decompressed. Larger Bundles are discarded without being
+// buffered, and reported with a *DecodeError that wraps ErrTooLarge.
+// The default is DefaultMaxBundleSize.
+func (d *Decoder) SetMaxBundleSize(n int) {
+	d.opts.maxSize = n
+}
+
+// Records that the data written to the stream from the given offset onwards
+// was captured at t, until the next call. Capture times are used to reject
+// Bundle headers with implausible epochs, and are reported by CaptureTime.
+// Without them, only epochs far outside the game's lifetime are rejected.
+// It is safe to call from other goroutines.
+func (d *Decoder) SetCaptureTime(offset int64, t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pendingMarks = append(d.pendingMarks, timeMark{offset, t})
+}
+
+// Gets the time that the end of the last Bundle returned by Next was
+// captured, or the zero time if it is unknown.
+func (d *Decoder) CaptureTime() time.Time {
+	return d.lastTime
+}
+
+// Gets the stream offset of the last Bundle returned by Next.
+func (d *Decoder) Offset() int64 {
+	return d.lastPos
+}
+
+// Marks that data is missing from the stream (for example, due to packet
+// loss) immediately before the given stream offset, which is the number
+// of bytes read from the underlying reader before the gap. It must be
+// called before the data that follows the gap can be read. It is safe to
+// call from other goroutines.
+//
+// There are 3 failure modes to be aware of when considering lost bytes:
+//  1. The magic bytes were (partially) lost, so the Bundle they started
+//     will not be found and *not* cause any issues.
+//  2. Part of the payload (i.e., not the magic bytes) was lost, so the
+//     remaining data would be misinterpreted as part of the original Bundle.
+//     This can cause major issues due to "unaligned" Bundle decoding, and
+//     probably an error of some sort after a sequence of invalid Bundles.
+//  3. The entire Bundle was sent as one TCP segment, and was completely lost.
+//     This will also *not* cause any issues with misinterpreting the stream.
+//
+// To protect against the second failure mode, a Bundle that spans a gap is
+// skipped, and decoding resumes at the first valid header after the gap.
+func (d *Decoder) MarkDataLost(offset int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pendingGaps = append(d.pendingGaps, offset)
+}
+
+// Reads and decodes the next Bundle from the stream.
+//
+// Next returns io.EOF at the end of the stream, and a *ResyncError whenever
+// data had to be skipped to find the next Bundle. Errors from decoding a
+// Bundle are returned as a *DecodeError, and are also not fatal: the Bundle
+// is skipped and Next can be called again. Errors from the underlying reader are returned once all buffered
+// data has been used.
+func (d *Decoder) Next() (Bundle, error) {
+	for {
+		d.collect()
+		d.discardOversized()
+
+		// Find the start of the next Bundle
+		idx := d.indexHeader()
+
+		switch {
+		case d.discard > 0:
+			// Still waiting for the end of an oversized Bundle
+
+		case idx == -1:
+			// Keep anything that could be the start of the magic bytes
+			if n := len(d.buf) - (magicSize - 1); n > 0 {
+				d.skip(n)
+			}
+
+		default:
+			d.skip(idx)
+
+			// Report anything that was skipped once we know the header is valid
+			if d.skipped > 0 && len(d.buf) >= bundleHeaderSize {
+				return Bundle{}, d.resync()
+			}
+
+			// Look for the next Bundle in what's already buffered,
+			// rather than waiting on the reader for more
+			if d.skipSpannedGap() {
+				continue
+			}
+
+			bundle, ok, err := d.decodeBuffered()
+			if ok {
+				return bundle, err
+			}
+		}
+
+		if d.err != nil {
+			return Bundle{}, d.finish()
+		}
+
+		d.fill()
+	}
+}
+
+// Gets the index of the first Bundle header in the buffer that is plausible,
+// or that is too short to tell yet, or -1 if there are none.
+func (d *Decoder) indexHeader() int {
+	// We explicitly check index 0 first since the magic bytes will always
+	// be there unless something went wrong. When we're correct,
+	// this function will run about 11x faster (according to pprof).
+	if bytes.HasPrefix(d.buf, IpcMagicBytes) || bytes.HasPrefix(d.buf, KeepAliveMagicBytes) {
+		if d.plausibleAt(0) {
+			return 0
+		}
+	}
+
+	// Remember where each magic is next, so that each one is only searched
+	// for past its last match. Otherwise, a run of implausible matches, such
+	// as zeros, would be searched again for every match in it.
+	magics := [...][]byte{IpcMagicBytes, KeepAliveMagicBytes}
+
+	var next [len(magics)]int
+	for i, magic := range magics {
+		next[i] = indexFrom(d.buf, magic, 0)
+	}
+
+	for {
+		idx := -1
+		for _, n := range next {
+			if n != -1 && (idx == -1 || n < idx) {
+				idx = n
+			}
+		}
+
+		if idx == -1 || d.plausibleAt(idx) {
+			return idx
+		}
+
+		for i, magic := range magics {
+			if next[i] == idx {
+				next[i] = indexFrom(d.buf, magic, idx+1)
+			}
+		}
+	}
+}
+
+// Checks whether the buffer has a plausible Bundle header at idx,
+// or too little data after idx to tell yet.
+func (d *Decoder) plausibleAt(idx int) bool {
+	captureTime := d.timeAt(d.pos + int64(idx))
+	return len(d.buf)-idx < bundleHeaderSize || CheckBundleHeader(d.buf[idx:], captureTime) == nil
+}
+
+// Attempts to decode a Bundle from the front of the buffer, which must begin
+// with a plausible header. ok is false if more data is needed to decode it.
+func (d *Decoder) decodeBuffered() (bundle Bundle, ok bool, err error) {
+	if len(d.buf) < bundleHeaderSize {
+		return Bundle{}, false, nil
+	}
+
+	length := PeekBundleLength(d.buf)
+	offset := d.pos
+
+	// Don't buffer a Bundle that's too large to decode, just report it
+	if length > d.opts.maxSize {
+		d.discard = length
+		d.lastTime = d.timeAt(offset)
+		d.discardOversized()
+
+		err := fmt.Errorf("%w: %d byte bundle exceeds limit of %d", ErrTooLarge, length, d.opts.maxSize)
+
+		return Bundle{}, true, &DecodeError{Offset: offset, Length: length, Err: err}
+	}
+
+	if length > len(d.buf) {
+		return Bundle{}, false, nil
+	}
+
+	err = bundle.unmarshal(d.buf[:length], &d.opts)
+	d.lastTime = d.timeAt(offset + int64(length) - 1)
+	d.lastPos = offset
+	d.advance(length)
+
+	if err != nil {
+		return Bundle{}, true, &DecodeError{Offset: offset, Length: length, Err: err}
+	}
+
+	return bundle, true, nil
+}
+
+// Skips to where the data resumes if the Bundle at the front of the buffer
+// spans a gap, since it is missing data. Oversized Bundles are left to
+// decodeBuffered, which discards them up to the gap. It reports whether
+// anything was skipped.
+func (d *Decoder) skipSpannedGap() bool {
+	if len(d.buf) < bundleHeaderSize {
+		return false
+	}
+
+	length := PeekBundleLength(d.buf)
+	if length > d.opts.maxSize {
+		return false
+	}
+
+	gap := d.firstGap()
+	if gap == -1 || gap >= d.pos+int64(length) {
+		return false
+	}
+
+	d.skip(int(gap - d.pos))
+
+	return true
+}
+
+// Discards as much of an oversized Bundle as is buffered. If data was lost
+// within the Bundle, discarding stops at the gap so that decoding can
+// resume from there.
+func (d *Decoder) discardOversized() {
+	if d.discard == 0 {
+		return
+	}
+
+	n := d.discard
+	if n > len(d.buf) {
+		n = len(d.buf)
+	}
+
+	if gap := d.firstGap(); gap != -1 && gap-d.pos <= int64(n) {
+		n = int(gap - d.pos)
+		d.discard = n
+	}
+
+	d.advance(n)
+	d.discard -= n
+}
+
+// Called once the reader has failed and no more Bundles can be decoded.
+func (d *Decoder) finish() error {
+	if len(d.buf) > 0 {
+		d.skip(len(d.buf))
+	}
+
+	if d.skipped > 0 {
+		return d.resync()
+	}
+
+	return d.err
+}
+
+// Discards n bytes from the front of the buffer.
+func (d *Decoder) skip(n int) {
+	if n == 0 {
+		return
+	}
+
+	if d.skipped == 0 {
+		d.skipStart = d.pos
+	}
+
+	// Are the skipped bytes next to a gap?
+	if len(d.gaps) > 0 && d.gaps[0] <= d.pos+int64(n) {
+		d.lost = true
+	}
+
+	d.skipped += n
+	d.advance(n)
+}
+
+// Consumes n bytes from the front of the buffer.
+func (d *Decoder) advance(n int) {
+	d.buf = d.buf[n:]
+	d.pos += int64(n)
+
+	// Forget gaps that are behind us, and capture times that no longer apply
+	for len(d.gaps) > 0 && d.gaps[0] < d.pos {
+		d.gaps = d.gaps[1:]
+	}
+
+	for len(d.marks) > 1 && d.marks[1].offset <= d.pos {
+		d.marks = d.marks[1:]
+	}
+}
+
+// Gets the capture time of the data at the given stream offset,
+// or the zero time if it is unknown.
+func (d *Decoder) timeAt(offset int64) time.Time {
+	var t time.Time
+
+	for _, mark := range d.marks {
+		if mark.offset > offset {
+			break
+		}
+
+		t = mark.time
+	}
+
+	return t
+}
+
+// Gets the offset of the first gap strictly after the front of the buffer,
+// or -1 if there are none.
+func (d *Decoder) firstGap() int64 {
+	for _, gap := range d.gaps {
+		if gap > d.pos {
+			return gap
+		}
+	}
+
+	return -1
+}
+
+// Moves gaps and capture times reported from other goroutines
+// to where Next can use them.
+func (d *Decoder) collect() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, gap := range d.pendingGaps {
+		if gap >= d.pos {
+			d.gaps = append(d.gaps, gap)
+		}
+	}
+
+	d.marks = append(d.marks, d.pendingMarks...)
+	d.pendingGaps = d.pendingGaps[:0]
+	d.pendingMarks = d.pendingMarks[:0]
+}
+
+// Gets an error describing the skipped data, and resets the skip state.
+func (d *Decoder) resync() *ResyncError {
+	err := &ResyncError{Offset: d.skipStart, Skipped: d.skipped, Lost: d.lost}
+	d.skipped = 0
+	d.lost = false
+
+	return err
+}
+
+// Reads more data from the underlying reader into the buffer.
+func (d *Decoder) fill() {
+	// Make room by moving the buffered data to the front, growing if needed
+	if cap(d.buf)-len(d.buf) < minReadSize {
+		if needed := len(d.buf) + minReadSize; cap(d.store) < needed {
+			d.store = make([]byte, 2*needed)
+		}
+
+		d.buf = d.store[:copy(d.store, d.buf)]
+	}
+
+	n, err := d.r.Read(d.buf[len(d.buf):cap(d.buf)])
+	d.buf = d.buf[:len(d.buf)+n]
+
+	if err != nil {
+		d.err = err
+	}
+}
+
+// Gets the index of the first instance of sep in s at or after from,
+// or -1 if there are none.
+func indexFrom(s, sep []byte, from int) int {
+	if idx := bytes.Index(s[from:], sep); idx != -1 {
+		return from + idx
+	}
+
+	return -1
+}
@@ -0,0 +1,433 @@
+package ffxiv_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/sparta142/goblade/ffxiv"
+	"github.com/sparta142/goblade/oodle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func concat(chunks ...[]byte) []byte {
+	return bytes.Join(chunks, nil)
+}
+
+func TestDecoder_Next(t *testing.T) {
+	t.Parallel()
+
+	stream := concat(uncompressedBundleData, compressedBundleData, uncompressedBundleData)
+	decoder := ffxiv.NewDecoder(iotest.OneByteReader(bytes.NewReader(stream)))
+
+	for _, epoch := range []uint64{1624314019411, 1624314020072, 1624314019411} {
+		bundle, err := decoder.Next()
+		require.NoError(t, err)
+		assert.Equal(t, epoch, bundle.Epoch)
+	}
+
+	_, err := decoder.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+// Counts the Oodle payloads decoded through it.
+type countingOodle struct {
+	fakeOodle
+	count int
+}
+
+func (c *countingOodle) Decode(comp, raw []byte) error {
+	c.count++
+	return c.fakeOodle.Decode(comp, raw) //nolint:wrapcheck
+}
+
+func TestDecoder_SetOodle(t *testing.T) {
+	t.Parallel()
+
+	stream := fakeOodleBundle(t, uncompressedBundleData)
+
+	// Each decoder uses its own Oodle state
+	first, second := &countingOodle{}, &countingOodle{}
+
+	for _, od := range []*countingOodle{first, second, first} {
+		decoder := ffxiv.NewDecoder(bytes.NewReader(stream))
+		decoder.SetOodle(od)
+
+		bundle, err := decoder.Next()
+		require.NoError(t, err)
+		assert.Equal(t, ffxiv.CompressionOodle, bundle.Compression)
+	}
+
+	assert.Equal(t, 2, first.count)
+	assert.Equal(t, 1, second.count)
+}
+
+func TestDecoder_SetDeferOodle(t *testing.T) {
+	t.Parallel()
+
+	stream := concat(fakeOodleBundle(t, uncompressedBundleData), uncompressedBundleData)
+	decoder := ffxiv.NewDecoder(bytes.NewReader(stream))
+	decoder.SetOodle(oodle.Current())
+	decoder.SetDeferOodle(true)
+
+	// The Oodle bundle can't be decompressed, but is returned anyway
+	deferred, err := decoder.Next()
+	require.NoError(t, err)
+	assert.True(t, deferred.Compressed)
+	assert.Empty(t, deferred.Segments)
+	assert.EqualValues(t, len(uncompressedBundleData)-40, deferred.UncompressedLength)
+	assert.Len(t, deferred.Payload, len(uncompressedBundleData)-40)
+
+	// Other bundles are unaffected
+	bundle, err := decoder.Next()
+	require.NoError(t, err)
+	assert.False(t, bundle.Compressed)
+
+	_, err = deferred.MarshalBinary()
+	assert.ErrorIs(t, err, ffxiv.ErrStillCompressed)
+
+	// The deferred bundle survives being written out, and decompresses later
+	data, err := json.Marshal(&deferred)
+	require.NoError(t, err)
+
+	var decoded ffxiv.Bundle
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	// The limit is the one the stream was decoded with
+	tooLarge := decoded
+	assert.ErrorIs(t, tooLarge.Decompress(fakeOodle{}, int(decoded.UncompressedLength)-1), ffxiv.ErrTooLarge)
+
+	require.NoError(t, decoded.Decompress(fakeOodle{}, ffxiv.DefaultMaxBundleSize))
+	assert.False(t, decoded.Compressed)
+	assert.Empty(t, decoded.Payload)
+	assert.Equal(t, bundle.Segments, decoded.Segments)
+}
+
+func TestDecoder_Next_Garbage(t *testing.T) {
+	t.Parallel()
+
+	garbage := []byte("this is not a bundle")
+	stream := concat(garbage, uncompressedBundleData, garbage, garbage)
+	decoder := ffxiv.NewDecoder(bytes.NewReader(stream))
+
+	// The garbage before the bundle is reported
+	_, err := decoder.Next()
+	assert.Equal(t, &ffxiv.ResyncError{Offset: 0, Skipped: len(garbage)}, err)
+
+	bundle, err := decoder.Next()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1624314019411, bundle.Epoch)
+
+	// So is the garbage at the end
+	_, err = decoder.Next()
+	assert.Equal(t, &ffxiv.ResyncError{
+		Offset:  int64(len(garbage) + len(uncompressedBundleData)),
+		Skipped: 2 * len(garbage),
+	}, err)
+
+	_, err = decoder.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestDecoder_Next_Zeros(t *testing.T) {
+	t.Parallel()
+
+	// Zeros are keep-alive magic bytes at every offset, but never a plausible header
+	zeros := make([]byte, 64*1024)
+	decoder := ffxiv.NewDecoder(bytes.NewReader(concat(zeros, uncompressedBundleData)))
+
+	_, err := decoder.Next()
+	assert.Equal(t, &ffxiv.ResyncError{Offset: 0, Skipped: len(zeros)}, err)
+
+	bundle, err := decoder.Next()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1624314019411, bundle.Epoch)
+}
+
+func Benchmark_Decoder_Next_Zeros(b *testing.B) {
+	stream := concat(make([]byte, 4*1024*1024), uncompressedBundleData)
+
+	b.SetBytes(int64(len(stream)))
+
+	for n := 0; n < b.N; n++ {
+		decoder := ffxiv.NewDecoder(bytes.NewReader(stream))
+		for {
+			if _, err := decoder.Next(); errors.Is(err, io.EOF) {
+				break
+			}
+		}
+	}
+}
+
+func TestDecoder_Next_Truncated(t *testing.T) {
+	t.Parallel()
+
+	stream := concat(uncompressedBundleData, compressedBundleData[:100])
+	decoder := ffxiv.NewDecoder(bytes.NewReader(stream))
+
+	_, err := decoder.Next()
+	require.NoError(t, err)
+
+	_, err = decoder.Next()
+	assert.Equal(t, &ffxiv.ResyncError{Offset: int64(len(uncompressedBundleData)), Skipped: 100}, err)
+
+	_, err = decoder.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestDecoder_Next_FalseMagic(t *testing.T) {
+	t.Parallel()
+
+	// Magic bytes followed by a header that can't be real, as could
+	// appear inside a payload
+	fake := concat(ffxiv.IpcMagicBytes, make([]byte, 24))
+	decoder := ffxiv.NewDecoder(bytes.NewReader(concat(fake, uncompressedBundleData)))
+
+	_, err := decoder.Next()
+	assert.Equal(t, &ffxiv.ResyncError{Offset: 0, Skipped: len(fake)}, err)
+
+	bundle, err := decoder.Next()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1624314019411, bundle.Epoch)
+}
+
+func TestDecoder_SetDecompressObserver(t *testing.T) {
+	t.Parallel()
+
+	stream := concat(fakeOodleBundle(t, uncompressedBundleData), uncompressedBundleData)
+	decoder := ffxiv.NewDecoder(bytes.NewReader(stream))
+	decoder.SetOodle(fakeOodle{})
+
+	var observed []ffxiv.CompressionType
+
+	decoder.SetDecompressObserver(func(c ffxiv.CompressionType, elapsed time.Duration) {
+		assert.GreaterOrEqual(t, elapsed, time.Duration(0))
+		observed = append(observed, c)
+	})
+
+	for i := 0; i < 2; i++ {
+		_, err := decoder.Next()
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []ffxiv.CompressionType{ffxiv.CompressionOodle, ffxiv.CompressionNone}, observed)
+}
+
+func TestDecoder_SetCaptureTime(t *testing.T) {
+	t.Parallel()
+
+	decoder := ffxiv.NewDecoder(bytes.NewReader(uncompressedBundleData))
+	decoder.SetCaptureTime(0, time.Date(2023, time.February, 25, 12, 0, 0, 0, time.UTC))
+
+	// The bundle was sent long before the capture, so it's implausible
+	_, err := decoder.Next()
+	assert.Equal(t, &ffxiv.ResyncError{Offset: 0, Skipped: len(uncompressedBundleData)}, err)
+
+	_, err = decoder.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestDecoder_CaptureTime(t *testing.T) {
+	t.Parallel()
+
+	first := time.UnixMilli(1624314019500)
+	second := time.UnixMilli(1624314019600)
+	third := time.UnixMilli(1624314020100)
+
+	reader, writer := io.Pipe()
+	decoder := ffxiv.NewDecoder(reader)
+
+	go func() {
+		// The first bundle arrives in two pieces, and the second in one
+		decoder.SetCaptureTime(0, first)
+		_, _ = writer.Write(uncompressedBundleData[:100])
+		decoder.SetCaptureTime(100, second)
+		_, _ = writer.Write(uncompressedBundleData[100:])
+		decoder.SetCaptureTime(int64(len(uncompressedBundleData)), third)
+		_, _ = writer.Write(compressedBundleData)
+		writer.Close()
+	}()
+
+	// Bundles are captured when their last piece is
+	for i, want := range []time.Time{second, third} {
+		_, err := decoder.Next()
+		require.NoError(t, err)
+		assert.Equal(t, want, decoder.CaptureTime())
+		assert.EqualValues(t, i*len(uncompressedBundleData), decoder.Offset())
+	}
+}
+
+func TestDecoder_MarkDataLost(t *testing.T) {
+	t.Parallel()
+
+	// Simulate a gap in the middle of the first bundle
+	reader, writer := io.Pipe()
+	decoder := ffxiv.NewDecoder(reader)
+
+	go func() {
+		_, _ = writer.Write(uncompressedBundleData[:100])
+		decoder.MarkDataLost(100)
+		_, _ = writer.Write(compressedBundleData)
+		writer.Close()
+	}()
+
+	// The data before the gap is discarded
+	_, err := decoder.Next()
+	assert.Equal(t, &ffxiv.ResyncError{Offset: 0, Skipped: 100, Lost: true}, err)
+
+	// But the data after it is not
+	bundle, err := decoder.Next()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1624314020072, bundle.Epoch)
+
+	_, err = decoder.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestDecoder_MarkDataLost_Buffered(t *testing.T) {
+	t.Parallel()
+
+	// The bundle after the gap arrives with the data before it,
+	// and the reader then blocks as a live capture would
+	reader, writer := io.Pipe()
+	decoder := ffxiv.NewDecoder(reader)
+	decoder.MarkDataLost(100)
+
+	go func() {
+		_, _ = writer.Write(concat(uncompressedBundleData[:100], compressedBundleData))
+	}()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		_, err := decoder.Next()
+		assert.Equal(t, &ffxiv.ResyncError{Offset: 0, Skipped: 100, Lost: true}, err)
+
+		bundle, err := decoder.Next()
+		assert.NoError(t, err)
+		assert.EqualValues(t, 1624314020072, bundle.Epoch)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "decoding the buffered bundle waited on the reader")
+	}
+
+	writer.Close()
+	<-done
+}
+
+func TestDecoder_MarkDataLost_Boundary(t *testing.T) {
+	t.Parallel()
+
+	// Simulate a gap between two bundles
+	reader, writer := io.Pipe()
+	decoder := ffxiv.NewDecoder(reader)
+
+	go func() {
+		_, _ = writer.Write(uncompressedBundleData)
+		decoder.MarkDataLost(int64(len(uncompressedBundleData)))
+		_, _ = writer.Write(compressedBundleData)
+		writer.Close()
+	}()
+
+	// Nothing needs to be skipped
+	for _, epoch := range []uint64{1624314019411, 1624314020072} {
+		bundle, err := decoder.Next()
+		require.NoError(t, err)
+		assert.Equal(t, epoch, bundle.Epoch)
+	}
+
+	_, err := decoder.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestDecoder_Next_DecodeError(t *testing.T) {
+	t.Parallel()
+
+	// A bundle with a valid header but a corrupt zlib payload
+	bad := concat(compressedBundleData)
+	bad[40] ^= 0xff
+
+	decoder := ffxiv.NewDecoder(bytes.NewReader(concat(bad, uncompressedBundleData)))
+
+	_, err := decoder.Next()
+
+	var decodeErr *ffxiv.DecodeError
+	require.True(t, errors.As(err, &decodeErr))
+	assert.EqualValues(t, 0, decodeErr.Offset)
+	assert.Equal(t, len(bad), decodeErr.Length)
+
+	// The bad bundle is skipped
+	_, err = decoder.Next()
+	assert.NoError(t, err)
+}
+
+// Builds an encoded Bundle with one Ipc segment carrying size bytes of data.
+func largeBundle(t *testing.T, size int, compression ffxiv.CompressionType) []byte {
+	t.Helper()
+
+	bundle := ffxiv.Bundle{
+		Epoch:       1624314019411,
+		Encoding:    1,
+		Compression: compression,
+		Segments: []ffxiv.Segment{
+			{Type: ffxiv.SegmentIpc, Payload: &ffxiv.Ipc{Magic: 0x0014, Type: 0x009c, Data: make([]byte, size)}},
+		},
+	}
+
+	data, err := bundle.MarshalBinary()
+	require.NoError(t, err)
+
+	return data
+}
+
+func TestDecoder_Next_Large(t *testing.T) {
+	t.Parallel()
+
+	for _, compression := range []ffxiv.CompressionType{ffxiv.CompressionNone, ffxiv.CompressionZlib} {
+		large := largeBundle(t, 200*1024, compression)
+		decoder := ffxiv.NewDecoder(bytes.NewReader(concat(large, uncompressedBundleData)))
+
+		bundle, err := decoder.Next()
+		require.NoError(t, err)
+		require.Len(t, bundle.Segments, 1)
+		assert.Len(t, bundle.Segments[0].Payload.(*ffxiv.Ipc).Data, 200*1024)
+
+		_, err = decoder.Next()
+		assert.NoError(t, err)
+	}
+}
+
+func TestDecoder_SetMaxBundleSize(t *testing.T) {
+	t.Parallel()
+
+	for _, compression := range []ffxiv.CompressionType{ffxiv.CompressionNone, ffxiv.CompressionZlib} {
+		large := largeBundle(t, 200*1024, compression)
+		decoder := ffxiv.NewDecoder(bytes.NewReader(concat(large, uncompressedBundleData)))
+		decoder.SetMaxBundleSize(64 * 1024)
+
+		// The large bundle is reported and skipped, whether it's too large
+		// as sent or once decompressed
+		_, err := decoder.Next()
+		assert.ErrorIs(t, err, ffxiv.ErrTooLarge)
+
+		var decodeErr *ffxiv.DecodeError
+		require.True(t, errors.As(err, &decodeErr))
+		assert.Equal(t, len(large), decodeErr.Length)
+
+		bundle, err := decoder.Next()
+		require.NoError(t, err)
+		assert.EqualValues(t, 1624314019411, bundle.Epoch)
+
+		_, err = decoder.Next()
+		assert.ErrorIs(t, err, io.EOF)
+	}
+}
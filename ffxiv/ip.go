@@ -7,6 +7,9 @@ import "net"
 //
 // Found by resolving each lobby domain to its IPv4 address, then looking up
 // the assigned address block that contains it in ARIN.
+//
+// Deprecated: It doesn't change when the default Resolver is replaced.
+// Use KnownDataCenterCIDRs instead.
 var DataCenterCIDRs = [...]string{
 	// neolobby01.ffxiv.com, neolobby03.ffxiv.com, neolobby05.ffxiv.com
 	"124.150.152.0/21",
@@ -26,6 +29,9 @@ var DataCenterCIDRs = [...]string{
 
 // DataCenterNets is a list of all theorized public FINAL FANTASY XIV
 // data center IP networks, as IPNets.
+//
+// Deprecated: It doesn't change when the default Resolver is replaced.
+// Use the Networks of DefaultResolver instead.
 var DataCenterNets = func() []net.IPNet {
 	nets := make([]net.IPNet, len(DataCenterCIDRs))
 
@@ -40,13 +46,30 @@ var DataCenterNets = func() []net.IPNet {
 	return nets
 }()
 
+// Gets all theorized public FINAL FANTASY XIV data center IP networks
+// known to the default Resolver, in string CIDR notation.
+func KnownDataCenterCIDRs() []string {
+	networks := DefaultResolver().Networks()
+	cidrs := make([]string, len(networks))
+
+	for i, n := range networks {
+		cidrs[i] = n.CIDR
+	}
+
+	return cidrs
+}
+
 // Returns whether ip is probably a FINAL FANTASY XIV address.
 func IsFinalFantasyIP(ip net.IP) bool {
-	for _, ipnet := range DataCenterNets {
-		if ipnet.Contains(ip) {
-			return true
-		}
-	}
+	_, ok := DefaultResolver().Network(ip)
+	return ok
+}
 
-	return false
+// Gets the opcode region of the data center network containing ip.
+// It returns false if ip is not in a known network. Only global networks
+// are embedded, so other regions are only found by a Resolver that was
+// loaded from a file.
+func RegionOf(ip net.IP) (Region, bool) {
+	network, ok := DefaultResolver().Network(ip)
+	return network.Region, ok
 }
@@ -116,3 +116,23 @@ func TestAllKnownWorldIPs(t *testing.T) {
 		})
 	}
 }
+
+func TestRegionOf(t *testing.T) {
+	t.Parallel()
+
+	region, ok := ffxiv.RegionOf(net.ParseIP("204.2.229.84"))
+	assert.True(t, ok)
+	assert.Equal(t, ffxiv.RegionGlobal, region)
+
+	_, ok = ffxiv.RegionOf(net.ParseIP("192.168.1.1"))
+	assert.False(t, ok)
+}
+
+//nolint:staticcheck // Checks that the deprecated networks are still the embedded ones
+func TestDataCenterCIDRs(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, ffxiv.DataCenterCIDRs[:], ffxiv.KnownDataCenterCIDRs())
+	assert.Len(t, ffxiv.DataCenterNets, len(ffxiv.DataCenterCIDRs))
+	assert.True(t, ffxiv.DataCenterNets[1].Contains(net.ParseIP("204.2.229.84")))
+}
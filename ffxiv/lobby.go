@@ -0,0 +1,181 @@
+package ffxiv
+
+import (
+	"bytes"
+	"crypto/md5" //nolint:gosec // Dictated by the game's key derivation
+	"encoding"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/blowfish"
+	"golang.org/x/exp/slices"
+)
+
+var ErrNoKeyPhrase = errors.New("ffxiv: encryption init has no key phrase")
+
+const (
+	encryptionInitSize       = 0x78
+	encryptionKeyPhraseStart = 0x24
+	encryptionKeyPhraseEnd   = 0x74
+	encryptionKeyOffset      = 0x74
+
+	// The size of the buffer the lobby encryption key is derived from.
+	baseKeySize = 0x2c
+
+	// The offset of the key phrase within the base key.
+	baseKeyPhraseOffset = 0x0c
+
+	// The size of one Blowfish word, in bytes.
+	blowfishWordSize = 4
+)
+
+// The game version stamped into the base key when deriving the lobby
+// encryption key. The client changes this between major game versions.
+var LobbyKeyVersion uint16 = 6000
+
+// EncryptionInit is the payload of a SegmentEncryptionInit segment,
+// sent by the client to begin an encrypted lobby session.
+type EncryptionInit struct {
+	// The key phrase chosen by the client.
+	KeyPhrase string `json:"keyPhrase"`
+
+	// The client's key, usually derived from the current time.
+	Key uint32 `json:"key"`
+}
+
+func (e *EncryptionInit) UnmarshalBinary(data []byte) error {
+	if len(data) < encryptionInitSize {
+		return fmt.Errorf("check length for encryption init: %w", ErrNotEnoughData)
+	}
+
+	// The key phrase is a NUL-terminated string in a fixed-size field
+	phrase := data[encryptionKeyPhraseStart:encryptionKeyPhraseEnd]
+	if idx := bytes.IndexByte(phrase, 0); idx != -1 {
+		phrase = phrase[:idx]
+	}
+
+	if len(phrase) == 0 {
+		return ErrNoKeyPhrase
+	}
+
+	e.KeyPhrase = string(phrase)
+	e.Key = byteOrder.Uint32(data[encryptionKeyOffset:])
+
+	return nil
+}
+
+func (e *EncryptionInit) MarshalBinary() ([]byte, error) {
+	// The NUL terminator is left out if the key phrase fills the field,
+	// which UnmarshalBinary also accepts
+	if len(e.KeyPhrase) > encryptionKeyPhraseEnd-encryptionKeyPhraseStart {
+		return nil, fmt.Errorf("%w: %d byte key phrase", ErrTooLarge, len(e.KeyPhrase))
+	}
+
+	data := make([]byte, encryptionInitSize)
+	copy(data[encryptionKeyPhraseStart:], e.KeyPhrase)
+	byteOrder.PutUint32(data[encryptionKeyOffset:], e.Key)
+
+	return data, nil
+}
+
+// Derives the Blowfish key for the lobby session started by this EncryptionInit.
+func (e *EncryptionInit) DeriveKey() []byte {
+	base := make([]byte, baseKeySize)
+	byteOrder.PutUint32(base[0:4], 0x12345678)
+	byteOrder.PutUint32(base[4:8], e.Key)
+	byteOrder.PutUint16(base[8:10], LobbyKeyVersion)
+	copy(base[baseKeyPhraseOffset:], e.KeyPhrase)
+
+	sum := md5.Sum(base) //nolint:gosec // Dictated by the game's key derivation
+
+	return sum[:]
+}
+
+// LobbySession holds the encryption state of one lobby connection, and is
+// shared by both of its directions. The zero value decrypts nothing until it
+// sees a SegmentEncryptionInit, so it is also safe to use for zone and chat
+// connections.
+type LobbySession struct {
+	mu     sync.RWMutex
+	cipher *blowfish.Cipher
+}
+
+// Whether the session has seen a SegmentEncryptionInit
+// and is decrypting segment payloads.
+func (s *LobbySession) Encrypted() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.cipher != nil
+}
+
+// Starts (or restarts) decryption using the key derived from init.
+func (s *LobbySession) Start(init *EncryptionInit) error {
+	cipher, err := blowfish.NewCipher(init.DeriveKey())
+	if err != nil {
+		return fmt.Errorf("create blowfish cipher: %w", err)
+	}
+
+	s.mu.Lock()
+	s.cipher = cipher
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Decrypts the payload of a segment of the given type, returning a
+// decrypted copy. Payloads that aren't encrypted are returned as-is.
+func (s *LobbySession) Decrypt(segmentType SegmentType, payload []byte) []byte {
+	return s.crypt(segmentType, payload, (*blowfish.Cipher).Decrypt)
+}
+
+// Encrypts the payload of a segment of the given type in the same way
+// as the game, returning an encrypted copy. This is the inverse of Decrypt.
+func (s *LobbySession) Encrypt(segmentType SegmentType, payload []byte) []byte {
+	return s.crypt(segmentType, payload, (*blowfish.Cipher).Encrypt)
+}
+
+func (s *LobbySession) crypt(
+	segmentType SegmentType,
+	payload []byte,
+	blockFunc func(c *blowfish.Cipher, dst, src []byte),
+) []byte {
+	if segmentType != SegmentIpc && segmentType != SegmentEncryptionResponse {
+		return payload
+	}
+
+	s.mu.RLock()
+	cipher := s.cipher
+	s.mu.RUnlock()
+
+	if cipher == nil {
+		return payload
+	}
+
+	out := slices.Clone(payload)
+
+	for block := out; len(block) >= blowfish.BlockSize; block = block[blowfish.BlockSize:] {
+		swapWords(block[:blowfish.BlockSize])
+		blockFunc(cipher, block, block)
+		swapWords(block[:blowfish.BlockSize])
+	}
+
+	return out
+}
+
+// Reverses the byte order of each 32-bit word in block.
+//
+// The game reads each half of a Blowfish block as a little-endian word,
+// but the blowfish package reads them as big-endian.
+func swapWords(block []byte) {
+	for i := 0; i+blowfishWordSize <= len(block); i += blowfishWordSize {
+		block[i], block[i+3] = block[i+3], block[i]
+		block[i+1], block[i+2] = block[i+2], block[i+1]
+	}
+}
+
+var (
+	_ encoding.BinaryMarshaler   = (*EncryptionInit)(nil)
+	_ encoding.BinaryUnmarshaler = (*EncryptionInit)(nil)
+)
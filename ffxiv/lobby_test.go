@@ -0,0 +1,150 @@
+package ffxiv_test
+
+import (
+	"testing"
+
+	"github.com/sparta142/goblade/ffxiv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A client bundle beginning a lobby session. Like the bundle below, it is
+// synthetic: the expected key and plaintext were checked against MD5 from
+// Python's hashlib and Blowfish from OpenSSL, rather than this package.
+var encryptionInitBundleData = []byte{
+	0x52, 0x52, 0xa0, 0x41, 0xff, 0x5d, 0x46, 0xe2,
+	0x7f, 0x2a, 0x64, 0x4d, 0x7b, 0x99, 0xc4, 0x75,
+	0xe8, 0xa9, 0x57, 0x7f, 0x86, 0x01, 0x00, 0x00,
+	0xb0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00,
+	0x01, 0x00, 0x00, 0x00, 0x88, 0x00, 0x00, 0x00,
+	0x88, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x33, 0x31, 0x65, 0x39,
+	0x62, 0x64, 0x34, 0x62, 0x65, 0x30, 0x34, 0x61,
+	0x38, 0x39, 0x61, 0x39, 0x65, 0x32, 0x34, 0x65,
+	0x66, 0x37, 0x66, 0x36, 0x62, 0x33, 0x65, 0x34,
+	0x63, 0x30, 0x61, 0x35, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0xc1, 0xa3, 0xf7, 0x63,
+}
+
+// A server bundle with a lobby IPC encrypted using the session above.
+var encryptedIpcBundleData = []byte{
+	0x52, 0x52, 0xa0, 0x41, 0xff, 0x5d, 0x46, 0xe2,
+	0x7f, 0x2a, 0x64, 0x4d, 0x7b, 0x99, 0xc4, 0x75,
+	0xca, 0xae, 0x57, 0x7f, 0x86, 0x01, 0x00, 0x00,
+	0x58, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00,
+	0x01, 0x00, 0x00, 0x00, 0x30, 0x00, 0x00, 0x00,
+	0x30, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00,
+	0xe5, 0x0b, 0x77, 0xf3, 0x15, 0x6f, 0x46, 0xc4,
+	0xfd, 0xda, 0x22, 0x89, 0x1b, 0x93, 0x90, 0x92,
+	0x6f, 0x9d, 0x9d, 0xc0, 0xad, 0x4e, 0xe1, 0x77,
+	0xde, 0xdb, 0x56, 0x28, 0x36, 0xbf, 0x58, 0x9a,
+}
+
+func TestEncryptionInit_DeriveKey(t *testing.T) {
+	t.Parallel()
+
+	init := ffxiv.EncryptionInit{KeyPhrase: "31e9bd4be04a89a9e24ef7f6b3e4c0a5", Key: 0x63f7a3c1}
+	expected := []byte{
+		0x2f, 0x2f, 0x38, 0x07, 0x60, 0x5a, 0x93, 0xe9,
+		0x9c, 0x67, 0x6d, 0xf5, 0xfe, 0x2e, 0xfa, 0xd7,
+	}
+
+	assert.Equal(t, expected, init.DeriveKey())
+}
+
+// Checks Encrypt against Blowfish from OpenSSL, which reads each block as two
+// big-endian words where the game reads them as little-endian ones:
+//
+//	openssl enc -bf-ecb -nopad -provider legacy -K a89f0c1731365675631d7cd6608c9660
+func TestLobbySession_KnownAnswer(t *testing.T) {
+	t.Parallel()
+
+	init := ffxiv.EncryptionInit{KeyPhrase: "d8d0a8c0e2a9f16b4c0e7d5f9a3b2c1e", Key: 0x6553f17e}
+	assert.Equal(t, []byte{
+		0xa8, 0x9f, 0x0c, 0x17, 0x31, 0x36, 0x56, 0x75,
+		0x63, 0x1d, 0x7c, 0xd6, 0x60, 0x8c, 0x96, 0x60,
+	}, init.DeriveKey())
+
+	var session ffxiv.LobbySession
+	require.NoError(t, session.Start(&init))
+
+	plaintext := []byte{
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07,
+		0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+	}
+	assert.Equal(t, []byte{
+		0x13, 0x23, 0xb1, 0xd0, 0x47, 0x18, 0x1d, 0xc1,
+		0x3c, 0x6d, 0x5f, 0x5b, 0x58, 0xb5, 0x93, 0x49,
+	}, session.Encrypt(ffxiv.SegmentIpc, plaintext))
+}
+
+func TestUnmarshalWithSession_Lobby(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	var session ffxiv.LobbySession
+	var bundle ffxiv.Bundle
+
+	// The session shouldn't decrypt anything before the handshake
+	assert.False(session.Encrypted())
+
+	// Read the handshake
+	require.NoError(t, bundle.UnmarshalWithSession(encryptionInitBundleData, &session))
+	require.Len(t, bundle.Segments, 1)
+	assert.EqualValues(ffxiv.SegmentEncryptionInit, bundle.Segments[0].Type)
+	assert.Equal(&ffxiv.EncryptionInit{
+		KeyPhrase: "31e9bd4be04a89a9e24ef7f6b3e4c0a5",
+		Key:       0x63f7a3c1,
+	}, bundle.Segments[0].Payload)
+	assert.True(session.Encrypted())
+
+	// Read an encrypted IPC after the handshake
+	require.NoError(t, bundle.UnmarshalWithSession(encryptedIpcBundleData, &session))
+	require.Len(t, bundle.Segments, 1)
+	require.IsType(t, (*ffxiv.Ipc)(nil), bundle.Segments[0].Payload)
+
+	ipc := bundle.Segments[0].Payload.(*ffxiv.Ipc)
+	assert.EqualValues(0x0014, ipc.Magic)
+	assert.EqualValues(0x000c, ipc.Type)
+	assert.EqualValues(1677173698, ipc.Epoch)
+	assert.Equal([]byte("Hello, Eorzea!\x00\x00"), ipc.Data)
+}
+
+func TestUnmarshalWithSession_NoHandshake(t *testing.T) {
+	t.Parallel()
+
+	var session ffxiv.LobbySession
+	var bundle ffxiv.Bundle
+
+	// Without the handshake, the IPC header is garbage
+	require.NoError(t, bundle.UnmarshalWithSession(encryptedIpcBundleData, &session))
+	assert.NotEqualValues(t, 0x0014, bundle.Segments[0].Payload.(*ffxiv.Ipc).Magic)
+}
+
+func TestLobbySession_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	var session ffxiv.LobbySession
+	require.NoError(t, session.Start(&ffxiv.EncryptionInit{KeyPhrase: "phrase", Key: 1}))
+
+	// The trailing partial block is left as-is
+	plaintext := []byte("0123456789abcdefXYZ")
+	encrypted := session.Encrypt(ffxiv.SegmentIpc, plaintext)
+	assert.NotEqual(t, plaintext[:16], encrypted[:16])
+	assert.Equal(t, plaintext[16:], encrypted[16:])
+	assert.Equal(t, plaintext, session.Decrypt(ffxiv.SegmentIpc, encrypted))
+
+	// Keep-alives are never encrypted
+	assert.Equal(t, plaintext, session.Encrypt(ffxiv.SegmentClientKeepAlive, plaintext))
+}
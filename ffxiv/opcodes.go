@@ -4,8 +4,12 @@ import (
 	_ "embed"
 	"errors"
 	"fmt"
+	"os"
+	"sort"
+	"sync/atomic"
 
 	"github.com/goccy/go-json"
+	"golang.org/x/exp/maps"
 )
 
 var ErrUnknownRegion = errors.New("ffxiv: unknown region")
@@ -53,20 +57,78 @@ func (t *OpcodeTable) GetOpcodeName(ipcType IpcType, opcode int) string {
 	return ""
 }
 
+// Gets the name of an opcode, trying each IPC type for the direction it was
+// sent in, or "" if none of them have it.
+func (t *OpcodeTable) NameOf(opcode uint16, fromServer bool) string {
+	ipcTypes := [...]IpcType{ClientZoneIpcType, ClientChatIpcType, ClientLobbyIpcType}
+	if fromServer {
+		ipcTypes = [...]IpcType{ServerZoneIpcType, ServerChatIpcType, ServerLobbyIpcType}
+	}
+
+	for _, ipcType := range ipcTypes {
+		if name := t.GetOpcodeName(ipcType, int(opcode)); name != "" {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// Sets the name of each Ipc in the bundle from its opcode,
+// given whether the bundle was sent by the server.
+func (t *OpcodeTable) ResolveBundle(b *Bundle, fromServer bool) {
+	for i := range b.Segments {
+		if ipc, ok := b.Segments[i].Payload.(*Ipc); ok {
+			ipc.Name = t.NameOf(ipc.Type, fromServer)
+		}
+	}
+}
+
+// An opcode table as it appears in FFXIVOpcodes JSON.
+type rawOpcodeTable struct {
+	Version string                  `json:"version"`
+	Region  Region                  `json:"region"`
+	Lists   map[IpcType][]rawOpcode `json:"lists"`
+}
+
+type rawOpcode struct {
+	Name   string `json:"name"`
+	Opcode int    `json:"opcode"`
+}
+
+// Gets the opcode table for a region from the opcodes embedded at build time.
 func GetOpcodes(region Region) (OpcodeTable, error) {
-	type rawOpcodeTable struct {
-		Version string `json:"version"`
-		Lists   map[IpcType][]struct {
-			Name   string `json:"name"`
-			Opcode int    `json:"opcode"`
-		} `json:"lists"`
-		Region Region `json:"region"`
+	table, err := ParseOpcodes(opcodesJSON, region)
+	if err != nil {
+		return OpcodeTable{}, fmt.Errorf("parse embedded opcodes: %w", err)
 	}
 
+	return table, nil
+}
+
+// Reads the opcode table for a region from a file in the same format as
+// the embedded opcodes (https://github.com/karashiiro/FFXIVOpcodes).
+func LoadOpcodes(path string, region Region) (OpcodeTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return OpcodeTable{}, fmt.Errorf("read opcodes file: %w", err)
+	}
+
+	table, err := ParseOpcodes(data, region)
+	if err != nil {
+		return OpcodeTable{}, fmt.Errorf("parse opcodes file %s: %w", path, err)
+	}
+
+	return table, nil
+}
+
+// Parses the opcode table for a region from JSON in the same format as
+// the embedded opcodes: an array of tables, one for each region.
+func ParseOpcodes(data []byte, region Region) (OpcodeTable, error) {
 	// Deserialize the array of opcode tables from JSON
 	var rawTables []rawOpcodeTable
-	if err := json.Unmarshal(opcodesJSON, &rawTables); err != nil {
-		return OpcodeTable{}, fmt.Errorf("unmarshal embedded opcodes: %w", err)
+	if err := json.Unmarshal(data, &rawTables); err != nil {
+		return OpcodeTable{}, fmt.Errorf("unmarshal opcodes: %w", err)
 	}
 
 	// Find the region we're looking for
@@ -101,3 +163,218 @@ func GetOpcodes(region Region) (OpcodeTable, error) {
 
 	return table, nil
 }
+
+// Encodes tables as JSON in the same format as the embedded opcodes,
+// so that the result can be read back by ParseOpcodes.
+func MarshalOpcodes(tables ...OpcodeTable) ([]byte, error) {
+	rawTables := make([]rawOpcodeTable, len(tables))
+
+	for i, t := range tables {
+		rawTables[i] = rawOpcodeTable{
+			Version: t.Version,
+			Region:  t.Region,
+			Lists:   make(map[IpcType][]rawOpcode, len(t.Lists)),
+		}
+
+		for _, entry := range t.Entries() {
+			rawTables[i].Lists[entry.IpcType] = append(
+				rawTables[i].Lists[entry.IpcType],
+				rawOpcode{Name: entry.Name, Opcode: entry.Opcode},
+			)
+		}
+	}
+
+	data, err := json.MarshalIndent(rawTables, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal opcodes: %w", err)
+	}
+
+	return data, nil
+}
+
+// OpcodeEntry is a single named opcode in an OpcodeTable.
+type OpcodeEntry struct {
+	IpcType IpcType `json:"type"`
+	Name    string  `json:"name"`
+	Opcode  int     `json:"opcode"`
+}
+
+// Gets every opcode in the table, sorted by IpcType and then by opcode.
+func (t *OpcodeTable) Entries() []OpcodeEntry {
+	entries := make([]OpcodeEntry, 0, len(t.Lists)*64)
+
+	for ipcType, mapping := range t.Lists {
+		for opcode, name := range mapping {
+			entries = append(entries, OpcodeEntry{IpcType: ipcType, Name: name, Opcode: opcode})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IpcType != entries[j].IpcType {
+			return entries[i].IpcType < entries[j].IpcType
+		}
+
+		return entries[i].Opcode < entries[j].Opcode
+	})
+
+	return entries
+}
+
+type OpcodeChangeKind string
+
+const (
+	OpcodeAdded      = OpcodeChangeKind("added")
+	OpcodeRemoved    = OpcodeChangeKind("removed")
+	OpcodeRenumbered = OpcodeChangeKind("renumbered")
+)
+
+// OpcodeChange describes how a named opcode differs between two tables.
+// OldOpcode is meaningless for added opcodes, as is NewOpcode for removed ones.
+type OpcodeChange struct {
+	Kind      OpcodeChangeKind `json:"kind"`
+	IpcType   IpcType          `json:"type"`
+	Name      string           `json:"name"`
+	OldOpcode int              `json:"oldOpcode"`
+	NewOpcode int              `json:"newOpcode"`
+}
+
+// Compares two tables by opcode name, returning the opcodes that were
+// renumbered, added or removed going from one to the other.
+// The changes are sorted by IpcType and then by name.
+func DiffOpcodes(from, to *OpcodeTable) []OpcodeChange {
+	oldNames := from.opcodesByName()
+	newNames := to.opcodesByName()
+
+	var changes []OpcodeChange
+
+	for key, oldOpcode := range oldNames {
+		change := OpcodeChange{IpcType: key.ipcType, Name: key.name, OldOpcode: oldOpcode}
+
+		if newOpcode, ok := newNames[key]; !ok {
+			change.Kind = OpcodeRemoved
+		} else if newOpcode != oldOpcode {
+			change.Kind = OpcodeRenumbered
+			change.NewOpcode = newOpcode
+		} else {
+			continue
+		}
+
+		changes = append(changes, change)
+	}
+
+	for key, newOpcode := range newNames {
+		if _, ok := oldNames[key]; !ok {
+			changes = append(changes, OpcodeChange{
+				Kind:      OpcodeAdded,
+				IpcType:   key.ipcType,
+				Name:      key.name,
+				NewOpcode: newOpcode,
+			})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].IpcType != changes[j].IpcType {
+			return changes[i].IpcType < changes[j].IpcType
+		}
+
+		return changes[i].Name < changes[j].Name
+	})
+
+	return changes
+}
+
+type opcodeKey struct {
+	ipcType IpcType
+	name    string
+}
+
+// Gets the opcode for each name in the table. If a name has several
+// opcodes, the lowest one is used.
+func (t *OpcodeTable) opcodesByName() map[opcodeKey]int {
+	byName := make(map[opcodeKey]int)
+
+	for ipcType, mapping := range t.Lists {
+		for opcode, name := range mapping {
+			key := opcodeKey{ipcType, name}
+			if existing, ok := byName[key]; !ok || opcode < existing {
+				byName[key] = opcode
+			}
+		}
+	}
+
+	return byName
+}
+
+// OpcodeOverrides patch individual opcodes in an OpcodeTable, such as when
+// a game patch changes a few opcodes before the full table is updated.
+// They map each opcode name to its opcode, for each IpcType.
+//
+// In JSON, they are written as {"ServerZoneIpcType": {"PlayerSetup": 412}}.
+type OpcodeOverrides map[IpcType]map[string]int
+
+// Reads OpcodeOverrides from a JSON file.
+func LoadOpcodeOverrides(path string) (OpcodeOverrides, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read opcode overrides file: %w", err)
+	}
+
+	var overrides OpcodeOverrides
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("unmarshal opcode overrides file %s: %w", path, err)
+	}
+
+	return overrides, nil
+}
+
+// Gets a copy of the table with overrides applied. Each overridden name
+// moves to its new opcode, replacing any name the opcode already had.
+func (t *OpcodeTable) WithOverrides(overrides OpcodeOverrides) OpcodeTable {
+	table := OpcodeTable{
+		Version: t.Version,
+		Region:  t.Region,
+		Lists:   make(map[IpcType]opcodeMapping, len(t.Lists)),
+	}
+
+	for ipcType, mapping := range t.Lists {
+		table.Lists[ipcType] = maps.Clone(mapping)
+	}
+
+	for ipcType, names := range overrides {
+		mapping, ok := table.Lists[ipcType]
+		if !ok {
+			mapping = make(opcodeMapping, len(names))
+			table.Lists[ipcType] = mapping
+		}
+
+		// Forget the old opcodes of the overridden names
+		maps.DeleteFunc(mapping, func(_ int, name string) bool {
+			_, overridden := names[name]
+			return overridden
+		})
+
+		for name, opcode := range names {
+			mapping[opcode] = name
+		}
+	}
+
+	return table
+}
+
+// OpcodeStore holds the current OpcodeTable, which can be replaced
+// while other goroutines are using it. The zero value holds no table.
+type OpcodeStore struct {
+	table atomic.Pointer[OpcodeTable]
+}
+
+// Gets the current table, or nil if none has been stored.
+// The table must not be modified.
+func (s *OpcodeStore) Load() *OpcodeTable {
+	return s.table.Load()
+}
+
+// Replaces the current table.
+func (s *OpcodeStore) Store(table OpcodeTable) {
+	s.table.Store(&table)
+}
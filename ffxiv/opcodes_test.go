@@ -0,0 +1,155 @@
+package ffxiv_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sparta142/goblade/ffxiv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var opcodesData = []byte(`[
+	{
+		"version": "2023.02.28.0000.0000",
+		"region": "Global",
+		"lists": {
+			"ServerZoneIpcType": [
+				{"name": "PlayerSetup", "opcode": 412},
+				{"name": "ActorControl", "opcode": 911}
+			],
+			"ClientZoneIpcType": [
+				{"name": "ChatHandler", "opcode": 156}
+			]
+		}
+	},
+	{
+		"version": "2023.01.10.0000.0000",
+		"region": "KR",
+		"lists": {
+			"ServerZoneIpcType": [
+				{"name": "PlayerSetup", "opcode": 101}
+			]
+		}
+	}
+]`)
+
+func TestParseOpcodes(t *testing.T) {
+	t.Parallel()
+
+	table, err := ffxiv.ParseOpcodes(opcodesData, ffxiv.RegionKorea)
+	require.NoError(t, err)
+	assert.Equal(t, "2023.01.10.0000.0000", table.Version)
+	assert.Equal(t, ffxiv.RegionKorea, table.Region)
+	assert.Equal(t, "PlayerSetup", table.GetOpcodeName(ffxiv.ServerZoneIpcType, 101))
+	assert.Equal(t, "", table.GetOpcodeName(ffxiv.ServerZoneIpcType, 412))
+
+	_, err = ffxiv.ParseOpcodes(opcodesData, ffxiv.RegionChina)
+	assert.ErrorIs(t, err, ffxiv.ErrUnknownRegion)
+}
+
+func TestLoadOpcodes(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "opcodes.json")
+	require.NoError(t, os.WriteFile(path, opcodesData, 0o600))
+
+	table, err := ffxiv.LoadOpcodes(path, ffxiv.RegionGlobal)
+	require.NoError(t, err)
+	assert.Equal(t, "ChatHandler", table.GetOpcodeName(ffxiv.ClientZoneIpcType, 156))
+
+	_, err = ffxiv.LoadOpcodes(filepath.Join(t.TempDir(), "missing.json"), ffxiv.RegionGlobal)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestOpcodeTable_WithOverrides(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "overrides.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"ServerZoneIpcType": {"PlayerSetup": 413, "Effect": 911},
+		"ServerChatIpcType": {"Tell": 100}
+	}`), 0o600))
+
+	overrides, err := ffxiv.LoadOpcodeOverrides(path)
+	require.NoError(t, err)
+
+	table, err := ffxiv.ParseOpcodes(opcodesData, ffxiv.RegionGlobal)
+	require.NoError(t, err)
+
+	patched := table.WithOverrides(overrides)
+
+	// Names move to their new opcodes, replacing what was there
+	assert.Equal(t, "", patched.GetOpcodeName(ffxiv.ServerZoneIpcType, 412))
+	assert.Equal(t, "PlayerSetup", patched.GetOpcodeName(ffxiv.ServerZoneIpcType, 413))
+	assert.Equal(t, "Effect", patched.GetOpcodeName(ffxiv.ServerZoneIpcType, 911))
+	assert.Equal(t, "ChatHandler", patched.GetOpcodeName(ffxiv.ClientZoneIpcType, 156))
+	assert.Equal(t, "Tell", patched.GetOpcodeName(ffxiv.ServerChatIpcType, 100))
+	assert.Equal(t, "Tell", patched.NameOf(100, true))
+	assert.Equal(t, "", patched.NameOf(100, false))
+
+	// The original table is untouched
+	assert.Equal(t, "PlayerSetup", table.GetOpcodeName(ffxiv.ServerZoneIpcType, 412))
+	assert.Equal(t, "ActorControl", table.GetOpcodeName(ffxiv.ServerZoneIpcType, 911))
+}
+
+func TestOpcodeStore(t *testing.T) {
+	t.Parallel()
+
+	var store ffxiv.OpcodeStore
+	assert.Nil(t, store.Load())
+
+	store.Store(ffxiv.OpcodeTable{Version: "1"})
+	old := store.Load()
+
+	store.Store(ffxiv.OpcodeTable{Version: "2"})
+	assert.Equal(t, "1", old.Version)
+	assert.Equal(t, "2", store.Load().Version)
+}
+
+func TestOpcodeTable_Entries(t *testing.T) {
+	t.Parallel()
+
+	table, err := ffxiv.ParseOpcodes(opcodesData, ffxiv.RegionGlobal)
+	require.NoError(t, err)
+
+	assert.Equal(t, []ffxiv.OpcodeEntry{
+		{IpcType: ffxiv.ClientZoneIpcType, Name: "ChatHandler", Opcode: 156},
+		{IpcType: ffxiv.ServerZoneIpcType, Name: "PlayerSetup", Opcode: 412},
+		{IpcType: ffxiv.ServerZoneIpcType, Name: "ActorControl", Opcode: 911},
+	}, table.Entries())
+}
+
+func TestMarshalOpcodes(t *testing.T) {
+	t.Parallel()
+
+	table, err := ffxiv.ParseOpcodes(opcodesData, ffxiv.RegionGlobal)
+	require.NoError(t, err)
+
+	data, err := ffxiv.MarshalOpcodes(table)
+	require.NoError(t, err)
+
+	roundTripped, err := ffxiv.ParseOpcodes(data, ffxiv.RegionGlobal)
+	require.NoError(t, err)
+	assert.Equal(t, table, roundTripped)
+}
+
+func TestDiffOpcodes(t *testing.T) {
+	t.Parallel()
+
+	from, err := ffxiv.ParseOpcodes(opcodesData, ffxiv.RegionGlobal)
+	require.NoError(t, err)
+
+	to := from.WithOverrides(ffxiv.OpcodeOverrides{
+		ffxiv.ServerZoneIpcType: {"PlayerSetup": 413, "Effect": 911},
+	})
+
+	assert.Equal(t, []ffxiv.OpcodeChange{
+		{Kind: ffxiv.OpcodeRemoved, IpcType: ffxiv.ServerZoneIpcType, Name: "ActorControl", OldOpcode: 911},
+		{Kind: ffxiv.OpcodeAdded, IpcType: ffxiv.ServerZoneIpcType, Name: "Effect", NewOpcode: 911},
+		{Kind: ffxiv.OpcodeRenumbered, IpcType: ffxiv.ServerZoneIpcType, Name: "PlayerSetup", OldOpcode: 412, NewOpcode: 413},
+	}, ffxiv.DiffOpcodes(&from, &to))
+
+	assert.Empty(t, ffxiv.DiffOpcodes(&from, &from))
+}
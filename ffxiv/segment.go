@@ -2,8 +2,11 @@ package ffxiv
 
 import (
 	"encoding"
+	"errors"
 	"fmt"
+	"math"
 
+	"github.com/goccy/go-json"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/exp/slices"
 )
@@ -14,12 +17,17 @@ const (
 	keepAliveSize     = 8
 )
 
+var ErrBadPayload = errors.New("ffxiv: bad segment payload type")
+
 type SegmentType uint16
 
 const (
 	SegmentIpc             = SegmentType(3)
 	SegmentClientKeepAlive = SegmentType(7)
 	SegmentServerKeepAlive = SegmentType(8)
+
+	SegmentEncryptionInit     = SegmentType(9)
+	SegmentEncryptionResponse = SegmentType(10)
 )
 
 func (s SegmentType) String() string {
@@ -30,6 +38,10 @@ func (s SegmentType) String() string {
 		return "ClientKeepAlive"
 	case SegmentServerKeepAlive:
 		return "ServerKeepAlive"
+	case SegmentEncryptionInit:
+		return "EncryptionInit"
+	case SegmentEncryptionResponse:
+		return "EncryptionResponse"
 	default:
 		return fmt.Sprint(uint16(s))
 	}
@@ -52,8 +64,13 @@ type Segment struct {
 }
 
 func (s *Segment) UnmarshalBinary(data []byte) error {
+	return s.unmarshal(data, nil)
+}
+
+// Decodes a Segment, decrypting its payload with session if it is non-nil.
+func (s *Segment) unmarshal(data []byte, session *LobbySession) error {
 	if len(data) < segmentHeaderSize {
-		return ErrNotEnoughData
+		return fmt.Errorf("check length for header: %w", ErrNotEnoughData)
 	}
 
 	_ = data[segmentHeaderSize-1]
@@ -64,22 +81,50 @@ func (s *Segment) UnmarshalBinary(data []byte) error {
 	s.Target = byteOrder.Uint32(data[8:12])
 	s.Type = SegmentType(byteOrder.Uint16(data[12:14]))
 
+	if s.Length < segmentHeaderSize {
+		return fmt.Errorf("%w: segment length %d is shorter than its header", ErrBadLength, s.Length)
+	}
+
+	if uint64(len(data)) < uint64(s.Length) {
+		return fmt.Errorf("check length for segment: %w", ErrNotEnoughData)
+	}
+
 	// Decode the Segment payload depending on the type
 	payloadData := data[segmentHeaderSize:s.Length]
+	if session != nil {
+		payloadData = session.Decrypt(s.Type, payloadData)
+	}
 
 	switch s.Type {
 	case SegmentIpc:
 		s.Payload = &Ipc{}
 		if err := s.Payload.(*Ipc).UnmarshalBinary(payloadData); err != nil {
-			return err
+			return fmt.Errorf("read ipc: %w", err)
 		}
 
 	case SegmentClientKeepAlive, SegmentServerKeepAlive:
 		s.Payload = &KeepAlive{}
 		if err := s.Payload.(*KeepAlive).UnmarshalBinary(payloadData); err != nil {
-			return err
+			return fmt.Errorf("read keep-alive: %w", err)
+		}
+
+	case SegmentEncryptionInit:
+		init := &EncryptionInit{}
+		if err := init.UnmarshalBinary(payloadData); err != nil {
+			return fmt.Errorf("read encryption init: %w", err)
+		}
+
+		s.Payload = init
+
+		if session != nil {
+			if err := session.Start(init); err != nil {
+				return fmt.Errorf("start lobby session: %w", err)
+			}
 		}
 
+	case SegmentEncryptionResponse:
+		s.Payload = slices.Clone(payloadData)
+
 	default:
 		log.Debugf("Segment has unknown type %d; storing payload as cloned []byte", s.Type)
 		s.Payload = slices.Clone(payloadData)
@@ -88,32 +133,143 @@ func (s *Segment) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
+// Decodes a Segment from its JSON, decoding the payload as the type that
+// UnmarshalBinary would give it, so that the Segment can be encoded again.
+func (s *Segment) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Source  uint32          `json:"source"`
+		Target  uint32          `json:"target"`
+		Type    SegmentType     `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+	}
+
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("unmarshal segment: %w", err)
+	}
+
+	s.Source = v.Source
+	s.Target = v.Target
+	s.Type = v.Type
+
+	switch s.Type {
+	case SegmentIpc:
+		s.Payload = &Ipc{}
+	case SegmentClientKeepAlive, SegmentServerKeepAlive:
+		s.Payload = &KeepAlive{}
+	case SegmentEncryptionInit:
+		s.Payload = &EncryptionInit{}
+	default:
+		s.Payload = &[]byte{}
+	}
+
+	if err := json.Unmarshal(v.Payload, s.Payload); err != nil {
+		return fmt.Errorf("unmarshal %s payload: %w", s.Type, err)
+	}
+
+	if p, ok := s.Payload.(*[]byte); ok {
+		s.Payload = *p
+	}
+
+	return nil
+}
+
+// Encodes the Segment as it would appear in a Bundle payload. The Length
+// field is ignored and recalculated from the encoded payload.
+func (s *Segment) MarshalBinary() ([]byte, error) {
+	return s.appendBinary(nil)
+}
+
+// Appends the encoded Segment to dst.
+func (s *Segment) appendBinary(dst []byte) ([]byte, error) {
+	var payload []byte
+
+	switch p := s.Payload.(type) {
+	case encoding.BinaryMarshaler:
+		var err error
+		if payload, err = p.MarshalBinary(); err != nil {
+			return nil, fmt.Errorf("marshal %s payload: %w", s.Type, err)
+		}
+
+	case []byte:
+		payload = p
+
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrBadPayload, s.Payload)
+	}
+
+	length := segmentHeaderSize + len(payload)
+	if uint64(length) > math.MaxUint32 {
+		return nil, fmt.Errorf("%w: %d byte segment", ErrTooLarge, length)
+	}
+
+	// Write the Segment header, followed by the payload
+	var header [segmentHeaderSize]byte
+	byteOrder.PutUint32(header[0:4], uint32(length))
+	byteOrder.PutUint32(header[4:8], s.Source)
+	byteOrder.PutUint32(header[8:12], s.Target)
+	byteOrder.PutUint16(header[12:14], uint16(s.Type))
+
+	dst = append(dst, header[:]...)
+
+	return append(dst, payload...), nil
+}
+
 type Ipc struct {
-	Magic    uint16 `json:"-"`
+	Magic    uint16 `json:"magic"`
 	Type     uint16 `json:"type"`
 	ServerID uint16 `json:"serverId"`
 	Epoch    uint32 `json:"epoch"`
 
+	// The header bytes between Type and ServerID, and after Epoch, whose
+	// meaning is unknown. They are kept so that the IPC encodes as it was decoded.
+	Reserved1 uint16 `json:"reserved1,omitempty"`
+	Reserved2 uint32 `json:"reserved2,omitempty"`
+
 	Data []byte `json:"data"`
+
+	// The name of Type in the opcode table used to decode the IPC, if it has one.
+	// It isn't part of the IPC, so it is ignored by MarshalBinary.
+	Name string `json:"name,omitempty"`
+
+	// The world and data center of ServerID, if a Resolver knows them.
+	// They aren't part of the IPC, so they are ignored by MarshalBinary.
+	World      string `json:"world,omitempty"`
+	DataCenter string `json:"dataCenter,omitempty"`
 }
 
 func (i *Ipc) UnmarshalBinary(data []byte) error {
 	if len(data) < ipcHeaderSize {
-		return ErrNotEnoughData
+		return fmt.Errorf("check length for header: %w", ErrNotEnoughData)
 	}
 
 	// Read the IPC header
 	i.Magic = byteOrder.Uint16(data[0:2])
 	i.Type = byteOrder.Uint16(data[2:4])
+	i.Reserved1 = byteOrder.Uint16(data[4:6])
 	i.ServerID = byteOrder.Uint16(data[6:8])
 	i.Epoch = byteOrder.Uint32(data[8:12])
+	i.Reserved2 = byteOrder.Uint32(data[12:16])
 
 	// Copy the IPC payload so it doesn't change without us noticing
-	i.Data = slices.Clone(data[16:])
+	i.Data = slices.Clone(data[ipcHeaderSize:])
 
 	return nil
 }
 
+func (i *Ipc) MarshalBinary() ([]byte, error) {
+	data := make([]byte, ipcHeaderSize, ipcHeaderSize+len(i.Data))
+
+	// Write the IPC header, followed by the payload
+	byteOrder.PutUint16(data[0:2], i.Magic)
+	byteOrder.PutUint16(data[2:4], i.Type)
+	byteOrder.PutUint16(data[4:6], i.Reserved1)
+	byteOrder.PutUint16(data[6:8], i.ServerID)
+	byteOrder.PutUint32(data[8:12], i.Epoch)
+	byteOrder.PutUint32(data[12:16], i.Reserved2)
+
+	return append(data, i.Data...), nil
+}
+
 type KeepAlive struct {
 	ID    uint32 `json:"id"`
 	Epoch uint32 `json:"epoch"`
@@ -121,17 +277,29 @@ type KeepAlive struct {
 
 func (k *KeepAlive) UnmarshalBinary(data []byte) error {
 	if len(data) < keepAliveSize {
-		return ErrNotEnoughData
+		return fmt.Errorf("check length for keep-alive: %w", ErrNotEnoughData)
 	}
 
 	k.ID = byteOrder.Uint32(data[0:4])
-	k.ID = byteOrder.Uint32(data[4:8])
+	k.Epoch = byteOrder.Uint32(data[4:8])
 
 	return nil
 }
 
+func (k *KeepAlive) MarshalBinary() ([]byte, error) {
+	data := make([]byte, keepAliveSize)
+	byteOrder.PutUint32(data[0:4], k.ID)
+	byteOrder.PutUint32(data[4:8], k.Epoch)
+
+	return data, nil
+}
+
 var (
+	_ encoding.BinaryMarshaler   = (*Segment)(nil)
 	_ encoding.BinaryUnmarshaler = (*Segment)(nil)
+	_ json.Unmarshaler           = (*Segment)(nil)
+	_ encoding.BinaryMarshaler   = (*Ipc)(nil)
 	_ encoding.BinaryUnmarshaler = (*Ipc)(nil)
+	_ encoding.BinaryMarshaler   = (*KeepAlive)(nil)
 	_ encoding.BinaryUnmarshaler = (*KeepAlive)(nil)
 )
@@ -3,7 +3,11 @@ package ffxiv_test
 import (
 	"testing"
 
+	"github.com/goccy/go-json"
 	"github.com/sparta142/goblade/ffxiv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slices"
 )
 
 var segmentData = []byte{
@@ -40,9 +44,136 @@ var segmentData = []byte{
 	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
 }
 
+func TestSegment_MarshalBinary(t *testing.T) {
+	t.Parallel()
+
+	var s ffxiv.Segment
+	require.NoError(t, s.UnmarshalBinary(segmentData))
+
+	data, err := s.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, segmentData, data)
+}
+
+func TestSegment_UnmarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	// An IPC with its reserved header bytes set
+	ipcData := slices.Clone(segmentData)
+	copy(ipcData[20:22], []byte{0x01, 0x02})
+	copy(ipcData[28:32], []byte{0x03, 0x04, 0x05, 0x06})
+
+	keepAliveData := []byte{
+		0x18, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00,
+		0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00,
+	}
+
+	unknownData := []byte{
+		0x13, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00,
+		0x02, 0x00, 0x00, 0x00, 0x63, 0x00, 0x00, 0x00,
+		0xaa, 0xbb, 0xcc,
+	}
+
+	for name, segmentData := range map[string][]byte{
+		"Ipc":       ipcData,
+		"KeepAlive": keepAliveData,
+		"Unknown":   unknownData,
+	} {
+		segmentData := segmentData
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var s ffxiv.Segment
+			require.NoError(t, s.UnmarshalBinary(segmentData))
+
+			text, err := json.Marshal(&s)
+			require.NoError(t, err)
+
+			var decoded ffxiv.Segment
+			require.NoError(t, json.Unmarshal(text, &decoded))
+			assert.IsType(t, s.Payload, decoded.Payload)
+
+			data, err := decoded.MarshalBinary()
+			require.NoError(t, err)
+			assert.Equal(t, segmentData, data)
+		})
+	}
+}
+
+func TestSegment_MarshalBinary_BadPayload(t *testing.T) {
+	t.Parallel()
+
+	s := ffxiv.Segment{Type: ffxiv.SegmentIpc, Payload: "not a payload"}
+	_, err := s.MarshalBinary()
+	assert.ErrorIs(t, err, ffxiv.ErrBadPayload)
+}
+
+func TestKeepAlive_MarshalBinary(t *testing.T) {
+	t.Parallel()
+
+	k := ffxiv.KeepAlive{ID: 0x01020304, Epoch: 1624314019}
+	data, err := k.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x04, 0x03, 0x02, 0x01, 0xa3, 0x10, 0xd1, 0x60}, data)
+}
+
+func TestKeepAlive_UnmarshalBinary(t *testing.T) {
+	t.Parallel()
+
+	var k ffxiv.KeepAlive
+	require.NoError(t, k.UnmarshalBinary([]byte{0x04, 0x03, 0x02, 0x01, 0xa3, 0x10, 0xd1, 0x60}))
+	assert.Equal(t, ffxiv.KeepAlive{ID: 0x01020304, Epoch: 1624314019}, k)
+}
+
 func Benchmark_Segment_UnmarshalBinary(b *testing.B) {
 	var s ffxiv.Segment
 	for n := 0; n < b.N; n++ {
 		_ = s.UnmarshalBinary(segmentData)
 	}
 }
+
+func FuzzSegment_UnmarshalBinary(f *testing.F) {
+	f.Add(segmentData)
+	f.Add(uncompressedBundleData[40:])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var segment ffxiv.Segment
+		if err := segment.UnmarshalBinary(data); err != nil {
+			return
+		}
+
+		assert.LessOrEqual(t, int(segment.Length), len(data))
+	})
+}
+
+func FuzzIpc_UnmarshalBinary(f *testing.F) {
+	f.Add(segmentData[16:])
+	f.Add(segmentData[16:32])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var ipc ffxiv.Ipc
+		if err := ipc.UnmarshalBinary(data); err != nil {
+			return
+		}
+
+		assert.Len(t, ipc.Data, len(data)-16)
+	})
+}
+
+func FuzzKeepAlive_UnmarshalBinary(f *testing.F) {
+	f.Add([]byte{0x04, 0x03, 0x02, 0x01, 0xa3, 0x10, 0xd1, 0x60})
+	f.Add([]byte{0x04, 0x03, 0x02})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var keepAlive ffxiv.KeepAlive
+		if err := keepAlive.UnmarshalBinary(data); err != nil {
+			return
+		}
+
+		encoded, err := keepAlive.MarshalBinary()
+		require.NoError(t, err)
+		assert.Equal(t, data[:8], encoded)
+	})
+}
@@ -0,0 +1,174 @@
+package ffxiv
+
+import (
+	_ "embed"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+
+	"github.com/goccy/go-json"
+	"golang.org/x/exp/slices"
+)
+
+// The known data center networks and worlds. Like the opcodes, this can be
+// replaced with a file in the same format when it changes. The networks of
+// the Chinese and Korean services aren't known yet, so all of them are global.
+//
+//go:embed servers.json
+var serversJSON []byte
+
+// ServerNetwork is a public FINAL FANTASY XIV data center IP network.
+//
+// Found by resolving each lobby domain to its IPv4 address, then looking up
+// the assigned address block that contains it in ARIN.
+type ServerNetwork struct {
+	// The network in string CIDR notation.
+	CIDR string `json:"cidr"`
+
+	// The opcode region of the servers in the network.
+	Region Region `json:"region"`
+
+	// The lobby domains that resolve to the network.
+	Lobbies []string `json:"lobbies"`
+
+	// The data centers hosted in the network.
+	DataCenters []string `json:"dataCenters"`
+}
+
+// World is a game world, identified by the server ID of its IPCs.
+type World struct {
+	ID         uint16 `json:"id"`
+	Name       string `json:"name"`
+	DataCenter string `json:"dataCenter"`
+}
+
+// Resolver maps server addresses to data centers and server IDs to worlds.
+type Resolver struct {
+	networks []ServerNetwork
+	ipnets   []net.IPNet
+	worlds   map[uint16]World
+}
+
+// Parses a Resolver from JSON in the same format as the embedded servers:
+// an object with "networks" and "worlds" arrays.
+func ParseResolver(data []byte) (*Resolver, error) {
+	var raw struct {
+		Networks []ServerNetwork `json:"networks"`
+		Worlds   []World         `json:"worlds"`
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("unmarshal servers: %w", err)
+	}
+
+	r := &Resolver{
+		networks: raw.Networks,
+		ipnets:   make([]net.IPNet, len(raw.Networks)),
+		worlds:   make(map[uint16]World, len(raw.Worlds)),
+	}
+
+	for i, n := range raw.Networks {
+		_, ipnet, err := net.ParseCIDR(n.CIDR)
+		if err != nil {
+			return nil, fmt.Errorf("parse server network: %w", err)
+		}
+
+		r.ipnets[i] = *ipnet
+	}
+
+	for _, w := range raw.Worlds {
+		r.worlds[w.ID] = w
+	}
+
+	return r, nil
+}
+
+// Reads a Resolver from a file in the same format as the embedded servers.
+func LoadResolver(path string) (*Resolver, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read servers file: %w", err)
+	}
+
+	r, err := ParseResolver(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse servers file %s: %w", path, err)
+	}
+
+	return r, nil
+}
+
+// Gets all of the known networks. The result must not be modified.
+func (r *Resolver) Networks() []ServerNetwork {
+	return r.networks
+}
+
+// Gets the opcode regions of the known networks, in the order they first appear.
+func (r *Resolver) Regions() []Region {
+	var regions []Region
+
+	for _, n := range r.networks {
+		if !slices.Contains(regions, n.Region) {
+			regions = append(regions, n.Region)
+		}
+	}
+
+	return regions
+}
+
+// Gets the network containing ip. It returns false if ip is not in a known network.
+func (r *Resolver) Network(ip net.IP) (ServerNetwork, bool) {
+	for i, ipnet := range r.ipnets {
+		if ipnet.Contains(ip) {
+			return r.networks[i], true
+		}
+	}
+
+	return ServerNetwork{}, false
+}
+
+// Gets the world with a server ID. It returns false if the world is unknown.
+func (r *Resolver) World(serverID uint16) (World, bool) {
+	w, ok := r.worlds[serverID]
+	return w, ok
+}
+
+// Sets the world and data center of each Ipc in the bundle from its server ID.
+func (r *Resolver) ResolveBundle(b *Bundle) {
+	for i := range b.Segments {
+		ipc, ok := b.Segments[i].Payload.(*Ipc)
+		if !ok {
+			continue
+		}
+
+		if w, ok := r.World(ipc.ServerID); ok {
+			ipc.World = w.Name
+			ipc.DataCenter = w.DataCenter
+		}
+	}
+}
+
+var defaultResolver = func() *atomic.Pointer[Resolver] {
+	r, err := ParseResolver(serversJSON)
+	if err != nil {
+		panic(err)
+	}
+
+	var p atomic.Pointer[Resolver]
+	p.Store(r)
+
+	return &p
+}()
+
+// Gets the Resolver used by IsFinalFantasyIP, RegionOf and captures.
+// Unless replaced, it resolves from the servers embedded at build time.
+func DefaultResolver() *Resolver {
+	return defaultResolver.Load()
+}
+
+// Replaces the Resolver returned by DefaultResolver.
+// Captures that have already started may not notice the change.
+func SetDefaultResolver(r *Resolver) {
+	defaultResolver.Store(r)
+}
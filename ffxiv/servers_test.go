@@ -0,0 +1,66 @@
+package ffxiv_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sparta142/goblade/ffxiv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultResolver(t *testing.T) {
+	t.Parallel()
+
+	r := ffxiv.DefaultResolver()
+
+	network, ok := r.Network(net.ParseIP("153.254.80.75"))
+	require.True(t, ok)
+	assert.Equal(t, ffxiv.RegionGlobal, network.Region)
+	assert.Equal(t, []string{"Materia"}, network.DataCenters)
+	assert.Equal(t, []ffxiv.Region{ffxiv.RegionGlobal}, r.Regions())
+
+	world, ok := r.World(90)
+	require.True(t, ok)
+	assert.Equal(t, ffxiv.World{ID: 90, Name: "Aegis", DataCenter: "Elemental"}, world)
+
+	_, ok = r.World(0)
+	assert.False(t, ok)
+}
+
+func TestLoadResolver(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "servers.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"networks": [{"cidr": "10.0.0.0/8", "region": "KR", "dataCenters": ["Test"]}],
+		"worlds": [{"id": 2075, "name": "Carbuncle", "dataCenter": "Test"}]
+	}`), 0o600))
+
+	r, err := ffxiv.LoadResolver(path)
+	require.NoError(t, err)
+
+	network, ok := r.Network(net.ParseIP("10.1.2.3"))
+	require.True(t, ok)
+	assert.Equal(t, ffxiv.RegionKorea, network.Region)
+	assert.Equal(t, []ffxiv.Region{ffxiv.RegionKorea}, r.Regions())
+
+	_, ok = r.Network(net.ParseIP("204.2.229.84"))
+	assert.False(t, ok)
+
+	bundle := ffxiv.Bundle{Segments: []ffxiv.Segment{
+		{Type: ffxiv.SegmentIpc, Payload: &ffxiv.Ipc{ServerID: 2075}},
+		{Type: ffxiv.SegmentIpc, Payload: &ffxiv.Ipc{ServerID: 1}},
+		{Type: ffxiv.SegmentClientKeepAlive, Payload: &ffxiv.KeepAlive{}},
+	}}
+	r.ResolveBundle(&bundle)
+
+	assert.Equal(t, "Carbuncle", bundle.Segments[0].Payload.(*ffxiv.Ipc).World)
+	assert.Equal(t, "Test", bundle.Segments[0].Payload.(*ffxiv.Ipc).DataCenter)
+	assert.Empty(t, bundle.Segments[1].Payload.(*ffxiv.Ipc).World)
+
+	_, err = ffxiv.ParseResolver([]byte(`{"networks": [{"cidr": "bogus"}]}`))
+	assert.Error(t, err)
+}
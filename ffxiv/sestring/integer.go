@@ -0,0 +1,54 @@
+package sestring
+
+import "fmt"
+
+// Markers below this value encode a small integer directly (offset by 1).
+const packedIntegerThreshold = 0xD0
+
+// Marker prefix for integers that are packed as a variable number of bytes.
+const packedIntegerMarker = 0xF0
+
+// Reads a SeString packed integer from the front of data,
+// returning the value and the number of bytes consumed.
+//
+// Integers below 0xCF are stored as a single byte (value + 1). Larger
+// integers are stored as a marker byte whose low nibble (plus one) is a
+// bitmask of which big-endian bytes of the uint32 follow the marker.
+// The markers 0x00 and 0xFF, which would encode -1 and no bytes at all,
+// are malformed.
+func readInteger(data []byte) (value uint32, n int, err error) {
+	if len(data) == 0 {
+		return 0, 0, fmt.Errorf("read integer marker: %w", ErrTruncated)
+	}
+
+	marker := data[0]
+	if marker == 0 || marker == 0xff {
+		return 0, 0, fmt.Errorf("%w: integer marker 0x%02x", ErrMalformed, marker)
+	}
+
+	if marker < packedIntegerThreshold {
+		return uint32(marker) - 1, 1, nil
+	}
+
+	if marker&packedIntegerMarker != packedIntegerMarker {
+		return 0, 0, fmt.Errorf("%w: integer marker 0x%02x", ErrMalformed, marker)
+	}
+
+	mask := (marker + 1) & 0x0f
+	n = 1
+
+	for i := 3; i >= 0; i-- {
+		if mask&(1<<i) == 0 {
+			continue
+		}
+
+		if n >= len(data) {
+			return 0, 0, fmt.Errorf("read integer byte: %w", ErrTruncated)
+		}
+
+		value |= uint32(data[n]) << (8 * i)
+		n++
+	}
+
+	return value, n, nil
+}
@@ -0,0 +1,340 @@
+package sestring
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+type PayloadType uint8
+
+const (
+	// Pseudo-type for the literal text between macro payloads.
+	PayloadText = PayloadType(0x00)
+
+	PayloadNewLine       = PayloadType(0x10)
+	PayloadIcon          = PayloadType(0x12)
+	PayloadEmphasis      = PayloadType(0x1a)
+	PayloadHyphen        = PayloadType(0x1f)
+	PayloadLink          = PayloadType(0x27)
+	PayloadAutoTranslate = PayloadType(0x2e)
+	PayloadUIForeground  = PayloadType(0x48)
+	PayloadUIGlow        = PayloadType(0x49)
+)
+
+// The sub-type of a PayloadLink (called "interactable" in the client).
+type LinkType uint8
+
+const (
+	LinkPlayer      = LinkType(0x01)
+	LinkItem        = LinkType(0x03)
+	LinkMapPosition = LinkType(0x04)
+	LinkQuest       = LinkType(0x05)
+	LinkStatus      = LinkType(0x06)
+	LinkTerminator  = LinkType(0xce)
+)
+
+// Item IDs above these offsets carry extra meaning in item links.
+const (
+	collectibleItemOffset = 500_000
+	hqItemOffset          = 1_000_000
+	eventItemOffset       = 2_000_000
+)
+
+// The number of unknown bytes between fields in player and item links.
+const (
+	playerLinkUnknownSize = 2
+	itemLinkUnknownSize   = 3
+)
+
+// Payload is a single node of a parsed SeString.
+type Payload interface {
+	// The type of the payload, as written after the start byte.
+	Type() PayloadType
+
+	// Appends the plain text representation of the payload to b.
+	writeText(b *strings.Builder)
+}
+
+// Text is literal text between macro payloads.
+type Text struct {
+	Text string `json:"text"`
+}
+
+func (*Text) Type() PayloadType { return PayloadText }
+
+func (t *Text) writeText(b *strings.Builder) { b.WriteString(t.Text) }
+
+// NewLine is a line break.
+type NewLine struct{}
+
+func (*NewLine) Type() PayloadType { return PayloadNewLine }
+
+func (*NewLine) writeText(b *strings.Builder) { b.WriteByte('\n') }
+
+// Hyphen is a dash in the text, which is written as an en dash (U+2013).
+type Hyphen struct{}
+
+func (*Hyphen) Type() PayloadType { return PayloadHyphen }
+
+func (*Hyphen) writeText(b *strings.Builder) { b.WriteString("–") }
+
+// Icon is an inline icon from the client's icon font.
+type Icon struct {
+	ID uint32 `json:"id"`
+}
+
+func (*Icon) Type() PayloadType { return PayloadIcon }
+
+func (*Icon) writeText(*strings.Builder) {}
+
+// Emphasis toggles italic text on or off.
+type Emphasis struct {
+	Enabled bool `json:"enabled"`
+}
+
+func (*Emphasis) Type() PayloadType { return PayloadEmphasis }
+
+func (*Emphasis) writeText(*strings.Builder) {}
+
+// Color starts (or, with a zero Key, ends) a foreground or glow colour
+// taken from the client's UIColor sheet.
+type Color struct {
+	Glow bool   `json:"glow"`
+	Key  uint32 `json:"key"`
+}
+
+func (c *Color) Type() PayloadType {
+	if c.Glow {
+		return PayloadUIGlow
+	}
+
+	return PayloadUIForeground
+}
+
+func (*Color) writeText(*strings.Builder) {}
+
+// Whether this payload ends the previous colour instead of starting a new one.
+func (c *Color) IsReset() bool {
+	return c.Key == 0
+}
+
+// AutoTranslate is a phrase from the client's Completion sheet. The phrase
+// text is not part of the SeString, so it renders as "[group:key]".
+type AutoTranslate struct {
+	Group uint8  `json:"group"`
+	Key   uint32 `json:"key"`
+}
+
+func (*AutoTranslate) Type() PayloadType { return PayloadAutoTranslate }
+
+func (a *AutoTranslate) writeText(b *strings.Builder) {
+	fmt.Fprintf(b, "[%d:%d]", a.Group, a.Key)
+}
+
+// PlayerLink starts a link to a player character. The visible name
+// follows as Text and is closed by a LinkEnd.
+type PlayerLink struct {
+	ServerID uint32 `json:"serverId"`
+	Name     string `json:"name"`
+}
+
+func (*PlayerLink) Type() PayloadType { return PayloadLink }
+
+func (*PlayerLink) writeText(*strings.Builder) {}
+
+// ItemLink starts a link to an item. The visible name
+// follows as Text and is closed by a LinkEnd.
+type ItemLink struct {
+	// The item ID, with the HQ/collectible/event offsets removed.
+	ItemID uint32 `json:"itemId"`
+
+	HighQuality bool `json:"hq"`
+	Collectible bool `json:"collectible"`
+	EventItem   bool `json:"eventItem"`
+
+	// The item name embedded in the link, if any.
+	Name string `json:"name,omitempty"`
+}
+
+func (*ItemLink) Type() PayloadType { return PayloadLink }
+
+func (*ItemLink) writeText(*strings.Builder) {}
+
+// LinkEnd closes the most recent PlayerLink, ItemLink or Link.
+type LinkEnd struct{}
+
+func (*LinkEnd) Type() PayloadType { return PayloadLink }
+
+func (*LinkEnd) writeText(*strings.Builder) {}
+
+// Link is any other kind of link, such as a map position or quest.
+type Link struct {
+	LinkType LinkType `json:"linkType"`
+	Data     []byte   `json:"data"`
+}
+
+func (*Link) Type() PayloadType { return PayloadLink }
+
+func (*Link) writeText(*strings.Builder) {}
+
+// Unknown is a macro payload that this package does not interpret.
+type Unknown struct {
+	PayloadType PayloadType `json:"payloadType"`
+	Data        []byte      `json:"data"`
+}
+
+func (u *Unknown) Type() PayloadType { return u.PayloadType }
+
+func (*Unknown) writeText(*strings.Builder) {}
+
+// Decodes the body of a macro payload (everything between the
+// length and the end byte) into its typed representation.
+func decodePayload(typ PayloadType, body []byte) (Payload, error) { //nolint:ireturn
+	switch typ {
+	case PayloadNewLine:
+		return &NewLine{}, nil
+
+	case PayloadHyphen:
+		return &Hyphen{}, nil
+
+	case PayloadIcon:
+		id, _, err := readInteger(body)
+		if err != nil {
+			return nil, fmt.Errorf("read icon id: %w", err)
+		}
+
+		return &Icon{ID: id}, nil
+
+	case PayloadEmphasis:
+		enabled, _, err := readInteger(body)
+		if err != nil {
+			return nil, fmt.Errorf("read emphasis flag: %w", err)
+		}
+
+		return &Emphasis{Enabled: enabled == 1}, nil
+
+	case PayloadUIForeground, PayloadUIGlow:
+		key, _, err := readInteger(body)
+		if err != nil {
+			return nil, fmt.Errorf("read color key: %w", err)
+		}
+
+		return &Color{Glow: typ == PayloadUIGlow, Key: key}, nil
+
+	case PayloadAutoTranslate:
+		return decodeAutoTranslate(body)
+
+	case PayloadLink:
+		return decodeLink(body)
+
+	default:
+		return &Unknown{PayloadType: typ, Data: slices.Clone(body)}, nil
+	}
+}
+
+func decodeAutoTranslate(body []byte) (*AutoTranslate, error) {
+	if len(body) == 0 {
+		return nil, fmt.Errorf("read auto-translate group: %w", ErrTruncated)
+	}
+
+	key, _, err := readInteger(body[1:])
+	if err != nil {
+		return nil, fmt.Errorf("read auto-translate key: %w", err)
+	}
+
+	return &AutoTranslate{Group: body[0], Key: key}, nil
+}
+
+func decodeLink(body []byte) (Payload, error) { //nolint:ireturn
+	if len(body) == 0 {
+		return nil, fmt.Errorf("read link type: %w", ErrTruncated)
+	}
+
+	linkType, body := LinkType(body[0]), body[1:]
+
+	switch linkType {
+	case LinkPlayer:
+		return decodePlayerLink(body)
+
+	case LinkItem:
+		return decodeItemLink(body)
+
+	case LinkTerminator:
+		return &LinkEnd{}, nil
+
+	default:
+		return &Link{LinkType: linkType, Data: slices.Clone(body)}, nil
+	}
+}
+
+func decodePlayerLink(body []byte) (*PlayerLink, error) {
+	// Skip an unknown byte (usually 0x01)
+	if len(body) < 1 {
+		return nil, fmt.Errorf("read player link header: %w", ErrTruncated)
+	}
+
+	serverID, n, err := readInteger(body[1:])
+	if err != nil {
+		return nil, fmt.Errorf("read player server id: %w", err)
+	}
+
+	// Skip more unknown bytes (usually 0x01 0xFF)
+	body = body[1+n:]
+	if len(body) < playerLinkUnknownSize {
+		return nil, fmt.Errorf("read player link padding: %w", ErrTruncated)
+	}
+
+	name, err := readSizedString(body[playerLinkUnknownSize:])
+	if err != nil {
+		return nil, fmt.Errorf("read player name: %w", err)
+	}
+
+	return &PlayerLink{ServerID: serverID, Name: name}, nil
+}
+
+func decodeItemLink(body []byte) (*ItemLink, error) {
+	rawID, n, err := readInteger(body)
+	if err != nil {
+		return nil, fmt.Errorf("read item id: %w", err)
+	}
+
+	link := &ItemLink{ItemID: rawID}
+
+	switch {
+	case rawID > eventItemOffset:
+		link.ItemID -= eventItemOffset
+		link.EventItem = true
+	case rawID > hqItemOffset:
+		link.ItemID -= hqItemOffset
+		link.HighQuality = true
+	case rawID > collectibleItemOffset:
+		link.ItemID -= collectibleItemOffset
+		link.Collectible = true
+	}
+
+	// The name is optional, and follows some unknown bytes (usually 0x02 0x01 0xFF)
+	body = body[n:]
+	if len(body) > itemLinkUnknownSize {
+		if link.Name, err = readSizedString(body[itemLinkUnknownSize:]); err != nil {
+			return nil, fmt.Errorf("read item name: %w", err)
+		}
+	}
+
+	return link, nil
+}
+
+// Reads a string prefixed by its packed integer length.
+func readSizedString(data []byte) (string, error) {
+	length, n, err := readInteger(data)
+	if err != nil {
+		return "", fmt.Errorf("read string length: %w", err)
+	}
+
+	if uint64(len(data)-n) < uint64(length) {
+		return "", fmt.Errorf("read string of length %d: %w", length, ErrTruncated)
+	}
+
+	return string(data[n : n+int(length)]), nil
+}
@@ -0,0 +1,194 @@
+// Package sestring parses SeStrings, the rich text format used by
+// FINAL FANTASY XIV for names, chat messages, item links and more.
+//
+// A SeString is a sequence of literal text and macro payloads. Each payload
+// is framed as 0x02, a type byte, a packed integer length, the payload body
+// and finally 0x03.
+package sestring
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	startByte = 0x02
+	endByte   = 0x03
+)
+
+var (
+	ErrMalformed = errors.New("sestring: malformed payload")
+	ErrTruncated = errors.New("sestring: truncated payload")
+)
+
+// String is a parsed SeString.
+type String struct {
+	Payloads []Payload
+}
+
+// Parses a SeString from data. Parsing stops at the first NUL byte outside
+// of a payload, so fixed-size, NUL-padded fields can be passed as-is.
+func Parse(data []byte) (String, error) {
+	var s String
+	err := s.UnmarshalBinary(data)
+
+	return s, err
+}
+
+func (s *String) UnmarshalBinary(data []byte) error {
+	s.Payloads = nil
+
+	for offset := 0; offset < len(data); {
+		// Everything up to the next start byte (or NUL terminator) is literal text
+		idx := bytes.IndexAny(data[offset:], "\x00\x02")
+		if idx == -1 {
+			idx = len(data) - offset
+		}
+
+		if idx > 0 {
+			s.Payloads = append(s.Payloads, &Text{Text: string(data[offset : offset+idx])})
+			offset += idx
+
+			continue
+		}
+
+		if data[offset] == 0 {
+			break
+		}
+
+		payload, n, err := readPayload(data[offset:])
+		if err != nil {
+			return fmt.Errorf("read payload at offset %d: %w", offset, err)
+		}
+
+		s.Payloads = append(s.Payloads, payload)
+		offset += n
+	}
+
+	return nil
+}
+
+// Reads one framed macro payload from the front of data,
+// returning the payload and the number of bytes consumed.
+func readPayload(data []byte) (Payload, int, error) { //nolint:ireturn
+	// Start byte and type
+	if len(data) < 2 {
+		return nil, 0, fmt.Errorf("read payload type: %w", ErrTruncated)
+	}
+
+	typ := PayloadType(data[1])
+
+	length, n, err := readInteger(data[2:])
+	if err != nil {
+		return nil, 0, fmt.Errorf("read payload length: %w", err)
+	}
+
+	bodyStart := 2 + n
+	if uint64(len(data)-bodyStart) <= uint64(length) {
+		return nil, 0, fmt.Errorf("read %d byte payload body: %w", length, ErrTruncated)
+	}
+
+	bodyEnd := bodyStart + int(length)
+	if data[bodyEnd] != endByte {
+		return nil, 0, fmt.Errorf("%w: expected end byte, got 0x%02x", ErrMalformed, data[bodyEnd])
+	}
+
+	// Payloads can hold expressions that this package doesn't evaluate,
+	// so keep the raw body of anything that doesn't decode rather than
+	// failing the whole string.
+	body := data[bodyStart:bodyEnd]
+
+	payload, err := decodePayload(typ, body)
+	if err != nil {
+		payload = &Unknown{PayloadType: typ, Data: slices.Clone(body)}
+	}
+
+	return payload, bodyEnd + 1, nil
+}
+
+// Renders the SeString as plain text, dropping formatting and links.
+func (s String) Text() string {
+	var b strings.Builder
+	for _, p := range s.Payloads {
+		p.writeText(&b)
+	}
+
+	return b.String()
+}
+
+func (s String) String() string {
+	return s.Text()
+}
+
+// Renders the SeString as a JSON array of payload objects,
+// each with a "type" field naming the kind of payload.
+func (s String) MarshalJSON() ([]byte, error) {
+	buf := []byte{'['}
+
+	for i, p := range s.Payloads {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+
+		fields, err := json.Marshal(p)
+		if err != nil {
+			return nil, fmt.Errorf("marshal %s payload: %w", payloadKind(p), err)
+		}
+
+		buf = append(buf, `{"type":"`...)
+		buf = append(buf, payloadKind(p)...)
+		buf = append(buf, '"')
+
+		// Splice the payload's own fields (if any) into the object
+		if len(fields) > len("{}") {
+			buf = append(buf, ',')
+			buf = append(buf, fields[1:len(fields)-1]...)
+		}
+
+		buf = append(buf, '}')
+	}
+
+	return append(buf, ']'), nil
+}
+
+// Gets the name of a payload's kind, as used in JSON output.
+func payloadKind(p Payload) string {
+	switch p.(type) {
+	case *Text:
+		return "text"
+	case *NewLine:
+		return "newLine"
+	case *Hyphen:
+		return "hyphen"
+	case *Icon:
+		return "icon"
+	case *Emphasis:
+		return "emphasis"
+	case *Color:
+		return "color"
+	case *AutoTranslate:
+		return "autoTranslate"
+	case *PlayerLink:
+		return "playerLink"
+	case *ItemLink:
+		return "itemLink"
+	case *LinkEnd:
+		return "linkEnd"
+	case *Link:
+		return "link"
+	default:
+		return "unknown"
+	}
+}
+
+var (
+	_ encoding.BinaryUnmarshaler = (*String)(nil)
+	_ json.Marshaler             = String{}
+	_ fmt.Stringer               = String{}
+)
@@ -0,0 +1,161 @@
+package sestring_test
+
+import (
+	"testing"
+
+	"github.com/goccy/go-json"
+	"github.com/sparta142/goblade/ffxiv/sestring"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// "Buy " + an HQ Potion item link + "!" in a colour, then a newline.
+var itemLinkData = []byte(
+	"Buy " +
+		"\x02\x48\x04\xf2\x01\xf4\x03" + // UIForeground 500
+		"\x02\x27\x10\x03\xf6\x0f\x54\x07\x02\x01\xff\x07Potion\x03" + // Item link 1004551
+		"Potion" +
+		"\x02\x27\x07\xce\x01\x01\x01\xff\x01\x03" + // Link terminator
+		"\x02\x48\x02\x01\x03" + // UIForeground reset
+		"!" +
+		"\x02\x10\x01\x03", // NewLine
+)
+
+// A player link to Y'shtola Rhul on server 34, followed by an auto-translate phrase.
+var playerLinkData = []byte(
+	"\x02\x27\x14\x01\x01\x23\x01\xff\x0eY'shtola Rhul\x03" +
+		"Y'shtola Rhul" +
+		"\x02\x27\x07\xce\x01\x01\x01\xff\x01\x03" +
+		": " +
+		"\x02\x2e\x05\x04\xf2\x01\x2c\x03" + // Auto-translate 4/300
+		"\x00\x00\x00\x00", // NUL padding
+)
+
+func TestParse_ItemLink(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	s, err := sestring.Parse(itemLinkData)
+	require.NoError(t, err)
+	require.Len(t, s.Payloads, 8)
+
+	assert.Equal(&sestring.Text{Text: "Buy "}, s.Payloads[0])
+	assert.Equal(&sestring.Color{Key: 500}, s.Payloads[1])
+	assert.Equal(&sestring.ItemLink{ItemID: 4551, HighQuality: true, Name: "Potion"}, s.Payloads[2])
+	assert.Equal(&sestring.Text{Text: "Potion"}, s.Payloads[3])
+	assert.Equal(&sestring.LinkEnd{}, s.Payloads[4])
+	assert.True(s.Payloads[5].(*sestring.Color).IsReset())
+	assert.Equal(&sestring.Text{Text: "!"}, s.Payloads[6])
+	assert.Equal(&sestring.NewLine{}, s.Payloads[7])
+
+	assert.Equal("Buy Potion!\n", s.Text())
+}
+
+func TestParse_PlayerLink(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	s, err := sestring.Parse(playerLinkData)
+	require.NoError(t, err)
+	require.Len(t, s.Payloads, 5)
+
+	assert.Equal(&sestring.PlayerLink{ServerID: 34, Name: "Y'shtola Rhul"}, s.Payloads[0])
+	assert.Equal(&sestring.AutoTranslate{Group: 4, Key: 300}, s.Payloads[4])
+
+	assert.Equal("Y'shtola Rhul: [4:300]", s.Text())
+}
+
+func TestMarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	s, err := sestring.Parse(playerLinkData)
+	require.NoError(t, err)
+
+	data, err := json.Marshal(s)
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `[
+		{"type": "playerLink", "serverId": 34, "name": "Y'shtola Rhul"},
+		{"type": "text", "text": "Y'shtola Rhul"},
+		{"type": "linkEnd"},
+		{"type": "text", "text": ": "},
+		{"type": "autoTranslate", "group": 4, "key": 300}
+	]`, string(data))
+}
+
+func TestParse_Malformed(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		data []byte
+		err  error
+	}{
+		"missing type":     {[]byte("\x02"), sestring.ErrTruncated},
+		"missing length":   {[]byte("\x02\x10"), sestring.ErrTruncated},
+		"body too short":   {[]byte("\x02\x48\x05\x01\x03"), sestring.ErrTruncated},
+		"missing end byte": {[]byte("\x02\x10\x01\x04"), sestring.ErrMalformed},
+		"bad length":       {[]byte("\x02\x10\xe0\x03"), sestring.ErrMalformed},
+		"zero length":      {[]byte("\x02\x10\x00\x03"), sestring.ErrMalformed},
+		"empty length":     {[]byte("\x02\x10\xff\x03"), sestring.ErrMalformed},
+	}
+
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, err := sestring.Parse(tc.data)
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+func TestParse_UndecodableBody(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string][]byte{
+		"expression":           []byte("\x02\x12\x02\xe0\x03"),
+		"truncated integer":    []byte("\x02\x12\x02\xf2\x03"),
+		"zero integer":         []byte("\x02\x12\x02\x00\x03"),
+		"empty integer":        []byte("\x02\x12\x02\xff\x03"),
+		"truncated item name":  []byte("\x02\x27\x07\x03\x02\x02\x01\xff\x09\x03"),
+		"empty auto-translate": []byte("\x02\x2e\x01\x03"),
+	}
+
+	for name, data := range tests {
+		data := data
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s, err := sestring.Parse(data)
+			require.NoError(t, err)
+			require.Len(t, s.Payloads, 1)
+			assert.IsType(t, (*sestring.Unknown)(nil), s.Payloads[0])
+			assert.Equal(t, sestring.PayloadType(data[1]), s.Payloads[0].Type())
+		})
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add(itemLinkData)
+	f.Add(playerLinkData)
+	f.Add([]byte("Hello, world!"))
+	f.Add([]byte("\x02\x12\x02\xf2\x03"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s, err := sestring.Parse(data)
+		if err != nil {
+			return
+		}
+
+		_ = s.Text()
+
+		out, err := json.Marshal(s)
+		if err != nil {
+			t.Fatalf("marshal parsed SeString: %v", err)
+		}
+
+		if !json.Valid(out) {
+			t.Fatalf("invalid JSON: %s", out)
+		}
+	})
+}
@@ -0,0 +1,272 @@
+// Package goblade captures FINAL FANTASY XIV network traffic and decodes it
+// into a stream of typed events, for embedding in other Go programs.
+//
+// A Capture is created with New and functional options that choose where
+// packets come from and how they are decoded, then started with Run:
+//
+//	capture, err := goblade.New(goblade.WithFile("packets.pcapng"))
+//	if err != nil {
+//		return err
+//	}
+//
+//	go func() {
+//		for event := range capture.Events() {
+//			switch event := event.(type) {
+//			case *goblade.BundleEvent:
+//				fmt.Println(event.Src, len(event.Bundle.Segments))
+//			case *goblade.DataGap:
+//				fmt.Println("lost", event.Length, "bytes")
+//			}
+//		}
+//	}()
+//
+//	return capture.Run(ctx)
+package goblade
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sparta142/goblade/ffxiv"
+	"github.com/sparta142/goblade/net"
+	"github.com/sparta142/goblade/oodle"
+)
+
+var ErrAlreadyRun = errors.New("goblade: capture has already been run")
+
+// OpcodeLoader gets the opcode table for a region, such as ffxiv.GetOpcodes.
+type OpcodeLoader func(region ffxiv.Region) (ffxiv.OpcodeTable, error)
+
+// Capture decodes the traffic from one source of packets. It can only be run once.
+type Capture struct {
+	source      source
+	promiscuous bool
+	opts        net.Options
+
+	opcodes      *ffxiv.OpcodeStore
+	region       ffxiv.Region
+	loadOpcodes  OpcodeLoader
+	detect       bool // Whether to switch opcode tables to the first detected region
+	detected     bool
+	oodleBackend oodle.Backend
+
+	events  chan Event
+	running atomic.Bool
+}
+
+// Creates a Capture with the options, which by default captures live from
+// the default network interface with the global opcodes.
+func New(opts ...Option) (*Capture, error) {
+	c := &Capture{
+		source:      &liveSource{},
+		loadOpcodes: ffxiv.GetOpcodes,
+		events:      make(chan Event),
+
+		// Buffer out-of-order data up to the default limits unless told otherwise
+		opts: net.Options{
+			MaxBufferedPagesPerConnection: net.UseDefault,
+			MaxBufferedPagesTotal:         net.UseDefault,
+		},
+	}
+
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	// Files can be read as slowly as their Events are, so nothing needs dropping
+	if _, live := c.source.(*liveSource); !live && c.opts.QueuePolicy == "" {
+		c.opts.QueuePolicy = net.QueueBlock
+	}
+
+	if err := c.opts.Validate(); err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	// Detection can't fail, but it can only find the regions of known networks
+	if regions := ffxiv.DefaultResolver().Regions(); c.detect && len(regions) < 2 {
+		log.WithField("regions", regions).Warn("The known data center networks are all in one region, " +
+			"so no other region can be detected, and unless there is a filter, only their traffic is captured")
+	}
+
+	// Load the opcodes, unless the store was given a table already
+	if c.opcodes == nil {
+		c.opcodes = &ffxiv.OpcodeStore{}
+	}
+
+	if c.region != "" || c.opcodes.Load() == nil {
+		if c.region == "" {
+			c.region = ffxiv.RegionGlobal
+		}
+
+		table, err := c.loadOpcodes(c.region)
+		if err != nil {
+			return nil, err
+		}
+
+		c.opcodes.Store(table)
+	}
+
+	// Don't filter out the traffic of a region without known networks
+	if c.opts.Filter == "" && !c.detect {
+		c.opts.Filter = net.DefaultFilter(c.opcodes.Load().Region)
+	}
+
+	if c.opts.Counters == nil {
+		c.opts.Counters = &net.Counters{}
+	}
+
+	c.opts.Counters.SetOpcodes(c.opcodes)
+	c.opts.Opcodes = c.opcodes
+
+	return c, nil
+}
+
+// Gets the events reported by the capture, which is closed once Run returns.
+// It must be read until then, or the capture stalls. Each connection's
+// events are in order, from its FlowOpened to its FlowClosed, and the queue
+// policy never drops those or its DataGaps.
+func (c *Capture) Events() <-chan Event {
+	return c.events
+}
+
+// Gets a snapshot of the capture's counters. It can be called at any time.
+func (c *Capture) Stats() net.Stats {
+	return c.opts.Counters.Stats()
+}
+
+// Gets the counters that the capture keeps its statistics in,
+// such as for net.MetricsHandler.
+func (c *Capture) Counters() *net.Counters {
+	return c.opts.Counters
+}
+
+// Gets the store holding the current opcode table.
+func (c *Capture) Opcodes() *ffxiv.OpcodeStore {
+	return c.opcodes
+}
+
+// Captures and decodes packets until the source runs out or ctx is done,
+// sending the results to Events.
+func (c *Capture) Run(ctx context.Context) error {
+	if !c.running.CompareAndSwap(false, true) {
+		return ErrAlreadyRun
+	}
+	defer close(c.events)
+
+	if c.oodleBackend != nil {
+		previous := oodle.CurrentBackend()
+		oodle.Use(c.oodleBackend)
+		defer oodle.Use(previous)
+	}
+
+	// One queue keeps each connection's events in order
+	results := make(chan net.Result)
+	errc := make(chan error, 1)
+
+	go func() {
+		errc <- c.source.capture(ctx, c, net.Outputs{Results: results})
+	}()
+
+	for results != nil {
+		select {
+		case err := <-errc:
+			// If the capture couldn't start, the outputs are never closed
+			if err != nil {
+				return err
+			}
+
+			errc = nil
+
+		case result, ok := <-results:
+			if !ok {
+				results = nil
+				continue
+			}
+
+			if event := c.event(result); event != nil {
+				c.events <- event
+			}
+		}
+	}
+
+	if errc != nil {
+		return <-errc
+	}
+
+	return nil
+}
+
+// Gets the Event for a result of the capture, or nil if it isn't reported.
+func (c *Capture) event(result net.Result) Event {
+	switch result := result.(type) {
+	case *net.FlowBundle:
+		return &BundleEvent{*result}
+	case *net.FlowError:
+		return &DecodeError{*result}
+	case *net.Latency:
+		return &LatencyMeasured{*result}
+	case *net.RegionDetection:
+		if c.detectRegion(result) {
+			return &RegionDetected{*result}
+		}
+	case *net.Stats:
+		// The final snapshot is only logged, unless stats were requested
+		if c.opts.StatsInterval > 0 {
+			return &StatsSnapshot{*result}
+		}
+	case *net.ConnectionOpened:
+		return &FlowOpened{*result}
+	case *net.ConnectionClosed:
+		return &FlowClosed{*result}
+	case *net.DataGap:
+		return &DataGap{*result}
+	case *net.OodleStatus:
+		return &OodleStatus{*result}
+	}
+
+	return nil
+}
+
+// Switches to the opcode table for the first detected region, if detecting
+// the region, and warns about any other region. Reports whether it switched.
+func (c *Capture) detectRegion(detection *net.RegionDetection) bool {
+	current := c.opcodes.Load().Region
+
+	if !c.detect {
+		if detection.Region != current {
+			log.Warnf("Server %s is in region %s, but opcodes are for %s",
+				detection.Server, detection.Region, current)
+		}
+
+		return false
+	}
+
+	if c.detected {
+		if detection.Region != current {
+			log.Warnf("Server %s is in region %s, but %s was already detected",
+				detection.Server, detection.Region, current)
+		}
+
+		return false
+	}
+
+	c.detected = true
+
+	table, err := c.loadOpcodes(detection.Region)
+	if err != nil {
+		log.WithError(err).Errorf("Failed to load opcodes for detected region %s", detection.Region)
+		return true
+	}
+
+	c.opcodes.Store(table)
+	log.Infof("Detected region %s from server %s (%s)",
+		detection.Region, detection.Server, strings.Join(detection.DataCenters, ", "))
+
+	return true
+}
@@ -0,0 +1,231 @@
+package goblade_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/sparta142/goblade"
+	"github.com/sparta142/goblade/ffxiv"
+	"github.com/sparta142/goblade/net"
+	"github.com/sparta142/goblade/oodle"
+	"github.com/sparta142/goblade/synth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Runs a capture of a synthetic pcapng file, and collects its events.
+func runSynthetic(t *testing.T, opts ...goblade.Option) (*goblade.Capture, synth.Summary, []goblade.Event) {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	summary, err := synth.Generate(&buf, synth.DefaultConfig())
+	require.NoError(t, err)
+
+	capture, err := goblade.New(append([]goblade.Option{goblade.WithReader(&buf)}, opts...)...)
+	require.NoError(t, err)
+
+	errc := make(chan error, 1)
+
+	go func() {
+		errc <- capture.Run(context.Background())
+	}()
+
+	var events []goblade.Event
+	for event := range capture.Events() {
+		events = append(events, event)
+	}
+
+	require.NoError(t, <-errc)
+
+	return capture, summary, events
+}
+
+//nolint:paralleltest // Capturing sets up the global Oodle backend
+func TestCapture_Run(t *testing.T) {
+	capture, summary, events := runSynthetic(t, goblade.WithRegion(ffxiv.RegionGlobal))
+
+	var bundles, opened, closed, oodleStatuses int
+
+	for _, event := range events {
+		switch event := event.(type) {
+		case *goblade.BundleEvent:
+			bundles++
+			assert.NotZero(t, event.ConnectionID)
+			assert.Equal(t, event.FromServer, event.Src == synth.DefaultConfig().Server)
+		case *goblade.FlowOpened:
+			opened++
+		case *goblade.FlowClosed:
+			closed++
+		case *goblade.OodleStatus:
+			oodleStatuses++
+		case *goblade.StatsSnapshot:
+			assert.Fail(t, "stats reported without a stats interval")
+		}
+	}
+
+	assert.Equal(t, summary.Bundles, bundles)
+	assert.NotZero(t, opened)
+	assert.Equal(t, opened, closed)
+	assert.Equal(t, 1, oodleStatuses)
+
+	stats := capture.Stats()
+	assert.EqualValues(t, summary.Bundles, stats.Totals.Bundles)
+	assert.Equal(t, ffxiv.RegionGlobal, capture.Opcodes().Load().Region)
+
+	assert.ErrorIs(t, capture.Run(context.Background()), goblade.ErrAlreadyRun)
+}
+
+//nolint:paralleltest // Capturing sets up the global Oodle backend
+func TestCapture_Run_Stats(t *testing.T) {
+	_, summary, events := runSynthetic(t, goblade.WithStatsInterval(time.Hour))
+
+	var final *net.Stats
+
+	for _, event := range events {
+		if event, ok := event.(*goblade.StatsSnapshot); ok && event.Final {
+			final = &event.Stats
+		}
+	}
+
+	require.NotNil(t, final)
+	assert.EqualValues(t, summary.Bundles, final.Totals.Bundles)
+}
+
+//nolint:paralleltest // Capturing sets up the global Oodle backend
+func TestCapture_Run_Order(t *testing.T) {
+	cfg := synth.DefaultConfig()
+	cfg.Bundles = 500
+	cfg.Loss = 0.05
+
+	var buf bytes.Buffer
+
+	summary, err := synth.Generate(&buf, cfg)
+	require.NoError(t, err)
+	require.NotZero(t, summary.Lost)
+
+	// A tiny queue and a slow reader drop most of the bundles
+	capture, err := goblade.New(goblade.WithReader(&buf), goblade.WithQueue(1, net.QueueDropOldest))
+	require.NoError(t, err)
+
+	errc := make(chan error, 1)
+
+	go func() {
+		errc <- capture.Run(context.Background())
+	}()
+
+	// Each connection's events are framed by its opening and closing,
+	// which are never dropped, and neither are its gaps
+	state := map[uint64]string{}
+	gaps := 0
+
+	for event := range capture.Events() {
+		time.Sleep(10 * time.Microsecond)
+
+		switch event := event.(type) {
+		case *goblade.FlowOpened:
+			assert.Empty(t, state[event.ID])
+			state[event.ID] = "opened"
+		case *goblade.BundleEvent:
+			assert.Equal(t, "opened", state[event.ConnectionID])
+		case *goblade.DataGap:
+			assert.Equal(t, "opened", state[event.ConnectionID])
+			gaps++
+		case *goblade.FlowClosed:
+			assert.Equal(t, "opened", state[event.ID])
+			state[event.ID] = "closed"
+		}
+	}
+
+	require.NoError(t, <-errc)
+	assert.Equal(t, map[uint64]string{1: "closed"}, state)
+	assert.NotZero(t, gaps)
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	_, err := goblade.New(goblade.WithReader(nil))
+	assert.ErrorIs(t, err, goblade.ErrNilReader)
+
+	_, err = goblade.New(goblade.WithQueue(-1, net.QueueBlock))
+	assert.ErrorIs(t, err, net.ErrInvalidOptions)
+
+	_, err = goblade.New(goblade.WithRegion("Atlantis"))
+	assert.ErrorIs(t, err, ffxiv.ErrUnknownRegion)
+
+	// The embedded networks are all global, which is warned about but still runs
+	_, err = goblade.New(goblade.WithRegionDetection())
+	assert.NoError(t, err)
+
+	// A table that is already in the store is kept
+	var store ffxiv.OpcodeStore
+	store.Store(ffxiv.OpcodeTable{Region: "Custom"})
+
+	capture, err := goblade.New(goblade.WithOpcodes(&store))
+	require.NoError(t, err)
+	assert.Equal(t, ffxiv.Region("Custom"), capture.Opcodes().Load().Region)
+}
+
+func TestBundleEvent_JSON(t *testing.T) {
+	t.Parallel()
+
+	event := goblade.BundleEvent{FlowBundle: net.FlowBundle{
+		ConnectionID: 3,
+		Src:          netip.MustParseAddrPort("204.2.229.9:55027"),
+		Dst:          netip.MustParseAddrPort("192.168.1.100:50432"),
+		FromServer:   true,
+		Bundle:       ffxiv.Bundle{Epoch: 1234, ConnectionType: 1, Segments: []ffxiv.Segment{}},
+	}}
+
+	data, err := json.Marshal(&event)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"type": "bundle",
+		"connectionId": 3,
+		"src": "204.2.229.9:55027",
+		"dst": "192.168.1.100:50432",
+		"fromServer": true,
+		"epoch": 1234,
+		"connectionType": 1,
+		"segments": []
+	}`, string(data))
+
+	var decoded goblade.BundleEvent
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, event, decoded)
+
+	// A Bundle on its own decodes without flow metadata
+	var bare goblade.BundleEvent
+	require.NoError(t, json.Unmarshal([]byte(`{"epoch":1234,"connectionType":1,"segments":[]}`), &bare))
+	assert.Equal(t, event.Bundle, bare.Bundle)
+	assert.Zero(t, bare.ConnectionID)
+}
+
+func TestEvent_MarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	for _, event := range []goblade.Event{
+		&goblade.FlowOpened{},
+		&goblade.FlowClosed{},
+		&goblade.DataGap{},
+		&goblade.DecodeError{FlowError: net.FlowError{Op: "decode bundle", Err: io.ErrUnexpectedEOF}},
+		&goblade.LatencyMeasured{},
+		&goblade.RegionDetected{},
+		&goblade.StatsSnapshot{},
+		&goblade.OodleStatus{OodleStatus: net.OodleStatus{Backend: "none", Err: oodle.ErrPlatformNotSupported}},
+	} {
+		data, err := json.Marshal(event)
+		require.NoError(t, err, event.Type())
+
+		var fields map[string]any
+		require.NoError(t, json.Unmarshal(data, &fields), event.Type())
+		assert.Equal(t, string(event.Type()), fields["type"])
+		assert.Greater(t, len(fields), 1, event.Type())
+	}
+}
@@ -0,0 +1,21 @@
+package net
+
+import (
+	"net/netip"
+
+	"github.com/sparta142/goblade/ffxiv"
+)
+
+// FlowBundle is a decoded Bundle with the flow that it was sent on.
+type FlowBundle struct {
+	// The ID of the connection, as reported by ConnectionOpened.
+	ConnectionID uint64
+
+	// The endpoints of the flow.
+	Src, Dst netip.AddrPort
+
+	// Whether the Bundle was sent by the server.
+	FromServer bool
+
+	Bundle ffxiv.Bundle
+}
@@ -16,48 +16,163 @@ import (
 	"github.com/sparta142/goblade/oodle"
 )
 
-// Filters for potential FFXIV ports and known data center networks.
-var bpfFilter = fmt.Sprintf(
-	"tcp and src portrange 49152-65535 and dst portrange 49152-65535 and (net %s)",
-	strings.Join(ffxiv.DataCenterCIDRs[:], " or "),
-)
+// Filters for potential FFXIV ports.
+const portFilter = "tcp and src portrange 49152-65535 and dst portrange 49152-65535"
+
+// Gets a filter for potential FFXIV ports and known data center networks.
+func bpfFilter() string {
+	return DefaultFilter("")
+}
+
+// Gets the default filter for a region: potential FFXIV ports on the region's
+// known data center networks, or on any network if none of them are known
+// (as for the Chinese and Korean services). If region is empty, every known
+// network is included.
+func DefaultFilter(region ffxiv.Region) string {
+	var cidrs []string
 
-// How often to attempt to flush TCP connections.
-const flushInterval = 1 * time.Minute
+	for _, n := range ffxiv.DefaultResolver().Networks() {
+		if region == "" || n.Region == region {
+			cidrs = append(cidrs, n.CIDR)
+		}
+	}
 
-// How old the data in an out-of-order TCP stream should be before flushing that stream.
-const flushStreamAge = 3 * time.Minute
+	if len(cidrs) == 0 {
+		return portFilter
+	}
+
+	return fmt.Sprintf("%s and (net %s)", portFilter, strings.Join(cidrs, " or "))
+}
 
 func Capture(handle *pcap.Handle, out chan<- ffxiv.Bundle) error {
 	return CaptureContext(context.Background(), handle, out)
 }
 
 func CaptureContext(ctx context.Context, handle *pcap.Handle, out chan<- ffxiv.Bundle) error {
+	return CaptureOutputs(ctx, handle, Outputs{Bundles: out})
+}
+
+// Outputs are the channels that a capture sends its results to.
+// All of them are closed when the capture ends.
+type Outputs struct {
+	// Receives every result in the order it was reported, instead of the
+	// channels below, which are then ignored. A connection's results arrive
+	// in order: ConnectionOpened first, then each flow's Bundles, errors and
+	// gaps as they occur in its data, and ConnectionClosed last. The
+	// QueuePolicy only drops Bundles, errors and latencies.
+	Results chan<- Result
+
+	// Receive every decoded Bundle, on its own or with the flow it was
+	// sent on. Either can be nil, but if both are, Bundles are only counted.
+	Bundles     chan<- ffxiv.Bundle
+	FlowBundles chan<- *FlowBundle
+
+	// Receives errors that occur in individual TCP flows, which never stop
+	// the capture. If it is nil, they are logged instead.
+	Errors chan<- *FlowError
+
+	// Receives latencies measured from keep-alive exchanges.
+	// If it is nil, they are logged instead.
+	Latency chan<- *Latency
+
+	// Receives the region of each new connection.
+	// If it is nil, they are logged instead.
+	Regions chan<- *RegionDetection
+
+	// Receives a snapshot of the capture's counters every
+	// Options.StatsInterval, and a final one when the capture ends.
+	// If it is nil, they are logged instead.
+	Stats chan<- *Stats
+
+	// Receive each connection when it is first seen, and once everything
+	// sent on it has been decoded after it closes.
+	// If they are nil, they are logged instead.
+	Opened chan<- *ConnectionOpened
+	Closed chan<- *ConnectionClosed
+
+	// Receives the gaps in each flow where data was never captured.
+	// If it is nil, they are logged instead.
+	Gaps chan<- *DataGap
+
+	// Receives whether Oodle-compressed bundles can be decoded, once the
+	// capture has set up decompression. If it is nil, failures are only logged.
+	Oodle chan<- *OodleStatus
+}
+
+// Result is one of the results sent to Outputs.Results: a *FlowBundle,
+// *FlowError, *Latency, *RegionDetection, *Stats, *ConnectionOpened,
+// *ConnectionClosed, *DataGap or *OodleStatus.
+type Result any
+
+// Captures like CaptureContext, but sends all results to outputs.
+func CaptureOutputs(ctx context.Context, handle *pcap.Handle, outputs Outputs) error {
+	return CaptureOptions(ctx, handle, outputs, Options{})
+}
+
+// Captures like CaptureOutputs, with options.
+func CaptureOptions(ctx context.Context, handle *pcap.Handle, outputs Outputs, opts Options) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	opts = opts.WithDefaults()
+	opts.log()
+
 	// Configure pcap handle
-	if err := handle.SetBPFFilter(bpfFilter); err != nil {
+	if err := handle.SetBPFFilter(opts.Filter); err != nil {
 		return fmt.Errorf("set bpf packet filter: %w", err)
 	}
 
-	// Setup packet source
-	src := gopacket.NewPacketSource(handle, handle.LinkType())
-	src.NoCopy = true
-	src.Lazy = true
+	capture(ctx, newPacketSource(handle, handle.LinkType()), handle, outputs, opts)
+
+	return nil
+}
+
+// Something that reports pcap's packet counts, such as a *pcap.Handle.
+type pcapStatser interface {
+	Stats() (*pcap.Stats, error)
+}
+
+// Decodes the packets from src until it runs out or ctx is done, with
+// opts that have been validated and defaulted. If ps isn't nil, the
+// packet counts that it reports are included in the stats.
+func capture(ctx context.Context, src *gopacket.PacketSource, ps pcapStatser, outputs Outputs, opts Options) {
+	counters := opts.Counters
+	if counters == nil {
+		counters = &Counters{}
+	}
+
+	counters.setOptions(opts)
+	counters.setHandle(ps)
+	defer counters.setHandle(nil)
+
+	// Decouple the consumers from the capture
+	queues := newOutputQueues(outputs, opts)
+	counters.setQueues(queues)
 
 	// Create TCP reassembler
-	factory := &tcpStreamFactory{out: out}
+	factory := &tcpStreamFactory{queues: queues, opts: opts, counters: counters}
 	pool := reassembly.NewStreamPool(factory)
 	assembler := reassembly.NewAssembler(pool)
-	assembler.MaxBufferedPagesPerConnection = 512
-	assembler.MaxBufferedPagesTotal = 2048
+	assembler.MaxBufferedPagesPerConnection = opts.MaxBufferedPagesPerConnection
+	assembler.MaxBufferedPagesTotal = opts.MaxBufferedPagesTotal
 
 	// Ticker to flush the reassembler periodically
-	ticker := time.NewTicker(flushInterval)
+	ticker := time.NewTicker(opts.FlushInterval)
 	defer ticker.Stop()
 
+	// Ticker to report the counters periodically, if requested
+	var statsTicks <-chan time.Time
+
+	if opts.StatsInterval > 0 {
+		statsTicker := time.NewTicker(opts.StatsInterval)
+		defer statsTicker.Stop()
+
+		statsTicks = statsTicker.C
+	}
+
 	// Setup Oodle decompression
-	if err := oodle.Setup(); err != nil {
-		log.WithError(err).Errorf("Failed to set up Oodle decompression. This won't end well.")
-	} else {
+	if setupOodle(queues) {
 		defer oodle.Shutdown()
 	}
 
@@ -70,10 +185,15 @@ Outer:
 				break Outer
 			}
 
+			counters.addPacket(len(packet.Data()))
 			handlePacket(packet, assembler)
 
 		case <-ticker.C:
-			handleTick(assembler)
+			handleTick(assembler, opts.FlushStreamAge)
+
+		case <-statsTicks:
+			stats := counters.Stats()
+			reportStats(queues, &stats)
 
 		case <-ctx.Done():
 			break Outer
@@ -84,9 +204,45 @@ Outer:
 	flushed := assembler.FlushAll()
 	log.WithField("count", flushed).Info("Flushed/closed all streams")
 	factory.Wait()
-	close(out)
 
-	return nil
+	counters.setHandle(nil)
+	final := counters.Stats()
+	final.Final = true
+	logStats(&final, "Capture finished")
+	reportStats(queues, &final)
+
+	queues.close()
+}
+
+// Reports a snapshot of the counters to the stats queue, or the log if there is none.
+func reportStats(queues *outputQueues, stats *Stats) {
+	if queues.sendResult(stats, true) {
+		return
+	}
+
+	if queues.stats == nil {
+		if !stats.Final {
+			logStats(stats, "Capture stats")
+		}
+
+		return
+	}
+
+	queues.stats.push(stats)
+}
+
+// Opens a network interface to capture from, with the snaplen from opts.
+func OpenLive(device string, promiscuous bool, opts Options) (*pcap.Handle, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	handle, err := pcap.OpenLive(device, int32(opts.WithDefaults().Snaplen), promiscuous, pcap.BlockForever)
+	if err != nil {
+		return nil, fmt.Errorf("open live pcap device: %w", err)
+	}
+
+	return handle, nil
 }
 
 type captureContext gopacket.CaptureInfo
@@ -96,7 +252,12 @@ func (c *captureContext) GetCaptureInfo() gopacket.CaptureInfo {
 }
 
 func handlePacket(packet gopacket.Packet, assembler *reassembly.Assembler) {
-	tcp := packet.TransportLayer().(*layers.TCP)
+	// A custom filter may let through packets that aren't TCP
+	tcp, ok := packet.TransportLayer().(*layers.TCP)
+	if !ok {
+		return
+	}
+
 	net := packet.NetworkLayer()
 
 	if err := tcp.SetNetworkLayerForChecksum(net); err != nil {
@@ -108,7 +269,7 @@ func handlePacket(packet gopacket.Packet, assembler *reassembly.Assembler) {
 	assembler.AssembleWithContext(net.NetworkFlow(), tcp, &ctx)
 }
 
-func handleTick(assembler *reassembly.Assembler) {
+func handleTick(assembler *reassembly.Assembler, flushStreamAge time.Duration) {
 	log.Debug("Starting periodic stream maintenance")
 
 	flushed, closed := assembler.FlushWithOptions(reassembly.FlushOptions{
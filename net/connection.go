@@ -0,0 +1,239 @@
+package net
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	log "github.com/sirupsen/logrus"
+)
+
+// ConnectionOpened reports a new TCP connection, such as the zone connection
+// that the game opens after a zone change or reconnect.
+type ConnectionOpened struct {
+	// The ID of the connection, unique within one capture.
+	ID uint64
+
+	// The endpoints of the connection.
+	Client, Server netip.AddrPort
+
+	// The time that the connection was first seen.
+	Time time.Time
+}
+
+func (e *ConnectionOpened) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(struct {
+		ID     uint64         `json:"id"`
+		Client netip.AddrPort `json:"client"`
+		Server netip.AddrPort `json:"server"`
+		Time   time.Time      `json:"time"`
+	}{e.ID, e.Client, e.Server, e.Time})
+	if err != nil {
+		return nil, fmt.Errorf("marshal connection opened: %w", err)
+	}
+
+	return data, nil
+}
+
+// ConnectionClosed reports the end of a TCP connection, once everything
+// sent on it has been decoded.
+type ConnectionClosed struct {
+	// The ID of the connection, as reported by ConnectionOpened.
+	ID uint64
+
+	// The endpoints of the connection.
+	Client, Server netip.AddrPort
+
+	// The times that the first and last packets of the connection were captured.
+	FirstSeen, LastSeen time.Time
+
+	// The totals of each direction of the connection.
+	ToClient, ToServer FlowCounts
+}
+
+func (e *ConnectionClosed) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(struct {
+		ID        uint64         `json:"id"`
+		Client    netip.AddrPort `json:"client"`
+		Server    netip.AddrPort `json:"server"`
+		FirstSeen time.Time      `json:"firstSeen"`
+		LastSeen  time.Time      `json:"lastSeen"`
+		ToClient  FlowCounts     `json:"toClient"`
+		ToServer  FlowCounts     `json:"toServer"`
+	}{e.ID, e.Client, e.Server, e.FirstSeen, e.LastSeen, e.ToClient, e.ToServer})
+	if err != nil {
+		return nil, fmt.Errorf("marshal connection closed: %w", err)
+	}
+
+	return data, nil
+}
+
+// DataGap reports data that was never captured in one direction of a
+// connection. The Bundles around it may be lost as well.
+type DataGap struct {
+	// The ID of the connection, as reported by ConnectionOpened.
+	ConnectionID uint64
+
+	// The endpoints of the flow that the data is missing from.
+	Src, Dst netip.AddrPort
+
+	// The time that the data after the gap was captured, if known.
+	Time time.Time
+
+	// The offset in the flow where the data is missing, and how many bytes are missing.
+	Offset int64
+	Length int
+}
+
+func (e *DataGap) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(struct {
+		ConnectionID uint64         `json:"connectionId"`
+		Src          netip.AddrPort `json:"src"`
+		Dst          netip.AddrPort `json:"dst"`
+		Time         time.Time      `json:"time"`
+		Offset       int64          `json:"offset"`
+		Length       int            `json:"length"`
+	}{e.ConnectionID, e.Src, e.Dst, e.Time, e.Offset, e.Length})
+	if err != nil {
+		return nil, fmt.Errorf("marshal data gap: %w", err)
+	}
+
+	return data, nil
+}
+
+// The lifetime of a connection, shared by its flows.
+type connectionTimes struct {
+	mu                  sync.Mutex
+	firstSeen, lastSeen time.Time
+	flowsRunning        int // The flows that are still decoding
+}
+
+// Records that a packet of the connection was captured at t.
+func (c *connectionTimes) seen(t time.Time) {
+	if t.IsZero() {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.firstSeen.IsZero() {
+		c.firstSeen = t
+	}
+
+	if t.After(c.lastSeen) {
+		c.lastSeen = t
+	}
+}
+
+// Records that a flow has finished decoding, and reports whether it was the last one.
+func (c *connectionTimes) flowDone() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.flowsRunning--
+
+	return c.flowsRunning == 0
+}
+
+func (c *connectionTimes) get() (firstSeen, lastSeen time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.firstSeen, c.lastSeen
+}
+
+// Reports that the stream's connection has opened to the opened queue,
+// or the log if there is none.
+func (stream *tcpStream) reportOpened() {
+	first, _ := stream.times.get()
+	opened := &ConnectionOpened{
+		ID:     stream.id,
+		Client: stream.client,
+		Server: stream.server,
+		Time:   first,
+	}
+
+	if stream.queues.sendResult(opened, true) {
+		return
+	}
+
+	if stream.queues.opened == nil {
+		log.Debugf("Connection %d opened from %s to %s", opened.ID, opened.Client, opened.Server)
+		return
+	}
+
+	stream.queues.opened.push(opened)
+}
+
+// Reports that the stream's connection has closed to the closed queue,
+// or the log if there is none.
+func (stream *tcpStream) reportClosed() {
+	first, last := stream.times.get()
+	closed := &ConnectionClosed{
+		ID:        stream.id,
+		Client:    stream.client,
+		Server:    stream.server,
+		FirstSeen: first,
+		LastSeen:  last,
+		ToClient:  stream.toClient.counters.load(),
+		ToServer:  stream.toServer.counters.load(),
+	}
+
+	if stream.queues.sendResult(closed, true) {
+		return
+	}
+
+	if stream.queues.closed == nil {
+		log.Debugf("Connection %d closed from %s to %s after %d and %d bundles", closed.ID,
+			closed.Client, closed.Server, closed.ToServer.Bundles, closed.ToClient.Bundles)
+
+		return
+	}
+
+	stream.queues.closed.push(closed)
+}
+
+// Records missing data in the flow, which is reported once the Bundles
+// before it have been.
+func (flow *tcpFlow) addGap(length int, t time.Time) {
+	flow.gapsMu.Lock()
+	defer flow.gapsMu.Unlock()
+
+	flow.gaps = append(flow.gaps, &DataGap{
+		ConnectionID: flow.stream.id,
+		Src:          flow.Src,
+		Dst:          flow.Dst,
+		Time:         t,
+		Offset:       flow.written,
+		Length:       length,
+	})
+}
+
+// Reports the missing data recorded before a stream offset to the gaps
+// queue, or the log if there is none.
+func (flow *tcpFlow) reportGaps(before int64) {
+	flow.gapsMu.Lock()
+	defer flow.gapsMu.Unlock()
+
+	for len(flow.gaps) > 0 && flow.gaps[0].Offset <= before {
+		gap := flow.gaps[0]
+		flow.gaps = flow.gaps[1:]
+
+		switch {
+		case flow.queues.sendResult(gap, true):
+		case flow.queues.gaps == nil:
+			log.Warnf("Lost %d bytes in %s", gap.Length, flow)
+		default:
+			flow.queues.gaps.push(gap)
+		}
+	}
+}
+
+var (
+	_ json.Marshaler = (*ConnectionOpened)(nil)
+	_ json.Marshaler = (*ConnectionClosed)(nil)
+	_ json.Marshaler = (*DataGap)(nil)
+)
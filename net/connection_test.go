@@ -0,0 +1,150 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/sparta142/goblade/ffxiv"
+	"github.com/sparta142/goblade/synth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Everything output by a capture.
+type captured struct {
+	bundles []ffxiv.Bundle
+	opened  []*ConnectionOpened
+	closed  []*ConnectionClosed
+	gaps    []*DataGap
+}
+
+// Captures a synthetic pcapng file generated with cfg.
+func captureSynthetic(t *testing.T, cfg synth.Config) (synth.Summary, *captured) {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	summary, err := synth.Generate(&buf, cfg)
+	require.NoError(t, err)
+
+	reader, err := pcapgo.NewNgReader(&buf, pcapgo.DefaultNgReaderOptions)
+	require.NoError(t, err)
+
+	bundles := make(chan ffxiv.Bundle)
+	opened := make(chan *ConnectionOpened)
+	closed := make(chan *ConnectionClosed)
+	gaps := make(chan *DataGap)
+
+	go capture(context.Background(), gopacket.NewPacketSource(reader, reader.LinkType()), nil, Outputs{
+		Bundles: bundles,
+		Opened:  opened,
+		Closed:  closed,
+		Gaps:    gaps,
+	}, Options{QueuePolicy: QueueBlock}.WithDefaults())
+
+	out := &captured{}
+
+	for bundles != nil || opened != nil || closed != nil || gaps != nil {
+		select {
+		case bundle, ok := <-bundles:
+			if !ok {
+				bundles = nil
+				continue
+			}
+
+			out.bundles = append(out.bundles, bundle)
+
+		case event, ok := <-opened:
+			if !ok {
+				opened = nil
+				continue
+			}
+
+			out.opened = append(out.opened, event)
+
+		case event, ok := <-closed:
+			if !ok {
+				closed = nil
+				continue
+			}
+
+			out.closed = append(out.closed, event)
+
+		case event, ok := <-gaps:
+			if !ok {
+				gaps = nil
+				continue
+			}
+
+			out.gaps = append(out.gaps, event)
+		}
+	}
+
+	return summary, out
+}
+
+//nolint:paralleltest // Capturing sets up the global Oodle backend
+func TestCapture_Connections(t *testing.T) {
+	cfg := synth.DefaultConfig()
+	summary, out := captureSynthetic(t, cfg)
+
+	assert.Len(t, out.bundles, summary.Bundles)
+	assert.Empty(t, out.gaps)
+
+	// The ACK after the teardown can be seen as the start of another connection,
+	// but every connection that's opened must be closed
+	require.NotEmpty(t, out.opened)
+	require.Len(t, out.closed, len(out.opened))
+
+	opened := out.opened[0]
+	closed := connectionClosed(t, out, opened.ID)
+	assert.Equal(t, cfg.Client, closed.Client)
+	assert.Equal(t, cfg.Server, closed.Server)
+	assert.Equal(t, opened.Time, closed.FirstSeen)
+	assert.True(t, closed.LastSeen.After(closed.FirstSeen))
+	assert.EqualValues(t, summary.Bundles, closed.ToClient.Bundles+closed.ToServer.Bundles)
+	assert.NotZero(t, closed.ToClient.Bytes)
+	assert.NotZero(t, closed.ToServer.Bytes)
+}
+
+// Gets the closed event of the connection with the ID.
+func connectionClosed(t *testing.T, out *captured, id uint64) *ConnectionClosed {
+	t.Helper()
+
+	for _, closed := range out.closed {
+		if closed.ID == id {
+			return closed
+		}
+	}
+
+	require.Failf(t, "connection not closed", "no closed event for connection %d", id)
+
+	return nil
+}
+
+//nolint:paralleltest // Capturing sets up the global Oodle backend
+func TestCapture_Gaps(t *testing.T) {
+	cfg := synth.DefaultConfig()
+	cfg.Bundles = 300
+	cfg.Loss = 0.05
+
+	summary, out := captureSynthetic(t, cfg)
+	require.NotZero(t, summary.Lost)
+	require.NotEmpty(t, out.gaps)
+	require.NotEmpty(t, out.opened)
+
+	closed := connectionClosed(t, out, out.opened[0].ID)
+
+	var lost int
+	for _, gap := range out.gaps {
+		assert.Equal(t, closed.ID, gap.ConnectionID)
+		assert.Positive(t, gap.Length)
+		lost += gap.Length
+	}
+
+	assert.EqualValues(t, lost, closed.ToClient.LostBytes+closed.ToServer.LostBytes)
+	assert.Less(t, len(out.bundles), summary.Bundles)
+}
@@ -0,0 +1,53 @@
+package net
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/goccy/go-json"
+)
+
+// FlowError is an error that occurred while processing one direction of a
+// TCP connection. It never stops the capture: the flow either recovers and
+// carries on decoding, or is closed, as indicated by Closed.
+type FlowError struct {
+	// The ID of the connection, as reported by ConnectionOpened.
+	ConnectionID uint64
+
+	// The endpoints of the flow.
+	Src, Dst netip.AddrPort
+
+	// The operation that failed.
+	Op string
+
+	// Whether the flow was closed because of the error.
+	Closed bool
+
+	Err error
+}
+
+func (e *FlowError) Error() string {
+	return fmt.Sprintf("%s %s->%s: %v", e.Op, e.Src, e.Dst, e.Err)
+}
+
+func (e *FlowError) Unwrap() error {
+	return e.Err
+}
+
+func (e *FlowError) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(struct {
+		ConnectionID uint64         `json:"connectionId"`
+		Src          netip.AddrPort `json:"src"`
+		Dst          netip.AddrPort `json:"dst"`
+		Op           string         `json:"op"`
+		Closed       bool           `json:"closed"`
+		Error        string         `json:"error"`
+	}{e.ConnectionID, e.Src, e.Dst, e.Op, e.Closed, e.Err.Error()})
+	if err != nil {
+		return nil, fmt.Errorf("marshal flow error: %w", err)
+	}
+
+	return data, nil
+}
+
+var _ json.Marshaler = (*FlowError)(nil)
@@ -4,6 +4,9 @@ import (
 	"net"
 	"net/netip"
 	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
@@ -12,27 +15,57 @@ import (
 )
 
 type tcpStreamFactory struct {
-	wg  sync.WaitGroup
-	out chan<- ffxiv.Bundle
+	wg       sync.WaitGroup
+	queues   *outputQueues
+	opts     Options
+	counters *Counters
+	lastID   uint64 // The ID of the last connection
 }
 
 // New implements reassembly.StreamFactory.
 func (fac *tcpStreamFactory) New( //nolint:ireturn
 	netFlow, transport gopacket.Flow,
-	_ *layers.TCP,
-	_ reassembly.AssemblerContext,
+	tcp *layers.TCP,
+	ac reassembly.AssemblerContext,
 ) reassembly.Stream {
 	src := toAddrPort(netFlow.Src(), transport.Src())
 	dst := toAddrPort(netFlow.Dst(), transport.Dst())
 
+	var seen time.Time
+	if ac != nil {
+		seen = ac.GetCaptureInfo().Timestamp
+	}
+
+	if detection := detectRegion(src, dst, seen); detection != nil {
+		fac.reportRegion(detection)
+	}
+
 	stream := &tcpStream{
 		fsm: *reassembly.NewTCPSimpleFSM(reassembly.TCPSimpleFSMOptions{
 			SupportMissingEstablishment: true,
 		}),
-		toClient: newTCPFlow(src, dst, fac.out),
-		toServer: newTCPFlow(dst, src, fac.out),
+		order: newDecodeOrder(),
+	}
+	fac.lastID++
+	stream.id = fac.lastID
+	stream.queues = fac.queues
+	stream.counters = fac.counters
+	stream.times.seen(seen)
+	stream.times.flowsRunning = 2
+	// The reassembler treats the first packet as being from the client,
+	// but a capture that starts mid-connection may see the server's first
+	stream.reversed = isServerFirst(src, dst, tcp)
+	stream.client, stream.server = src, dst
+	if stream.reversed {
+		stream.client, stream.server = dst, src
 	}
 
+	stream.toClient = newTCPFlow(stream.server, stream.client, true, stream, fac.queues, fac.opts)
+	stream.toServer = newTCPFlow(stream.client, stream.server, false, stream, fac.queues, fac.opts)
+	stream.latency = newLatencyTracker(stream.client, stream.server)
+	fac.counters.openStream(stream.toClient, stream.toServer)
+	stream.reportOpened()
+
 	fac.wg.Add(2)
 	go stream.toClient.Run(&fac.wg)
 	go stream.toServer.Run(&fac.wg)
@@ -40,6 +73,53 @@ func (fac *tcpStreamFactory) New( //nolint:ireturn
 	return stream
 }
 
+// The port ranges that FFXIV servers listen on.
+var serverPorts = [...][2]uint16{{54992, 54994}, {55006, 55007}, {55021, 55040}, {55296, 55551}}
+
+func isServerPort(port uint16) bool {
+	for _, ports := range serverPorts {
+		if port >= ports[0] && port <= ports[1] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reports whether the first packet of a connection, sent from src to dst,
+// came from the server. Only the server is in a known data center network,
+// and failing that, only the client sends a SYN without an ACK, and only
+// the server listens on the game's ports. Otherwise, the first packet is
+// taken to be the client's.
+func isServerFirst(src, dst netip.AddrPort, tcp *layers.TCP) bool {
+	resolver := ffxiv.DefaultResolver()
+	_, srcKnown := resolver.Network(src.Addr().AsSlice())
+	_, dstKnown := resolver.Network(dst.Addr().AsSlice())
+
+	switch {
+	case srcKnown != dstKnown:
+		return srcKnown
+	case tcp != nil && tcp.SYN:
+		return tcp.ACK
+	default:
+		return isServerPort(src.Port()) && !isServerPort(dst.Port())
+	}
+}
+
+// Reports a region detection to the regions queue, or the log if there is none.
+func (fac *tcpStreamFactory) reportRegion(detection *RegionDetection) {
+	if fac.queues.sendResult(detection, true) {
+		return
+	}
+
+	if fac.queues.regions == nil {
+		log.Debugf("Connection to %s is in region %s", detection.Server, detection.Region)
+		return
+	}
+
+	fac.queues.regions.push(detection)
+}
+
 func (fac *tcpStreamFactory) Wait() {
 	fac.wg.Wait()
 }
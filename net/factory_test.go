@@ -0,0 +1,310 @@
+package net
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+	"github.com/sparta142/goblade/ffxiv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The offset of the first segment's length in a marshaled Bundle.
+const bundleSegmentOffset = 40
+
+// Receives from ch, failing the test if nothing is sent in time.
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "nothing was received")
+	}
+
+	var zero T
+
+	return zero
+}
+
+// Marshals a Bundle for writing to a flow.
+func marshalBundle(t *testing.T, bundle *ffxiv.Bundle) []byte {
+	t.Helper()
+
+	bundle.Epoch = 1624314019411
+
+	data, err := bundle.MarshalBinary()
+	require.NoError(t, err)
+
+	return data
+}
+
+// The endpoints of the connection opened by openStream.
+var (
+	testClient = netip.MustParseAddrPort("192.168.1.100:50432")
+	testServer = netip.MustParseAddrPort("204.2.229.9:55027")
+)
+
+// Opens a stream from client to server with a factory that reports to outputs,
+// and closes it once the test is done.
+func openStream(t *testing.T, outputs Outputs, opts Options) *tcpStream {
+	t.Helper()
+
+	// The reassembler creates the stream from the client's first packet
+	return openStreamFrom(t, testClient, testServer, nil, outputs, opts)
+}
+
+// Opens a stream like openStream, whose first packet is tcp, sent from src to dst.
+func openStreamFrom(t *testing.T, src, dst netip.AddrPort, tcp *layers.TCP, outputs Outputs, opts Options) *tcpStream {
+	t.Helper()
+
+	netFlow := gopacket.NewFlow(layers.EndpointIPv4, net.IP(src.Addr().AsSlice()), net.IP(dst.Addr().AsSlice()))
+	transport := gopacket.NewFlow(layers.EndpointTCPPort,
+		layers.NewTCPPortEndpoint(layers.TCPPort(src.Port())).Raw(),
+		layers.NewTCPPortEndpoint(layers.TCPPort(dst.Port())).Raw())
+
+	opts = opts.WithDefaults()
+	queues := newOutputQueues(outputs, opts)
+	fac := &tcpStreamFactory{queues: queues, opts: opts, counters: &Counters{}}
+
+	stream, ok := fac.New(netFlow, transport, tcp, nil).(*tcpStream)
+	require.True(t, ok)
+
+	t.Cleanup(func() {
+		stream.ReassemblyComplete(nil)
+		fac.Wait()
+		queues.close()
+	})
+
+	return stream
+}
+
+//nolint:paralleltest // Creating flows sets up the global Oodle backend
+func TestStreamFactory_New_Orientation(t *testing.T) {
+	bundles := make(chan *FlowBundle, 2)
+	latencies := make(chan *Latency, 1)
+	errs := make(chan *FlowError, 1)
+
+	stream := openStream(t, Outputs{FlowBundles: bundles, Latency: latencies, Errors: errs}, Options{})
+	assert.Equal(t, testClient, stream.toServer.Src)
+	assert.Equal(t, testServer, stream.toServer.Dst)
+	assert.Equal(t, testServer, stream.toClient.Src)
+	assert.Equal(t, testClient, stream.toClient.Dst)
+
+	// The client asks, then the server answers
+	start := time.UnixMilli(1624314019411)
+	stream.toServer.decoder.SetCaptureTime(0, start)
+	stream.toClient.decoder.SetCaptureTime(0, start.Add(40*time.Millisecond))
+
+	_, err := stream.toServer.writer.Write(marshalBundle(t, keepAliveBundle(ffxiv.SegmentClientKeepAlive, 1)))
+	require.NoError(t, err)
+
+	sent := receive(t, bundles)
+	assert.Equal(t, testClient, sent.Src)
+	assert.False(t, sent.FromServer)
+
+	answer := marshalBundle(t, keepAliveBundle(ffxiv.SegmentServerKeepAlive, 1))
+	_, err = stream.toClient.writer.Write(answer)
+	require.NoError(t, err)
+
+	latency := receive(t, latencies)
+	assert.Equal(t, testClient, latency.Client)
+	assert.Equal(t, testServer, latency.Server)
+	assert.Equal(t, 40*time.Millisecond, latency.RTT)
+
+	// Errors name the side that sent the data
+	answer[bundleSegmentOffset] = 0xff
+	_, err = stream.toClient.writer.Write(answer)
+	require.NoError(t, err)
+
+	flowErr := receive(t, errs)
+	assert.Equal(t, testServer, flowErr.Src)
+	assert.Equal(t, testClient, flowErr.Dst)
+}
+
+//nolint:paralleltest // Creating flows sets up the global Oodle backend
+func TestStreamFactory_New_ServerFirst(t *testing.T) {
+	lanClient := netip.MustParseAddrPort("10.0.0.2:50100")
+	lanServer := netip.MustParseAddrPort("10.0.0.1:55023")
+
+	for name, tc := range map[string]struct {
+		client, server netip.AddrPort
+		tcp            *layers.TCP
+	}{
+		"known network": {testClient, testServer, nil},
+		"syn-ack": {
+			netip.MustParseAddrPort("10.0.0.2:55023"),
+			netip.MustParseAddrPort("10.0.0.1:50100"),
+			&layers.TCP{SYN: true, ACK: true},
+		},
+		"server port": {lanClient, lanServer, nil},
+	} {
+		// The capture starts mid-connection, with a packet from the server
+		bundles := make(chan *FlowBundle, 1)
+		stream := openStreamFrom(t, tc.server, tc.client, tc.tcp, Outputs{FlowBundles: bundles}, Options{})
+		assert.Equal(t, tc.client, stream.client, name)
+		assert.Equal(t, tc.server, stream.server, name)
+		assert.Equal(t, tc.client, stream.toServer.Src, name)
+		assert.Equal(t, tc.server, stream.toClient.Src, name)
+
+		// What the reassembler thinks the client sent came from the server
+		flow := stream.getFlow(reassembly.TCPDirClientToServer)
+		require.Same(t, stream.toClient, flow, name)
+
+		flow.decoder.SetCaptureTime(0, time.UnixMilli(1624314019411))
+		stream.reassembled(flow, marshalBundle(t, keepAliveBundle(ffxiv.SegmentServerKeepAlive, 1)), 0, time.Time{})
+
+		bundle := receive(t, bundles)
+		assert.True(t, bundle.FromServer, name)
+		assert.Equal(t, tc.server, bundle.Src, name)
+	}
+}
+
+// Parses a global opcode table that names one server zone opcode.
+func parseOpcodes(t *testing.T, name string, opcode int) ffxiv.OpcodeTable {
+	t.Helper()
+
+	data, err := json.Marshal([]any{map[string]any{
+		"version": "test",
+		"region":  ffxiv.RegionGlobal,
+		"lists": map[ffxiv.IpcType]any{
+			ffxiv.ServerZoneIpcType: []any{map[string]any{"name": name, "opcode": opcode}},
+		},
+	}})
+	require.NoError(t, err)
+
+	table, err := ffxiv.ParseOpcodes(data, ffxiv.RegionGlobal)
+	require.NoError(t, err)
+
+	return table
+}
+
+//nolint:paralleltest // Creating flows sets up the global Oodle backend
+func TestTCPFlow_Run_Opcodes(t *testing.T) {
+	var store ffxiv.OpcodeStore
+	store.Store(parseOpcodes(t, "PlayerSetup", 0x0123))
+
+	bundles := make(chan *FlowBundle, 1)
+	stream := openStream(t, Outputs{FlowBundles: bundles}, Options{Opcodes: &store})
+	stream.toClient.decoder.SetCaptureTime(0, time.UnixMilli(1624314019411))
+
+	// Each IPC is named with the table at the time it is decoded
+	data := marshalBundle(t, &ffxiv.Bundle{Segments: []ffxiv.Segment{
+		{Type: ffxiv.SegmentIpc, Payload: &ffxiv.Ipc{Magic: 0x14, Type: 0x0123}},
+	}})
+
+	_, err := stream.toClient.writer.Write(data)
+	require.NoError(t, err)
+	assert.Equal(t, "PlayerSetup", receive(t, bundles).Bundle.Segments[0].Payload.(*ffxiv.Ipc).Name)
+
+	store.Store(parseOpcodes(t, "PlayerSpawn", 0x0123))
+
+	_, err = stream.toClient.writer.Write(data)
+	require.NoError(t, err)
+	assert.Equal(t, "PlayerSpawn", receive(t, bundles).Bundle.Segments[0].Payload.(*ffxiv.Ipc).Name)
+}
+
+//nolint:paralleltest // Creating flows sets up the global Oodle backend
+func TestTCPFlow_Run_MaxBundleSize(t *testing.T) {
+	bundles := make(chan *FlowBundle, 1)
+	errs := make(chan *FlowError, 1)
+
+	stream := openStream(t, Outputs{FlowBundles: bundles, Errors: errs}, Options{MaxBundleSize: 64})
+	stream.toClient.decoder.SetCaptureTime(0, time.UnixMilli(1624314019411))
+
+	// Bundles over the limit are skipped, and the ones after them still decoded
+	large := marshalBundle(t, &ffxiv.Bundle{Segments: []ffxiv.Segment{
+		{Type: ffxiv.SegmentIpc, Payload: &ffxiv.Ipc{Magic: 0x14, Data: make([]byte, 64)}},
+	}})
+	small := marshalBundle(t, keepAliveBundle(ffxiv.SegmentServerKeepAlive, 1))
+
+	_, err := stream.toClient.writer.Write(append(large, small...))
+	require.NoError(t, err)
+	assert.ErrorIs(t, receive(t, errs), ffxiv.ErrTooLarge)
+	assert.Equal(t, ffxiv.SegmentServerKeepAlive, receive(t, bundles).Bundle.Segments[0].Type)
+}
+
+//nolint:paralleltest // Creating flows sets up the global Oodle backend
+func TestTCPFlow_Burst(t *testing.T) {
+	const count = 100
+
+	bundles := make(chan *FlowBundle, count)
+	gaps := make(chan *DataGap, 1)
+
+	// Dropping results doesn't drop reassembled data, however far behind decoding is
+	stream := openStream(t, Outputs{FlowBundles: bundles, Gaps: gaps}, Options{QueuePolicy: QueueDropOldest})
+	stream.toClient.decoder.SetCaptureTime(0, time.UnixMilli(1624314019411))
+
+	decoding := make(chan struct{})
+	stream.toClient.decoder.SetDecompressObserver(func(ffxiv.CompressionType, time.Duration) {
+		<-decoding
+	})
+
+	data := marshalBundle(t, keepAliveBundle(ffxiv.SegmentServerKeepAlive, 1))
+	require.Greater(t, count*len(data), DefaultPipeBufferSize)
+
+	written := make(chan struct{})
+
+	go func() {
+		for i := 0; i < count; i++ {
+			stream.reassembled(stream.toClient, data, 0, time.Time{})
+		}
+
+		close(written)
+	}()
+
+	// Reassembly waits for decoding once the pipe is full
+	select {
+	case <-written:
+		require.FailNow(t, "the burst was written without waiting for decoding")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(decoding)
+	receive(t, written)
+
+	for i := 0; i < count; i++ {
+		receive(t, bundles)
+	}
+
+	assert.Empty(t, gaps)
+	assert.Zero(t, stream.toClient.counters.lostBytes.Load())
+}
+
+//nolint:paralleltest // Creating flows sets up the global Oodle backend
+func TestTCPFlow_Run_ResultOrder(t *testing.T) {
+	results := make(chan Result, 8)
+	stream := openStream(t, Outputs{Results: results}, Options{})
+	stream.toClient.decoder.SetCaptureTime(0, time.UnixMilli(1624314019411))
+
+	// The gap is reassembled while the Bundle before it is still decoding
+	decoding := make(chan struct{})
+	stream.toClient.decoder.SetDecompressObserver(func(ffxiv.CompressionType, time.Duration) {
+		<-decoding
+	})
+
+	data := marshalBundle(t, keepAliveBundle(ffxiv.SegmentServerKeepAlive, 1))
+	stream.reassembled(stream.toClient, data, 0, time.Time{})
+	stream.reassembled(stream.toClient, data, 10, time.Time{})
+	close(decoding)
+
+	// The server's region is detected before the connection opens
+	assert.IsType(t, &RegionDetection{}, receive(t, results))
+	assert.IsType(t, &ConnectionOpened{}, receive(t, results))
+	assert.IsType(t, &FlowBundle{}, receive(t, results))
+
+	gap, ok := receive(t, results).(*DataGap)
+	if assert.True(t, ok) {
+		assert.EqualValues(t, len(data), gap.Offset)
+		assert.Equal(t, 10, gap.Length)
+	}
+
+	assert.IsType(t, &FlowBundle{}, receive(t, results))
+}
@@ -0,0 +1,212 @@
+package net
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/sparta142/goblade/ffxiv"
+)
+
+// How long to wait for the other half of a keep-alive exchange before forgetting it.
+const keepAliveTimeout = 1 * time.Minute
+
+// The most keep-alive exchanges to wait on for each connection.
+const maxPendingKeepAlives = 32
+
+// The weight given to each new sample in LatencyStats.Smoothed,
+// the same as TCP's smoothed round-trip time.
+const latencySmoothing = 8
+
+// Latency is a round-trip time measured from a keep-alive exchange:
+// a SegmentClientKeepAlive from the client, answered by a
+// SegmentServerKeepAlive with the same ID from the server.
+type Latency struct {
+	// The endpoints of the connection.
+	Client, Server netip.AddrPort
+
+	// The ID of the keep-alive exchange.
+	ID uint32
+
+	// The time that the server's answer was captured.
+	Time time.Time
+
+	// The time between capturing the client's keep-alive and the server's answer.
+	RTT time.Duration
+
+	// Statistics for all exchanges on the connection so far, including this one.
+	Stats LatencyStats
+}
+
+func (l *Latency) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(struct {
+		Client netip.AddrPort `json:"client"`
+		Server netip.AddrPort `json:"server"`
+		ID     uint32         `json:"id"`
+		Time   time.Time      `json:"time"`
+		RTT    float64        `json:"rttMs"`
+		Stats  LatencyStats   `json:"stats"`
+	}{l.Client, l.Server, l.ID, l.Time, milliseconds(l.RTT), l.Stats})
+	if err != nil {
+		return nil, fmt.Errorf("marshal latency: %w", err)
+	}
+
+	return data, nil
+}
+
+// LatencyStats are running statistics of the round-trip times on a connection.
+type LatencyStats struct {
+	Count int
+
+	Min, Max, Mean time.Duration
+
+	// An exponentially weighted moving average that favors recent samples.
+	Smoothed time.Duration
+}
+
+// Adds a round-trip time to the statistics.
+func (s *LatencyStats) add(rtt time.Duration) {
+	s.Count++
+
+	if s.Count == 1 {
+		s.Min, s.Max, s.Mean, s.Smoothed = rtt, rtt, rtt, rtt
+		return
+	}
+
+	if rtt < s.Min {
+		s.Min = rtt
+	}
+
+	if rtt > s.Max {
+		s.Max = rtt
+	}
+
+	s.Mean += (rtt - s.Mean) / time.Duration(s.Count)
+	s.Smoothed += (rtt - s.Smoothed) / latencySmoothing
+}
+
+func (s LatencyStats) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(struct {
+		Count    int     `json:"count"`
+		Min      float64 `json:"minMs"`
+		Max      float64 `json:"maxMs"`
+		Mean     float64 `json:"meanMs"`
+		Smoothed float64 `json:"smoothedMs"`
+	}{s.Count, milliseconds(s.Min), milliseconds(s.Max), milliseconds(s.Mean), milliseconds(s.Smoothed)})
+	if err != nil {
+		return nil, fmt.Errorf("marshal latency stats: %w", err)
+	}
+
+	return data, nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Matches up keep-alive exchanges on one connection. It is shared by both
+// of the connection's flows, which may see either half of an exchange first.
+type latencyTracker struct {
+	mu      sync.Mutex
+	pending map[uint32]pendingKeepAlive // Keyed by keep-alive ID
+	stats   LatencyStats
+
+	Client, Server netip.AddrPort
+}
+
+// Half of a keep-alive exchange, waiting for the other half.
+type pendingKeepAlive struct {
+	segmentType ffxiv.SegmentType
+	time        time.Time
+}
+
+func newLatencyTracker(client, server netip.AddrPort) *latencyTracker {
+	return &latencyTracker{
+		pending: make(map[uint32]pendingKeepAlive),
+		Client:  client,
+		Server:  server,
+	}
+}
+
+// Looks for keep-alives in a Bundle captured at t, and gets the latency of
+// every exchange that they complete.
+func (lt *latencyTracker) observe(bundle *ffxiv.Bundle, t time.Time) []*Latency {
+	if t.IsZero() {
+		return nil
+	}
+
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	var samples []*Latency
+
+	for i := range bundle.Segments {
+		segment := &bundle.Segments[i]
+
+		keepAlive, ok := segment.Payload.(*ffxiv.KeepAlive)
+		if !ok {
+			continue
+		}
+
+		if sample := lt.match(segment.Type, keepAlive.ID, t); sample != nil {
+			samples = append(samples, sample)
+		}
+	}
+
+	return samples
+}
+
+// Records half of a keep-alive exchange, and gets its latency
+// if the other half has already been seen.
+func (lt *latencyTracker) match(segmentType ffxiv.SegmentType, id uint32, t time.Time) *Latency {
+	other, ok := lt.pending[id]
+	if !ok || other.segmentType == segmentType {
+		lt.expire(t)
+
+		if len(lt.pending) < maxPendingKeepAlives {
+			lt.pending[id] = pendingKeepAlive{segmentType, t}
+		}
+
+		return nil
+	}
+
+	delete(lt.pending, id)
+
+	sent, answered := other.time, t
+	if segmentType == ffxiv.SegmentClientKeepAlive {
+		sent, answered = answered, sent
+	}
+
+	// The server doesn't answer before it's asked
+	rtt := answered.Sub(sent)
+	if rtt < 0 {
+		return nil
+	}
+
+	lt.stats.add(rtt)
+
+	return &Latency{
+		Client: lt.Client,
+		Server: lt.Server,
+		ID:     id,
+		Time:   answered,
+		RTT:    rtt,
+		Stats:  lt.stats,
+	}
+}
+
+// Forgets exchanges that have gone unanswered for too long.
+func (lt *latencyTracker) expire(now time.Time) {
+	for id, p := range lt.pending {
+		if now.Sub(p.time) > keepAliveTimeout {
+			delete(lt.pending, id)
+		}
+	}
+}
+
+var (
+	_ json.Marshaler = (*Latency)(nil)
+	_ json.Marshaler = LatencyStats{}
+)
@@ -0,0 +1,73 @@
+package net
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/sparta142/goblade/ffxiv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func keepAliveBundle(segmentType ffxiv.SegmentType, id uint32) *ffxiv.Bundle {
+	return &ffxiv.Bundle{
+		Segments: []ffxiv.Segment{
+			{Type: segmentType, Payload: &ffxiv.KeepAlive{ID: id, Epoch: 1624314019}},
+		},
+	}
+}
+
+func TestLatencyTracker(t *testing.T) {
+	t.Parallel()
+
+	client := netip.MustParseAddrPort("192.168.1.100:50432")
+	server := netip.MustParseAddrPort("204.2.229.9:55027")
+	lt := newLatencyTracker(client, server)
+	start := time.UnixMilli(1624314019411)
+
+	// The client asks, then the server answers
+	assert.Empty(t, lt.observe(keepAliveBundle(ffxiv.SegmentClientKeepAlive, 1), start))
+
+	samples := lt.observe(keepAliveBundle(ffxiv.SegmentServerKeepAlive, 1), start.Add(40*time.Millisecond))
+	require.Len(t, samples, 1)
+	assert.Equal(t, client, samples[0].Client)
+	assert.Equal(t, server, samples[0].Server)
+	assert.EqualValues(t, 1, samples[0].ID)
+	assert.Equal(t, 40*time.Millisecond, samples[0].RTT)
+
+	// The other flow may decode the server's answer first
+	assert.Empty(t, lt.observe(keepAliveBundle(ffxiv.SegmentServerKeepAlive, 2), start.Add(1080*time.Millisecond)))
+
+	samples = lt.observe(keepAliveBundle(ffxiv.SegmentClientKeepAlive, 2), start.Add(time.Second))
+	require.Len(t, samples, 1)
+	assert.Equal(t, 80*time.Millisecond, samples[0].RTT)
+
+	assert.Equal(t, LatencyStats{
+		Count:    2,
+		Min:      40 * time.Millisecond,
+		Max:      80 * time.Millisecond,
+		Mean:     60 * time.Millisecond,
+		Smoothed: 45 * time.Millisecond,
+	}, samples[0].Stats)
+}
+
+func TestLatencyTracker_ServerFirst(t *testing.T) {
+	t.Parallel()
+
+	lt := newLatencyTracker(netip.AddrPort{}, netip.AddrPort{})
+	start := time.UnixMilli(1624314019411)
+
+	// An exchange started by the server isn't a round trip to it
+	assert.Empty(t, lt.observe(keepAliveBundle(ffxiv.SegmentServerKeepAlive, 1), start))
+	assert.Empty(t, lt.observe(keepAliveBundle(ffxiv.SegmentClientKeepAlive, 1), start.Add(time.Millisecond)))
+}
+
+func TestLatencyTracker_UnknownTime(t *testing.T) {
+	t.Parallel()
+
+	lt := newLatencyTracker(netip.AddrPort{}, netip.AddrPort{})
+
+	assert.Empty(t, lt.observe(keepAliveBundle(ffxiv.SegmentClientKeepAlive, 1), time.Time{}))
+	assert.Empty(t, lt.observe(keepAliveBundle(ffxiv.SegmentServerKeepAlive, 1), time.Time{}))
+}
@@ -0,0 +1,380 @@
+package net
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sparta142/goblade/ffxiv"
+	"github.com/sparta142/goblade/oodle"
+	"golang.org/x/exp/maps"
+)
+
+// The upper bounds of the decompression time histogram buckets, in seconds.
+var decompressBuckets = [...]float64{
+	0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025,
+}
+
+// The kinds of errors counted by the decode errors metric.
+const (
+	errorKindResync         = "resync"          // Bytes skipped to find the next bundle
+	errorKindTooLarge       = "too_large"       // A bundle over the size limit
+	errorKindBadLength      = "bad_length"      // A bundle whose lengths don't add up
+	errorKindBadCompression = "bad_compression" // An unknown compression type
+	errorKindOodle          = "oodle"           // An Oodle payload that couldn't be decompressed
+	errorKindDecrypt        = "decrypt"         // A lobby segment that couldn't be decrypted
+	errorKindStream         = "stream"          // A failure that closed the flow
+	errorKindOther          = "other"
+)
+
+// An IPC opcode, and the direction it was sent in.
+type ipcKey struct {
+	fromServer bool
+	opcode     uint16
+}
+
+// The counts that are only exported as metrics.
+type metricCounters struct {
+	mu         sync.Mutex
+	segments   map[ffxiv.SegmentType]uint64
+	ipcs       map[ipcKey]uint64
+	errors     map[string]uint64
+	decompress map[ffxiv.CompressionType]*histogram
+	opcodes    *ffxiv.OpcodeStore
+}
+
+// A histogram of durations, in seconds.
+type histogram struct {
+	counts [len(decompressBuckets)]uint64 // Not cumulative
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+
+	h.count++
+	h.sum += seconds
+
+	for i, bound := range decompressBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			return
+		}
+	}
+}
+
+// Sets the opcode table used to name IPCs in the metrics. If it isn't
+// called, IPCs are only identified by their opcodes.
+func (c *Counters) SetOpcodes(opcodes *ffxiv.OpcodeStore) {
+	c.metrics.mu.Lock()
+	defer c.metrics.mu.Unlock()
+
+	c.metrics.opcodes = opcodes
+}
+
+// Counts the segments of a decoded Bundle.
+func (c *Counters) observeBundle(bundle *ffxiv.Bundle, fromServer bool) {
+	m := &c.metrics
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.segments == nil {
+		m.segments = make(map[ffxiv.SegmentType]uint64)
+		m.ipcs = make(map[ipcKey]uint64)
+	}
+
+	for i := range bundle.Segments {
+		segment := &bundle.Segments[i]
+		m.segments[segment.Type]++
+
+		if ipc, ok := segment.Payload.(*ffxiv.Ipc); ok {
+			m.ipcs[ipcKey{fromServer, ipc.Type}]++
+		}
+	}
+}
+
+// Counts an error in a flow.
+func (c *Counters) observeError(kind string) {
+	m := &c.metrics
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.errors == nil {
+		m.errors = make(map[string]uint64)
+	}
+
+	m.errors[kind]++
+}
+
+// Records how long a payload took to decompress.
+func (c *Counters) observeDecompress(compression ffxiv.CompressionType, elapsed time.Duration) {
+	m := &c.metrics
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.decompress == nil {
+		m.decompress = make(map[ffxiv.CompressionType]*histogram)
+	}
+
+	h, ok := m.decompress[compression]
+	if !ok {
+		h = &histogram{}
+		m.decompress[compression] = h
+	}
+
+	h.observe(elapsed)
+}
+
+// Gets the kind of error that a bundle couldn't be decoded because of.
+func decodeErrorKind(err error) string {
+	switch {
+	case errors.Is(err, ffxiv.ErrTooLarge):
+		return errorKindTooLarge
+	case errors.Is(err, ffxiv.ErrBadLength), errors.Is(err, ffxiv.ErrNotEnoughData):
+		return errorKindBadLength
+	case errors.Is(err, ffxiv.ErrBadCompression):
+		return errorKindBadCompression
+	case errors.Is(err, oodle.ErrDecompressionFailed),
+		errors.Is(err, oodle.ErrPlatformNotSupported),
+		errors.Is(err, oodle.ErrDecoderClosed):
+		return errorKindOodle
+	case errors.Is(err, ffxiv.ErrNoKeyPhrase):
+		return errorKindDecrypt
+	default:
+		return errorKindOther
+	}
+}
+
+// Writes the counters as metrics in the Prometheus text exposition format.
+func (c *Counters) WritePrometheus(w io.Writer) error {
+	stats := c.Stats()
+	pw := &promWriter{w: bufio.NewWriter(w)}
+
+	pw.metric("goblade_packets_total", "counter", "Packets that passed the capture filter.")
+	pw.sample("goblade_packets_total", nil, float64(stats.Packets))
+
+	pw.metric("goblade_captured_bytes_total", "counter", "Bytes of the packets that passed the capture filter.")
+	pw.sample("goblade_captured_bytes_total", nil, float64(stats.Bytes))
+
+	if stats.Pcap != nil {
+		pw.metric("goblade_pcap_received_packets_total", "counter", "Packets received by the pcap filter.")
+		pw.sample("goblade_pcap_received_packets_total", nil, float64(stats.Pcap.Received))
+
+		pw.metric("goblade_pcap_dropped_packets_total", "counter", "Packets dropped by pcap or the interface.")
+		pw.sample("goblade_pcap_dropped_packets_total", labels{"by", "pcap"}, float64(stats.Pcap.Dropped))
+		pw.sample("goblade_pcap_dropped_packets_total", labels{"by", "interface"}, float64(stats.Pcap.IfDropped))
+	}
+
+	pw.metric("goblade_active_streams", "gauge", "TCP connections that are currently open.")
+	pw.sample("goblade_active_streams", nil, float64(stats.ActiveStreams))
+
+	pw.metric("goblade_streams_total", "counter", "TCP connections that have been seen.")
+	pw.sample("goblade_streams_total", nil, float64(stats.TotalStreams))
+
+	pw.metric("goblade_reassembled_bytes_total", "counter", "Bytes of reassembled TCP data.")
+	pw.sample("goblade_reassembled_bytes_total", nil, float64(stats.Totals.Bytes))
+
+	pw.metric("goblade_lost_bytes_total", "counter", "Bytes of TCP data that were never captured.")
+	pw.sample("goblade_lost_bytes_total", nil, float64(stats.Totals.LostBytes))
+
+	pw.metric("goblade_bundles_total", "counter", "Bundles that were decoded.")
+	pw.sample("goblade_bundles_total", nil, float64(stats.Totals.Bundles))
+
+	pw.metric("goblade_output_queue_depth", "gauge", "Results waiting to be output, by output.")
+
+	for _, q := range stats.Queues {
+		pw.sample("goblade_output_queue_depth", labels{"queue", q.Name}, float64(q.Length))
+	}
+
+	pw.metric("goblade_output_queue_capacity", "gauge", "The most results that can wait to be output, by output.")
+
+	for _, q := range stats.Queues {
+		pw.sample("goblade_output_queue_capacity", labels{"queue", q.Name}, float64(q.Capacity))
+	}
+
+	pw.metric("goblade_output_queue_dropped_total", "counter", "Results dropped because their output was full.")
+
+	for _, q := range stats.Queues {
+		pw.sample("goblade_output_queue_dropped_total", labels{"queue", q.Name}, float64(q.Dropped))
+	}
+
+	c.writeMetricCounters(pw)
+
+	if pw.err != nil {
+		return pw.err
+	}
+
+	if err := pw.w.Flush(); err != nil {
+		return fmt.Errorf("write metrics: %w", err)
+	}
+
+	return nil
+}
+
+// Writes the counts that are only exported as metrics.
+func (c *Counters) writeMetricCounters(pw *promWriter) {
+	m := &c.metrics
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pw.metric("goblade_segments_total", "counter", "Segments of decoded bundles, by segment type.")
+
+	for _, segmentType := range sortedKeys(m.segments, func(a, b ffxiv.SegmentType) bool { return a < b }) {
+		pw.sample("goblade_segments_total", labels{"type", segmentType.String()}, float64(m.segments[segmentType]))
+	}
+
+	pw.metric("goblade_ipcs_total", "counter", "IPC segments of decoded bundles, by direction and opcode.")
+
+	var table *ffxiv.OpcodeTable
+	if m.opcodes != nil {
+		table = m.opcodes.Load()
+	}
+
+	for _, key := range sortedKeys(m.ipcs, func(a, b ipcKey) bool {
+		if a.fromServer != b.fromServer {
+			return a.fromServer
+		}
+
+		return a.opcode < b.opcode
+	}) {
+		direction := "client"
+		if key.fromServer {
+			direction = "server"
+		}
+
+		pw.sample("goblade_ipcs_total", labels{
+			"direction", direction,
+			"opcode", fmt.Sprintf("0x%04x", key.opcode),
+			"name", opcodeName(table, key),
+		}, float64(m.ipcs[key]))
+	}
+
+	pw.metric("goblade_decode_errors_total", "counter", "Errors decoding bundles, by kind.")
+
+	for _, kind := range sortedKeys(m.errors, func(a, b string) bool { return a < b }) {
+		pw.sample("goblade_decode_errors_total", labels{"kind", kind}, float64(m.errors[kind]))
+	}
+
+	pw.metric("goblade_decompress_seconds", "histogram", "Time taken to decompress bundle payloads, by compression type.")
+
+	for _, compression := range sortedKeys(m.decompress, func(a, b ffxiv.CompressionType) bool { return a < b }) {
+		h := m.decompress[compression]
+		name := compression.String()
+
+		var cumulative uint64
+
+		for i, bound := range decompressBuckets {
+			cumulative += h.counts[i]
+			pw.sample("goblade_decompress_seconds_bucket", labels{
+				"compression", name,
+				"le", formatFloat(bound),
+			}, float64(cumulative))
+		}
+
+		pw.sample("goblade_decompress_seconds_bucket", labels{"compression", name, "le", "+Inf"}, float64(h.count))
+		pw.sample("goblade_decompress_seconds_sum", labels{"compression", name}, h.sum)
+		pw.sample("goblade_decompress_seconds_count", labels{"compression", name}, float64(h.count))
+	}
+}
+
+// Gets the name of an IPC's opcode, or "unknown" if the table doesn't have it.
+func opcodeName(table *ffxiv.OpcodeTable, key ipcKey) string {
+	if table == nil {
+		return "unknown"
+	}
+
+	if name := table.NameOf(key.opcode, key.fromServer); name != "" {
+		return name
+	}
+
+	return "unknown"
+}
+
+// Gets the keys of m, sorted by less.
+func sortedKeys[K comparable, V any](m map[K]V, less func(a, b K) bool) []K {
+	keys := maps.Keys(m)
+	sort.Slice(keys, func(i, j int) bool { return less(keys[i], keys[j]) })
+
+	return keys
+}
+
+// Serves the counters as metrics in the Prometheus text exposition format.
+func MetricsHandler(c *Counters) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := c.WritePrometheus(w); err != nil {
+			log.WithError(err).Error("Failed to write metrics")
+		}
+	})
+}
+
+// Label names and values, alternating.
+type labels []string
+
+// Writes metrics in the Prometheus text exposition format,
+// remembering the first error.
+type promWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+// Writes the HELP and TYPE lines of a metric.
+func (pw *promWriter) metric(name, metricType, help string) {
+	pw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// Writes one sample of a metric.
+func (pw *promWriter) sample(name string, l labels, value float64) {
+	var b strings.Builder
+
+	b.WriteString(name)
+
+	if len(l) > 0 {
+		b.WriteByte('{')
+
+		for i := 0; i+1 < len(l); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+
+			fmt.Fprintf(&b, "%s=\"%s\"", l[i], escapeLabelValue(l[i+1]))
+		}
+
+		b.WriteByte('}')
+	}
+
+	pw.printf("%s %s\n", b.String(), formatFloat(value))
+}
+
+func (pw *promWriter) printf(format string, a ...any) {
+	if pw.err != nil {
+		return
+	}
+
+	if _, err := fmt.Fprintf(pw.w, format, a...); err != nil {
+		pw.err = fmt.Errorf("write metrics: %w", err)
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,100 @@
+package net
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/sparta142/goblade/ffxiv"
+	"github.com/sparta142/goblade/oodle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounters_WritePrometheus(t *testing.T) {
+	t.Parallel()
+
+	table, err := ffxiv.GetOpcodes(ffxiv.RegionGlobal)
+	require.NoError(t, err)
+
+	var store ffxiv.OpcodeStore
+	store.Store(table)
+
+	var counters Counters
+	counters.SetOpcodes(&store)
+	counters.addPacket(100)
+
+	bundle := &ffxiv.Bundle{Segments: []ffxiv.Segment{
+		{Type: ffxiv.SegmentIpc, Payload: &ffxiv.Ipc{Type: 911}},
+		{Type: ffxiv.SegmentIpc, Payload: &ffxiv.Ipc{Type: 0x1234}},
+		{Type: ffxiv.SegmentServerKeepAlive, Payload: &ffxiv.KeepAlive{}},
+	}}
+	counters.observeBundle(bundle, true)
+	counters.observeBundle(bundle, false)
+
+	counters.observeError(decodeErrorKind(fmt.Errorf("decompress payload: %w", oodle.ErrDecompressionFailed)))
+	counters.observeError(errorKindResync)
+	counters.observeDecompress(ffxiv.CompressionOodle, 200*time.Microsecond)
+
+	var buf bytes.Buffer
+	require.NoError(t, counters.WritePrometheus(&buf))
+	out := buf.String()
+
+	for _, line := range []string{
+		"# TYPE goblade_packets_total counter",
+		"goblade_packets_total 1",
+		"goblade_captured_bytes_total 100",
+		"goblade_active_streams 0",
+		`goblade_segments_total{type="Ipc"} 4`,
+		`goblade_segments_total{type="ServerKeepAlive"} 2`,
+		`goblade_ipcs_total{direction="server",opcode="0x038f",name="PlayerSpawn"} 1`,
+		`goblade_ipcs_total{direction="server",opcode="0x1234",name="unknown"} 1`,
+		`goblade_ipcs_total{direction="client",opcode="0x038f",name="unknown"} 1`,
+		`goblade_decode_errors_total{kind="oodle"} 1`,
+		`goblade_decode_errors_total{kind="resync"} 1`,
+		`goblade_decompress_seconds_bucket{compression="Oodle",le="0.0001"} 0`,
+		`goblade_decompress_seconds_bucket{compression="Oodle",le="0.00025"} 1`,
+		`goblade_decompress_seconds_bucket{compression="Oodle",le="+Inf"} 1`,
+		`goblade_decompress_seconds_count{compression="Oodle"} 1`,
+	} {
+		assert.Contains(t, out, line+"\n")
+	}
+
+	// There is no pcap handle or output queue
+	assert.NotContains(t, out, "goblade_pcap_")
+	assert.NotContains(t, out, "goblade_output_queue_depth{")
+}
+
+// A ResponseWriter whose client has gone away.
+type brokenResponseWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (brokenResponseWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
+
+//nolint:paralleltest // Hooks the global logger
+func TestMetricsHandler_WriteError(t *testing.T) {
+	hook := logtest.NewGlobal()
+	t.Cleanup(func() { log.StandardLogger().ReplaceHooks(make(log.LevelHooks)) })
+
+	w := brokenResponseWriter{httptest.NewRecorder()}
+	MetricsHandler(&Counters{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if entry := hook.LastEntry(); assert.NotNil(t, entry) {
+		assert.Equal(t, "Failed to write metrics", entry.Message)
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, `a\\b\"c\nd`, escapeLabelValue("a\\b\"c\nd"))
+}
@@ -0,0 +1,69 @@
+package net
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/goccy/go-json"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/sparta142/goblade/oodle"
+)
+
+// OodleStatus reports whether Oodle-compressed bundles can be decoded,
+// as found when the capture sets up decompression.
+type OodleStatus struct {
+	// The time that decompression was set up.
+	Time time.Time
+
+	// The backend that decompresses, or "none".
+	Backend string
+
+	// Why decompression couldn't be set up, or nil if it was.
+	Err error
+}
+
+// Reports whether Oodle-compressed bundles can be decoded.
+func (s *OodleStatus) Available() bool {
+	return s.Err == nil
+}
+
+func (s *OodleStatus) MarshalJSON() ([]byte, error) {
+	var errText string
+	if s.Err != nil {
+		errText = s.Err.Error()
+	}
+
+	data, err := json.Marshal(struct {
+		Time      time.Time `json:"time"`
+		Available bool      `json:"available"`
+		Backend   string    `json:"backend"`
+		Error     string    `json:"error,omitempty"`
+	}{s.Time, s.Available(), s.Backend, errText})
+	if err != nil {
+		return nil, fmt.Errorf("marshal oodle status: %w", err)
+	}
+
+	return data, nil
+}
+
+// Sets up Oodle decompression, and reports how it went to the oodle queue.
+// It reports whether decompression was set up, so that it must be shut down.
+func setupOodle(queues *outputQueues) bool {
+	err := oodle.Setup()
+	if err != nil {
+		log.WithError(err).Error("Failed to set up Oodle decompression, so compressed bundles can't be decoded")
+	}
+
+	status := &OodleStatus{
+		Time:    time.Now(),
+		Backend: fmt.Sprint(oodle.CurrentBackend()),
+		Err:     err,
+	}
+
+	if !queues.sendResult(status, true) && queues.oodle != nil {
+		queues.oodle.push(status)
+	}
+
+	return err == nil
+}
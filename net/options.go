@@ -0,0 +1,224 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/goccy/go-json"
+	log "github.com/sirupsen/logrus"
+	"github.com/sparta142/goblade/ffxiv"
+)
+
+var ErrInvalidOptions = errors.New("net: invalid options")
+
+// The defaults for Options fields that are left as zero, or as UseDefault.
+const (
+	DefaultMaxBufferedPagesPerConnection = 512
+	DefaultMaxBufferedPagesTotal         = 2048
+	DefaultFlushInterval                 = 1 * time.Minute
+	DefaultFlushStreamAge                = 3 * time.Minute
+	DefaultSnaplen                       = 2048
+	DefaultPipeBufferSize                = 2 * kibibytes
+	DefaultMaxBundleSize                 = ffxiv.DefaultMaxBundleSize
+	DefaultQueueSize                     = 256
+	DefaultQueuePolicy                   = QueueDropOldest
+)
+
+// The limits of Options.Snaplen. The smallest must fit the Ethernet,
+// IP and TCP headers with their options, and the largest is libpcap's.
+const (
+	minSnaplen = 128
+	maxSnaplen = 262144
+)
+
+// The smallest Options.PipeBufferSize and Options.MaxBundleSize,
+// which is the size of a Bundle header.
+const (
+	minPipeBufferSize = 40
+	minMaxBundleSize  = 40
+)
+
+// UseDefault gives the Options fields where zero means no limit their defaults.
+// Any negative value does the same.
+const UseDefault = -1
+
+// Options control how a capture decodes traffic. Zero fields take their
+// defaults, except for the ones where zero means no limit, which take
+// them when they are UseDefault.
+type Options struct {
+	// Whether to keep Bundles whose Oodle payloads can't be decompressed,
+	// with their payloads still compressed, instead of reporting errors.
+	// See ffxiv.Bundle.Compressed.
+	DeferOodle bool
+
+	// How often to report the capture's counters to Outputs.Stats.
+	// If it is zero, they are only reported when the capture ends.
+	StatsInterval time.Duration
+
+	// The counters to keep the capture's statistics in, so that they can be
+	// read while it runs. If it is nil, the capture uses its own.
+	Counters *Counters
+
+	// The opcode table used to name each Ipc, which can be replaced while
+	// the capture runs. If it is nil or empty, IPCs aren't named.
+	Opcodes *ffxiv.OpcodeStore
+
+	// The most pages of out-of-order data that the reassembler buffers for
+	// one connection, and for all of them. Each page holds one TCP segment.
+	// When either limit is reached, the missing data is given up as lost.
+	// Larger limits ride out more loss, such as on Wi-Fi, at the cost of memory.
+	// Zero means no limit, and UseDefault the default.
+	MaxBufferedPagesPerConnection int
+	MaxBufferedPagesTotal         int
+
+	// How often to look for connections with old out-of-order data.
+	FlushInterval time.Duration
+
+	// How old out-of-order data must be before it is flushed, giving up
+	// on the data missing before it.
+	FlushStreamAge time.Duration
+
+	// The most bytes to capture of each packet, for OpenLive.
+	Snaplen int
+
+	// The BPF filter that selects the packets to decode. If it is empty,
+	// TCP traffic between ephemeral ports on a known data center network is.
+	// See DefaultFilter for the traffic of a single region.
+	Filter string
+
+	// The size of the buffer between each flow's reassembly and decoding, in bytes.
+	// Reassembly waits for decoding when it is full, whatever the QueuePolicy,
+	// so no reassembled data is ever dropped.
+	PipeBufferSize int
+
+	// The largest Bundle to decode, both as sent and once its payload is
+	// decompressed. Larger Bundles are skipped and reported as errors.
+	// See ffxiv.Decoder.SetMaxBundleSize.
+	MaxBundleSize int
+
+	// The most results to hold for each output while its consumer catches up,
+	// and what to do with new results when that many are waiting. Dropping
+	// results keeps a slow consumer from stalling a live capture, where pcap
+	// would drop packets instead; QueueBlock suits files, which can wait.
+	QueueSize   int
+	QueuePolicy QueuePolicy
+}
+
+// Gets the options with their zero or UseDefault fields replaced by the defaults.
+func (o Options) WithDefaults() Options {
+	setLimitDefault(&o.MaxBufferedPagesPerConnection, DefaultMaxBufferedPagesPerConnection)
+	setLimitDefault(&o.MaxBufferedPagesTotal, DefaultMaxBufferedPagesTotal)
+	setDefault(&o.FlushInterval, DefaultFlushInterval)
+	setDefault(&o.FlushStreamAge, DefaultFlushStreamAge)
+	setDefault(&o.Snaplen, DefaultSnaplen)
+	setDefault(&o.PipeBufferSize, DefaultPipeBufferSize)
+	setDefault(&o.MaxBundleSize, DefaultMaxBundleSize)
+	setDefault(&o.QueueSize, DefaultQueueSize)
+
+	if o.Filter == "" {
+		o.Filter = bpfFilter()
+	}
+
+	if o.QueuePolicy == "" {
+		o.QueuePolicy = DefaultQueuePolicy
+	}
+
+	return o
+}
+
+func setDefault[T int | time.Duration](field *T, value T) {
+	if *field == 0 {
+		*field = value
+	}
+}
+
+// Sets the default of a limit, where zero means no limit.
+func setLimitDefault(field *int, value int) {
+	if *field < 0 {
+		*field = value
+	}
+}
+
+// Checks that the options, with their defaults, make sense.
+// Problems are reported with an error that wraps ErrInvalidOptions.
+func (o Options) Validate() error {
+	o = o.WithDefaults()
+
+	switch {
+	case o.StatsInterval < 0:
+		return fmt.Errorf("%w: stats interval %s is negative", ErrInvalidOptions, o.StatsInterval)
+	case o.MaxBufferedPagesTotal > 0 && o.MaxBufferedPagesPerConnection > o.MaxBufferedPagesTotal:
+		return fmt.Errorf("%w: max buffered pages per connection %d exceeds the total of %d",
+			ErrInvalidOptions, o.MaxBufferedPagesPerConnection, o.MaxBufferedPagesTotal)
+	case o.FlushInterval < 0:
+		return fmt.Errorf("%w: flush interval %s is negative", ErrInvalidOptions, o.FlushInterval)
+	case o.FlushStreamAge < 0:
+		return fmt.Errorf("%w: flush stream age %s is negative", ErrInvalidOptions, o.FlushStreamAge)
+	case o.Snaplen < minSnaplen || o.Snaplen > maxSnaplen:
+		return fmt.Errorf("%w: snaplen %d is not between %d and %d", ErrInvalidOptions, o.Snaplen, minSnaplen, maxSnaplen)
+	case o.PipeBufferSize < minPipeBufferSize:
+		return fmt.Errorf("%w: pipe buffer size %d is less than %d", ErrInvalidOptions, o.PipeBufferSize, minPipeBufferSize)
+	case o.MaxBundleSize < minMaxBundleSize:
+		return fmt.Errorf("%w: max bundle size %d is less than %d", ErrInvalidOptions, o.MaxBundleSize, minMaxBundleSize)
+	case o.QueueSize < 0:
+		return fmt.Errorf("%w: queue size %d is negative", ErrInvalidOptions, o.QueueSize)
+	}
+
+	return o.QueuePolicy.validate()
+}
+
+// Logs the options that tune the capture.
+func (o *Options) log() {
+	log.WithFields(log.Fields{
+		"maxBufferedPagesPerConnection": o.MaxBufferedPagesPerConnection,
+		"maxBufferedPagesTotal":         o.MaxBufferedPagesTotal,
+		"flushInterval":                 o.FlushInterval,
+		"flushStreamAge":                o.FlushStreamAge,
+		"snaplen":                       o.Snaplen,
+		"filter":                        o.Filter,
+		"pipeBufferSize":                o.PipeBufferSize,
+		"maxBundleSize":                 o.MaxBundleSize,
+		"queueSize":                     o.QueueSize,
+		"queuePolicy":                   o.QueuePolicy,
+		"deferOodle":                    o.DeferOodle,
+		"statsInterval":                 o.StatsInterval,
+	}).Info("Starting capture")
+}
+
+func (o *Options) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(struct {
+		DeferOodle                    bool        `json:"deferOodle"`
+		StatsInterval                 string      `json:"statsInterval"`
+		MaxBufferedPagesPerConnection int         `json:"maxBufferedPagesPerConnection"`
+		MaxBufferedPagesTotal         int         `json:"maxBufferedPagesTotal"`
+		FlushInterval                 string      `json:"flushInterval"`
+		FlushStreamAge                string      `json:"flushStreamAge"`
+		Snaplen                       int         `json:"snaplen"`
+		Filter                        string      `json:"filter"`
+		PipeBufferSize                int         `json:"pipeBufferSize"`
+		MaxBundleSize                 int         `json:"maxBundleSize"`
+		QueueSize                     int         `json:"queueSize"`
+		QueuePolicy                   QueuePolicy `json:"queuePolicy"`
+	}{
+		o.DeferOodle,
+		o.StatsInterval.String(),
+		o.MaxBufferedPagesPerConnection,
+		o.MaxBufferedPagesTotal,
+		o.FlushInterval.String(),
+		o.FlushStreamAge.String(),
+		o.Snaplen,
+		o.Filter,
+		o.PipeBufferSize,
+		o.MaxBundleSize,
+		o.QueueSize,
+		o.QueuePolicy,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal options: %w", err)
+	}
+
+	return data, nil
+}
+
+var _ json.Marshaler = (*Options)(nil)
@@ -0,0 +1,83 @@
+package net
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/sparta142/goblade/ffxiv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptions_WithDefaults(t *testing.T) {
+	t.Parallel()
+
+	opts := Options{
+		MaxBufferedPagesPerConnection: UseDefault,
+		MaxBufferedPagesTotal:         8192,
+		FlushInterval:                 10 * time.Second,
+	}.WithDefaults()
+
+	assert.Equal(t, DefaultMaxBufferedPagesPerConnection, opts.MaxBufferedPagesPerConnection)
+	assert.Equal(t, 8192, opts.MaxBufferedPagesTotal)
+	assert.Equal(t, 10*time.Second, opts.FlushInterval)
+	assert.Equal(t, DefaultFlushStreamAge, opts.FlushStreamAge)
+	assert.Equal(t, DefaultSnaplen, opts.Snaplen)
+	assert.Equal(t, DefaultPipeBufferSize, opts.PipeBufferSize)
+	assert.Equal(t, DefaultMaxBundleSize, opts.MaxBundleSize)
+	assert.Equal(t, DefaultQueueSize, opts.QueueSize)
+	assert.Equal(t, QueueDropOldest, opts.QueuePolicy)
+
+	// Zero means no limit on the buffered pages
+	opts = Options{}.WithDefaults()
+	assert.Zero(t, opts.MaxBufferedPagesPerConnection)
+	assert.Zero(t, opts.MaxBufferedPagesTotal)
+}
+
+func TestDefaultFilter(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, bpfFilter(), DefaultFilter(ffxiv.RegionGlobal))
+	assert.Contains(t, DefaultFilter(""), "net 124.150.152.0/21 or ")
+
+	// No networks are known for the Korean service, so none are filtered out
+	assert.Equal(t, portFilter, DefaultFilter(ffxiv.RegionKorea))
+}
+
+func TestOptions_Validate(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, Options{}.Validate())
+	require.NoError(t, Options{MaxBufferedPagesPerConnection: 4096, MaxBufferedPagesTotal: 16384}.Validate())
+	require.NoError(t, Options{MaxBufferedPagesPerConnection: 4096}.Validate())
+
+	for name, opts := range map[string]Options{
+		"more than total":    {MaxBufferedPagesPerConnection: 4096, MaxBufferedPagesTotal: UseDefault},
+		"negative interval":  {FlushInterval: -time.Second},
+		"negative age":       {FlushStreamAge: -time.Second},
+		"negative stats":     {StatsInterval: -time.Second},
+		"tiny snaplen":       {Snaplen: 64},
+		"huge snaplen":       {Snaplen: 1 << 20},
+		"tiny pipe buffer":   {PipeBufferSize: 16},
+		"negative pipe size": {PipeBufferSize: -1},
+		"tiny bundle size":   {MaxBundleSize: 16},
+		"negative bundles":   {MaxBundleSize: -1},
+		"negative queue":     {QueueSize: -1},
+		"unknown policy":     {QueuePolicy: "drop-everything"},
+	} {
+		assert.ErrorIs(t, opts.Validate(), ErrInvalidOptions, name)
+	}
+}
+
+func TestOptions_MarshalJSON(t *testing.T) {
+	t.Parallel()
+
+	opts := Options{MaxBufferedPagesTotal: UseDefault}.WithDefaults()
+
+	data, err := json.Marshal(&opts)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"flushInterval":"1m0s"`)
+	assert.Contains(t, string(data), `"maxBufferedPagesTotal":2048`)
+	assert.Contains(t, string(data), `"maxBundleSize":1048576`)
+}
@@ -0,0 +1,132 @@
+package net
+
+import (
+	"io"
+	"math"
+	"sync"
+)
+
+// Orders the decoding of a connection's server data after the client data
+// that was reassembled before it. The flows are decoded concurrently, but
+// the lobby session that decrypts the server's replies is started by the
+// client's data, so the replies must not be decoded before it.
+type decodeOrder struct {
+	mu   sync.Mutex
+	cond sync.Cond
+
+	// The number of bytes the client decoder had read when it last asked for
+	// more. Every complete Bundle in them has been decoded by then.
+	clientDecoded int64
+
+	// Server stream offsets that have to wait for the client to decode,
+	// in order of offset and of watermark
+	marks []orderMark
+}
+
+// Marks that the server data from offset onwards was reassembled after
+// watermark bytes of client data.
+type orderMark struct {
+	offset    int64
+	watermark int64
+}
+
+func newDecodeOrder() *decodeOrder {
+	order := &decodeOrder{}
+	order.cond.L = &order.mu
+
+	return order
+}
+
+// Records that the server data about to be written at offset must wait for
+// the client to decode the first watermark bytes of its data.
+func (o *decodeOrder) mark(offset, watermark int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if watermark <= o.clientDecoded {
+		return
+	}
+
+	if n := len(o.marks); n > 0 && o.marks[n-1].watermark == watermark {
+		return
+	}
+
+	o.marks = append(o.marks, orderMark{offset, watermark})
+}
+
+// Records that the client decoder has decoded every complete Bundle
+// in the first n bytes of its data.
+func (o *decodeOrder) decoded(n int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if n <= o.clientDecoded {
+		return
+	}
+
+	o.clientDecoded = n
+	o.cond.Broadcast()
+}
+
+// Releases all server data, once the client has stopped decoding.
+func (o *decodeOrder) clientDone() {
+	o.decoded(math.MaxInt64)
+}
+
+// Waits until the server data at offset can be decoded, and gets how much
+// of it can be, or -1 if all of it can.
+func (o *decodeOrder) wait(offset int64) int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for {
+		// Watermarks only increase, so the marks before a released one are too
+		for len(o.marks) > 0 && o.marks[0].watermark <= o.clientDecoded {
+			o.marks = o.marks[1:]
+		}
+
+		switch {
+		case len(o.marks) == 0:
+			return -1
+		case o.marks[0].offset > offset:
+			return o.marks[0].offset - offset
+		}
+
+		o.cond.Wait()
+	}
+}
+
+// Reads the client's data, reporting its progress to a decodeOrder.
+type clientReader struct {
+	r     io.Reader
+	order *decodeOrder
+	read  int64
+}
+
+func (r *clientReader) Read(p []byte) (int, error) {
+	// The decoder only reads once it has decoded everything it can
+	r.order.decoded(r.read)
+
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+
+	return n, err //nolint:wrapcheck
+}
+
+// Reads the server's data as a decodeOrder allows.
+type serverReader struct {
+	r      io.Reader
+	order  *decodeOrder
+	offset int64
+}
+
+func (r *serverReader) Read(p []byte) (int, error) {
+	if limit := r.order.wait(r.offset); limit >= 0 && int64(len(p)) > limit {
+		p = p[:limit]
+	}
+
+	n, err := r.r.Read(p)
+	r.offset += int64(n)
+
+	return n, err //nolint:wrapcheck
+}
@@ -0,0 +1,121 @@
+package net
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sparta142/goblade/ffxiv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeOrder(t *testing.T) {
+	t.Parallel()
+
+	order := newDecodeOrder()
+	client := &clientReader{r: bytes.NewReader(make([]byte, 10)), order: order}
+	server := &serverReader{r: bytes.NewReader([]byte("0123456789")), order: order}
+
+	// The first 4 bytes of server data came before any client data,
+	// and the rest after the client's 10 bytes
+	order.mark(0, 0)
+	order.mark(4, 10)
+
+	buf := make([]byte, 10)
+	n, err := server.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "0123", string(buf[:n]))
+
+	read := make(chan string)
+
+	go func() {
+		n, _ := server.Read(buf)
+		read <- string(buf[:n])
+	}()
+
+	// Reading the client's data isn't enough, since it hasn't been decoded yet
+	_, err = client.Read(make([]byte, 10))
+	require.NoError(t, err)
+
+	select {
+	case <-read:
+		require.FailNow(t, "server data was released before the client's was decoded")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Asking for more means that everything read so far has been decoded
+	_, err = client.Read(make([]byte, 10))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, "456789", receive(t, read))
+}
+
+func TestDecodeOrder_ClientDone(t *testing.T) {
+	t.Parallel()
+
+	order := newDecodeOrder()
+	server := &serverReader{r: bytes.NewReader([]byte("reply")), order: order}
+	order.mark(0, 100)
+
+	read := make(chan string)
+
+	go func() {
+		data, _ := io.ReadAll(server)
+		read <- string(data)
+	}()
+
+	order.clientDone()
+	assert.Equal(t, "reply", receive(t, read))
+}
+
+// Marshals a lobby IPC bundle, encrypting its segment payload with session.
+func encryptedIpcBundle(t *testing.T, session *ffxiv.LobbySession, data []byte) []byte {
+	t.Helper()
+
+	bundle := marshalBundle(t, &ffxiv.Bundle{Segments: []ffxiv.Segment{
+		{Type: ffxiv.SegmentIpc, Payload: &ffxiv.Ipc{Magic: 0x14, Type: 0x000c, Data: data}},
+	}})
+
+	// The payload follows the bundle and segment headers
+	const payloadOffset = 40 + 16
+	copy(bundle[payloadOffset:], session.Encrypt(ffxiv.SegmentIpc, bundle[payloadOffset:]))
+
+	return bundle
+}
+
+//nolint:paralleltest // Creating flows sets up the global Oodle backend
+func TestTCPFlow_Run_LobbyOrder(t *testing.T) {
+	bundles := make(chan *FlowBundle, 2)
+	stream := openStream(t, Outputs{FlowBundles: bundles}, Options{})
+	stream.toServer.decoder.SetCaptureTime(0, time.UnixMilli(1624314019411))
+	stream.toClient.decoder.SetCaptureTime(0, time.UnixMilli(1624314019411))
+
+	init := &ffxiv.EncryptionInit{KeyPhrase: "31e9bd4be04a89a9e24ef7f6b3e4c0a5", Key: 0x63f7a3c1}
+
+	var session ffxiv.LobbySession
+	require.NoError(t, session.Start(init))
+
+	// The server's reply is written straight after the client's handshake,
+	// which is slow to decode, so the reply would be decoded first if the
+	// flows weren't ordered
+	stream.toServer.decoder.SetDecompressObserver(func(ffxiv.CompressionType, time.Duration) {
+		time.Sleep(50 * time.Millisecond)
+	})
+	stream.write(stream.toServer, marshalBundle(t, &ffxiv.Bundle{Segments: []ffxiv.Segment{
+		{Type: ffxiv.SegmentEncryptionInit, Payload: init},
+	}}))
+	stream.write(stream.toClient, encryptedIpcBundle(t, &session, []byte("Hello, Eorzea!\x00\x00")))
+
+	for i := 0; i < 2; i++ {
+		bundle := receive(t, bundles)
+		if !bundle.FromServer {
+			continue
+		}
+
+		ipc, ok := bundle.Bundle.Segments[0].Payload.(*ffxiv.Ipc)
+		require.True(t, ok)
+		assert.EqualValues(t, 0x14, ipc.Magic)
+		assert.Equal(t, []byte("Hello, Eorzea!\x00\x00"), ipc.Data)
+	}
+}
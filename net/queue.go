@@ -0,0 +1,383 @@
+package net
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sparta142/goblade/ffxiv"
+	"golang.org/x/exp/slices"
+)
+
+// QueuePolicy is what an output queue does with a new result when it is full.
+type QueuePolicy string
+
+const (
+	// Wait for the consumer to make room. Nothing is lost, but a slow
+	// consumer stalls decoding, then reassembly, and then the capture,
+	// at which point pcap drops packets.
+	QueueBlock = QueuePolicy("block")
+
+	// Drop the oldest queued result to make room for the new one.
+	QueueDropOldest = QueuePolicy("drop-oldest")
+
+	// Drop the new result.
+	QueueDropNewest = QueuePolicy("drop-newest")
+)
+
+// Checks that the policy is one of the known ones.
+func (p QueuePolicy) validate() error {
+	switch p {
+	case QueueBlock, QueueDropOldest, QueueDropNewest:
+		return nil
+	default:
+		return fmt.Errorf("%w: queue policy %q is not %s, %s or %s",
+			ErrInvalidOptions, p, QueueBlock, QueueDropOldest, QueueDropNewest)
+	}
+}
+
+// How often to keep logging that something is being dropped.
+const dropLogInterval = 10 * time.Second
+
+// Limits the warnings about drops, which can happen for every result,
+// to one every dropLogInterval. It isn't safe for concurrent use.
+type dropLog struct {
+	last time.Time
+}
+
+// Reports whether a drop at now should be logged.
+func (l *dropLog) due(now time.Time) bool {
+	if !l.last.IsZero() && now.Sub(l.last) < dropLogInterval {
+		return false
+	}
+
+	l.last = now
+
+	return true
+}
+
+// QueueStats are the state of one output queue.
+type QueueStats struct {
+	// The output that the queue feeds, such as "bundles".
+	Name string `json:"name"`
+
+	// The results waiting in the queue, and the most that it holds.
+	Length   int `json:"length"`
+	Capacity int `json:"capacity"`
+
+	// The results that were dropped because the queue was full.
+	Dropped uint64 `json:"dropped"`
+}
+
+// A bounded FIFO queue between the capture and one of its outputs,
+// so that a slow consumer doesn't hold up reassembly (unless the
+// policy is QueueBlock).
+type queue[T any] struct {
+	name   string
+	size   int
+	policy QueuePolicy
+
+	mu       sync.Mutex
+	notEmpty sync.Cond
+	notFull  sync.Cond
+	items    []queued[T]
+	closed   bool
+
+	dropped atomic.Uint64
+	dropLog dropLog
+}
+
+// A value in a queue, and whether the policy may drop it.
+type queued[T any] struct {
+	v    T
+	keep bool
+}
+
+func newQueue[T any](name string, size int, policy QueuePolicy) *queue[T] {
+	q := &queue[T]{name: name, size: size, policy: policy, items: make([]queued[T], 0, size)}
+	q.notEmpty.L = &q.mu
+	q.notFull.L = &q.mu
+
+	return q
+}
+
+// Adds v to the back of the queue, applying the policy if it's full.
+// Values pushed after the queue is closed are discarded.
+func (q *queue[T]) push(v T) {
+	q.add(v, false)
+}
+
+// Adds v to the back of the queue like push, but never drops it, since other
+// values depend on it. If the queue is full and the policy is to drop, it
+// grows past its size instead.
+func (q *queue[T]) keep(v T) {
+	q.add(v, true)
+}
+
+func (q *queue[T]) add(v T, keep bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.policy == QueueBlock {
+		for len(q.items) >= q.size && !q.closed {
+			q.notFull.Wait()
+		}
+	}
+
+	switch {
+	case q.closed:
+		return
+
+	case len(q.items) < q.size || keep:
+
+	case q.policy == QueueDropOldest && q.dropOldest():
+
+	default:
+		q.drop()
+		return
+	}
+
+	q.items = append(q.items, queued[T]{v, keep})
+	q.notEmpty.Signal()
+}
+
+// Drops the oldest value that isn't kept, and reports whether there was one.
+// q.mu must be held.
+func (q *queue[T]) dropOldest() bool {
+	i := slices.IndexFunc(q.items, func(item queued[T]) bool { return !item.keep })
+	if i < 0 {
+		return false
+	}
+
+	if i == 0 {
+		q.items[0] = queued[T]{}
+		q.items = q.items[1:]
+	} else {
+		last := len(q.items) - 1
+		copy(q.items[i:], q.items[i+1:])
+		q.items[last] = queued[T]{}
+		q.items = q.items[:last]
+	}
+
+	q.drop()
+
+	return true
+}
+
+// Counts a dropped value. q.mu must be held.
+func (q *queue[T]) drop() {
+	dropped := q.dropped.Add(1)
+
+	if q.dropLog.due(time.Now()) {
+		log.Warnf("The %s output isn't keeping up, so %s are being dropped (%d so far, queue policy %s)",
+			q.name, q.name, dropped, q.policy)
+	}
+}
+
+// Removes the value at the front of the queue, waiting for one if it's
+// empty. It returns false once the queue is closed and empty.
+func (q *queue[T]) pop() (T, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.items) == 0 && !q.closed {
+		q.notEmpty.Wait()
+	}
+
+	var zero T
+
+	if len(q.items) == 0 {
+		return zero, false
+	}
+
+	v := q.items[0].v
+	q.items[0] = queued[T]{}
+	q.items = q.items[1:]
+	q.notFull.Signal()
+
+	return v, true
+}
+
+// Closes the queue. Values already in it can still be popped.
+func (q *queue[T]) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+}
+
+func (q *queue[T]) stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return QueueStats{Name: q.name, Length: len(q.items), Capacity: q.size, Dropped: q.dropped.Load()}
+}
+
+// Sends everything popped from q to out, then closes out once q is closed and drained.
+func (q *queue[T]) forward(out chan<- T, wg *sync.WaitGroup) {
+	defer wg.Done()
+	defer close(out)
+
+	for {
+		v, ok := q.pop()
+		if !ok {
+			return
+		}
+
+		out <- v
+	}
+}
+
+// The queues in front of each of the capture's outputs. A queue is nil if
+// its output is, in which case its results are logged instead. If there is
+// a results queue, it takes every result, and the others are all nil.
+type outputQueues struct {
+	results     *queue[Result]
+	bundles     *queue[ffxiv.Bundle]
+	flowBundles *queue[*FlowBundle]
+	errors      *queue[*FlowError]
+	latency     *queue[*Latency]
+	regions     *queue[*RegionDetection]
+	stats       *queue[*Stats]
+	opened      *queue[*ConnectionOpened]
+	closed      *queue[*ConnectionClosed]
+	gaps        *queue[*DataGap]
+	oodle       *queue[*OodleStatus]
+
+	wg sync.WaitGroup
+}
+
+// Creates queues for the outputs, and starts forwarding from them.
+func newOutputQueues(outputs Outputs, opts Options) *outputQueues {
+	qs := &outputQueues{}
+	if outputs.Results != nil {
+		qs.results = startQueue(&qs.wg, "results", outputs.Results, opts)
+		return qs
+	}
+
+	qs.bundles = startQueue(&qs.wg, "bundles", outputs.Bundles, opts)
+	qs.flowBundles = startQueue(&qs.wg, "flow bundles", outputs.FlowBundles, opts)
+	qs.errors = startQueue(&qs.wg, "errors", outputs.Errors, opts)
+	qs.latency = startQueue(&qs.wg, "latencies", outputs.Latency, opts)
+	qs.regions = startQueue(&qs.wg, "regions", outputs.Regions, opts)
+	qs.stats = startQueue(&qs.wg, "stats", outputs.Stats, opts)
+	qs.opened = startQueue(&qs.wg, "opened connections", outputs.Opened, opts)
+	qs.closed = startQueue(&qs.wg, "closed connections", outputs.Closed, opts)
+	qs.gaps = startQueue(&qs.wg, "gaps", outputs.Gaps, opts)
+	qs.oodle = startQueue(&qs.wg, "oodle statuses", outputs.Oodle, opts)
+
+	return qs
+}
+
+// Creates a queue that forwards to out, or nil if out is nil.
+func startQueue[T any](wg *sync.WaitGroup, name string, out chan<- T, opts Options) *queue[T] {
+	if out == nil {
+		return nil
+	}
+
+	q := newQueue[T](name, opts.QueueSize, opts.QueuePolicy)
+
+	wg.Add(1)
+	go q.forward(out, wg)
+
+	return q
+}
+
+// The methods shared by queues of every type.
+type outputQueue interface {
+	stats() QueueStats
+	close()
+}
+
+// Gets every queue that isn't nil.
+func (qs *outputQueues) all() []outputQueue {
+	var all []outputQueue
+
+	if qs.results != nil {
+		all = append(all, qs.results)
+	}
+
+	if qs.bundles != nil {
+		all = append(all, qs.bundles)
+	}
+
+	if qs.flowBundles != nil {
+		all = append(all, qs.flowBundles)
+	}
+
+	if qs.errors != nil {
+		all = append(all, qs.errors)
+	}
+
+	if qs.latency != nil {
+		all = append(all, qs.latency)
+	}
+
+	if qs.regions != nil {
+		all = append(all, qs.regions)
+	}
+
+	if qs.stats != nil {
+		all = append(all, qs.stats)
+	}
+
+	if qs.opened != nil {
+		all = append(all, qs.opened)
+	}
+
+	if qs.closed != nil {
+		all = append(all, qs.closed)
+	}
+
+	if qs.gaps != nil {
+		all = append(all, qs.gaps)
+	}
+
+	if qs.oodle != nil {
+		all = append(all, qs.oodle)
+	}
+
+	return all
+}
+
+// Sends a result to the results queue, if there is one, and reports whether
+// it did. Results that frame others, such as a connection opening, are kept
+// whatever the policy, so that they stay in order with what they frame.
+func (qs *outputQueues) sendResult(result Result, keep bool) bool {
+	switch {
+	case qs.results == nil:
+		return false
+	case keep:
+		qs.results.keep(result)
+	default:
+		qs.results.push(result)
+	}
+
+	return true
+}
+
+// Gets the stats of every queue.
+func (qs *outputQueues) queueStats() []QueueStats {
+	all := qs.all()
+	stats := make([]QueueStats, 0, len(all))
+
+	for _, q := range all {
+		stats = append(stats, q.stats())
+	}
+
+	return stats
+}
+
+// Closes every queue, and waits for them to drain into their outputs,
+// which are then closed.
+func (qs *outputQueues) close() {
+	for _, q := range qs.all() {
+		q.close()
+	}
+
+	qs.wg.Wait()
+}
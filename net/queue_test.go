@@ -0,0 +1,140 @@
+package net
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Pops everything in a closed queue.
+func drain[T any](q *queue[T]) []T {
+	var values []T
+
+	for {
+		v, ok := q.pop()
+		if !ok {
+			return values
+		}
+
+		values = append(values, v)
+	}
+}
+
+func TestQueue_DropOldest(t *testing.T) {
+	t.Parallel()
+
+	q := newQueue[int]("test", 3, QueueDropOldest)
+	for i := 1; i <= 5; i++ {
+		q.push(i)
+	}
+
+	assert.Equal(t, QueueStats{Name: "test", Length: 3, Capacity: 3, Dropped: 2}, q.stats())
+
+	q.close()
+	q.push(6)
+	assert.Equal(t, []int{3, 4, 5}, drain(q))
+}
+
+func TestQueue_DropNewest(t *testing.T) {
+	t.Parallel()
+
+	q := newQueue[int]("test", 3, QueueDropNewest)
+	for i := 1; i <= 5; i++ {
+		q.push(i)
+	}
+
+	q.close()
+	assert.Equal(t, []int{1, 2, 3}, drain(q))
+	assert.Equal(t, uint64(2), q.stats().Dropped)
+}
+
+func TestQueue_Keep(t *testing.T) {
+	t.Parallel()
+
+	// Kept values stay in order with the rest, and are never dropped
+	q := newQueue[int]("test", 2, QueueDropOldest)
+	q.keep(1)
+	q.push(2)
+	q.push(3)
+	q.keep(4)
+	q.push(5)
+
+	q.close()
+	assert.Equal(t, []int{1, 4, 5}, drain(q))
+	assert.Equal(t, uint64(2), q.stats().Dropped)
+
+	q = newQueue[int]("test", 1, QueueDropNewest)
+	q.keep(1)
+	q.push(2)
+	q.keep(3)
+
+	q.close()
+	assert.Equal(t, []int{1, 3}, drain(q))
+}
+
+func TestQueue_Block(t *testing.T) {
+	t.Parallel()
+
+	q := newQueue[int]("test", 1, QueueBlock)
+	q.push(1)
+
+	pushed := make(chan struct{})
+
+	go func() {
+		q.push(2)
+		close(pushed)
+	}()
+
+	// The second push waits for room
+	select {
+	case <-pushed:
+		t.Fatal("push didn't block on a full queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	v, ok := q.pop()
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	<-pushed
+
+	q.close()
+	assert.Equal(t, []int{2}, drain(q))
+	assert.Zero(t, q.stats().Dropped)
+}
+
+func TestQueue_Forward(t *testing.T) {
+	t.Parallel()
+
+	q := newQueue[int]("test", 4, QueueBlock)
+	out := make(chan int)
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go q.forward(out, &wg)
+
+	q.push(1)
+	q.push(2)
+	q.close()
+
+	var received []int
+	for v := range out {
+		received = append(received, v)
+	}
+
+	wg.Wait()
+	assert.Equal(t, []int{1, 2}, received)
+}
+
+func TestDropLog(t *testing.T) {
+	t.Parallel()
+
+	var l dropLog
+
+	start := time.Now()
+	assert.True(t, l.due(start))
+	assert.False(t, l.due(start.Add(dropLogInterval/2)))
+	assert.True(t, l.due(start.Add(dropLogInterval)))
+}
@@ -0,0 +1,64 @@
+package net
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/sparta142/goblade/ffxiv"
+)
+
+// RegionDetection is the opcode region of a new connection,
+// detected from the data center network of its server address.
+type RegionDetection struct {
+	// The endpoints of the connection.
+	Client, Server netip.AddrPort
+
+	// The time that the connection was first seen.
+	Time time.Time
+
+	Region ffxiv.Region
+
+	// The data centers that the server may belong to.
+	DataCenters []string
+}
+
+func (d *RegionDetection) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(struct {
+		Client      netip.AddrPort `json:"client"`
+		Server      netip.AddrPort `json:"server"`
+		Time        time.Time      `json:"time"`
+		Region      ffxiv.Region   `json:"region"`
+		DataCenters []string       `json:"dataCenters"`
+	}{d.Client, d.Server, d.Time, d.Region, d.DataCenters})
+	if err != nil {
+		return nil, fmt.Errorf("marshal region detection: %w", err)
+	}
+
+	return data, nil
+}
+
+// Detects the region of a connection between a and b, whichever of them is the server.
+// It returns nil if neither is in a known data center network.
+func detectRegion(a, b netip.AddrPort, t time.Time) *RegionDetection {
+	resolver := ffxiv.DefaultResolver()
+
+	for _, ends := range [...][2]netip.AddrPort{{b, a}, {a, b}} {
+		client, server := ends[0], ends[1]
+
+		if network, ok := resolver.Network(server.Addr().AsSlice()); ok {
+			return &RegionDetection{
+				Client:      client,
+				Server:      server,
+				Time:        t,
+				Region:      network.Region,
+				DataCenters: network.DataCenters,
+			}
+		}
+	}
+
+	return nil
+}
+
+var _ json.Marshaler = (*RegionDetection)(nil)
@@ -0,0 +1,121 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
+)
+
+var ErrUnknownFormat = errors.New("net: not a pcap or pcapng file")
+
+// The magic bytes at the start of pcapng files.
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+// The magic bytes at the start of pcap files.
+var pcapMagics = [...][]byte{
+	{0xd4, 0xc3, 0xb2, 0xa1}, // Little-endian
+	{0xa1, 0xb2, 0xc3, 0xd4}, // Big-endian
+	{0x4d, 0x3c, 0xb2, 0xa1}, // Nanosecond timestamps, little-endian
+	{0xa1, 0xb2, 0x3c, 0x4d}, // Nanosecond timestamps, big-endian
+}
+
+// PacketReader is a source of packets with a single link type,
+// such as a *pcapgo.Reader or *pcapgo.NgReader.
+type PacketReader interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
+
+// Reports whether r starts with the magic bytes of a pcap or pcapng file.
+func IsCapture(r *bufio.Reader) bool {
+	magic, _ := r.Peek(len(pcapngMagic))
+	if bytes.Equal(magic, pcapngMagic) {
+		return true
+	}
+
+	for _, m := range pcapMagics {
+		if bytes.Equal(magic, m) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Creates a PacketReader for pcap or pcapng data, which is parsed in Go
+// rather than by libpcap. Capturing from it still needs libpcap; see CaptureReader.
+func NewPacketReader(r io.Reader) (PacketReader, error) { //nolint:ireturn
+	br := bufio.NewReader(r)
+	if !IsCapture(br) {
+		return nil, ErrUnknownFormat
+	}
+
+	if magic, _ := br.Peek(len(pcapngMagic)); bytes.Equal(magic, pcapngMagic) {
+		reader, err := pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			return nil, fmt.Errorf("read pcapng header: %w", err)
+		}
+
+		return reader, nil
+	}
+
+	reader, err := pcapgo.NewReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("read pcap header: %w", err)
+	}
+
+	return reader, nil
+}
+
+// Captures like CaptureOptions, but from a PacketReader instead of a pcap
+// handle. The filter is still compiled by libpcap, with pcap.NewBPF, so
+// libpcap must be installed; only the packets are read without it.
+func CaptureReader(ctx context.Context, r PacketReader, outputs Outputs, opts Options) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	opts = opts.WithDefaults()
+	opts.log()
+
+	bpf, err := pcap.NewBPF(r.LinkType(), opts.Snaplen, opts.Filter)
+	if err != nil {
+		return fmt.Errorf("compile bpf packet filter: %w", err)
+	}
+
+	capture(ctx, newPacketSource(&filteredReader{r, bpf}, r.LinkType()), nil, outputs, opts)
+
+	return nil
+}
+
+// A PacketReader that skips the packets that don't match a filter.
+type filteredReader struct {
+	PacketReader
+	bpf *pcap.BPF
+}
+
+func (r *filteredReader) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	for {
+		data, ci, err := r.PacketReader.ReadPacketData()
+		if err != nil || r.bpf.Matches(ci, data) {
+			return data, ci, err //nolint:wrapcheck
+		}
+	}
+}
+
+// Creates a packet source that decodes packets from src lazily and without copying.
+func newPacketSource(src gopacket.PacketDataSource, decoder gopacket.Decoder) *gopacket.PacketSource {
+	ps := gopacket.NewPacketSource(src, decoder)
+	ps.NoCopy = true
+	ps.Lazy = true
+
+	return ps
+}
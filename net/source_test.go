@@ -0,0 +1,33 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+	"github.com/sparta142/goblade/synth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPacketReader(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	_, err := synth.Generate(&buf, synth.DefaultConfig())
+	require.NoError(t, err)
+	assert.True(t, IsCapture(bufio.NewReader(bytes.NewReader(buf.Bytes()))))
+
+	reader, err := NewPacketReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, layers.LinkTypeEthernet, reader.LinkType())
+
+	_, _, err = reader.ReadPacketData()
+	require.NoError(t, err)
+
+	_, err = NewPacketReader(strings.NewReader(`{"epoch":1234}`))
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
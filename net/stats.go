@@ -0,0 +1,344 @@
+package net
+
+import (
+	"net/netip"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/google/gopacket/reassembly"
+)
+
+// Stats is a snapshot of a capture's counters, for telling whether it is
+// keeping up with the traffic.
+type Stats struct {
+	// The time that the snapshot was taken.
+	Time time.Time `json:"time"`
+
+	// Whether this is the last snapshot, taken when the capture ended.
+	Final bool `json:"final,omitempty"`
+
+	// The packets that passed the capture filter, and their captured bytes.
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+
+	// The packet counts reported by pcap, or nil if it can't report them,
+	// such as when reading from a file.
+	Pcap *PcapStats `json:"pcap,omitempty"`
+
+	// The TCP connections that are currently open, and that have been seen in total.
+	ActiveStreams int    `json:"activeStreams"`
+	TotalStreams  uint64 `json:"totalStreams"`
+
+	// How much data the reassembler had to buffer because it arrived out of order.
+	Reassembly ReassemblyStats `json:"reassembly"`
+
+	// The counts of every flow, both open and closed.
+	Totals FlowCounts `json:"totals"`
+
+	// The counts of each flow that is currently open.
+	Flows []FlowStats `json:"flows"`
+
+	// The queues in front of each output.
+	Queues []QueueStats `json:"queues"`
+
+	// The options that the capture is running with, or nil if it hasn't started.
+	Options *Options `json:"options,omitempty"`
+}
+
+// PcapStats are the packet counts reported by pcap.
+type PcapStats struct {
+	// The packets received by the filter.
+	Received int `json:"received"`
+
+	// The packets dropped because there was no room in the buffer,
+	// which means goblade wasn't reading them fast enough.
+	Dropped int `json:"dropped"`
+
+	// The packets dropped by the network interface or its driver.
+	IfDropped int `json:"ifDropped"`
+}
+
+// ReassemblyStats are the reassembler's buffering of out-of-order and
+// retransmitted TCP segments, summed over every connection.
+type ReassemblyStats struct {
+	// The packets and bytes that were buffered until the data before them arrived.
+	QueuedPackets uint64 `json:"queuedPackets"`
+	QueuedBytes   uint64 `json:"queuedBytes"`
+
+	// The packets and bytes that overlapped data that had already been seen.
+	OverlapPackets uint64 `json:"overlapPackets"`
+	OverlapBytes   uint64 `json:"overlapBytes"`
+}
+
+// FlowCounts are the counts of one direction of a TCP connection,
+// or the sum of several.
+type FlowCounts struct {
+	// The bytes of reassembled data.
+	Bytes uint64 `json:"bytes"`
+
+	// The bytes that were never captured, and so are missing from the data.
+	LostBytes uint64 `json:"lostBytes"`
+
+	// The bundles that were decoded.
+	Bundles uint64 `json:"bundles"`
+
+	// The bytes skipped while looking for the next bundle after lost
+	// or corrupt data.
+	DiscardedBytes uint64 `json:"discardedBytes"`
+
+	// The bundles that were discarded because they couldn't be decoded.
+	DecodeErrors uint64 `json:"decodeErrors"`
+}
+
+// Adds other to the counts.
+func (c *FlowCounts) add(other FlowCounts) {
+	c.Bytes += other.Bytes
+	c.LostBytes += other.LostBytes
+	c.Bundles += other.Bundles
+	c.DiscardedBytes += other.DiscardedBytes
+	c.DecodeErrors += other.DecodeErrors
+}
+
+// FlowStats are the counts of one direction of a TCP connection.
+type FlowStats struct {
+	// The endpoints of the flow.
+	Src netip.AddrPort `json:"src"`
+	Dst netip.AddrPort `json:"dst"`
+
+	FlowCounts
+}
+
+// The counts of one flow, which are updated by both the reassembler and
+// the flow's own goroutine.
+type flowCounters struct {
+	bytes, lostBytes, bundles, discardedBytes, decodeErrors atomic.Uint64
+}
+
+func (c *flowCounters) load() FlowCounts {
+	return FlowCounts{
+		Bytes:          c.bytes.Load(),
+		LostBytes:      c.lostBytes.Load(),
+		Bundles:        c.bundles.Load(),
+		DiscardedBytes: c.discardedBytes.Load(),
+		DecodeErrors:   c.decodeErrors.Load(),
+	}
+}
+
+// Counters keep track of a capture's statistics. They are safe for
+// concurrent use, so Stats can be called while the capture is running.
+// The zero value is ready to use.
+type Counters struct {
+	packets, bytes atomic.Uint64
+
+	queuedPackets, queuedBytes   atomic.Uint64
+	overlapPackets, overlapBytes atomic.Uint64
+
+	mu            sync.Mutex
+	options       *Options    // Set once the capture starts
+	handle        pcapStatser // Set while the capture is running
+	queues        *outputQueues
+	pcap          *PcapStats // The last counts read from the handle
+	activeStreams int
+	totalStreams  uint64
+	flows         map[*tcpFlow]struct{}
+	closed        FlowCounts // The sum of the counts of every closed flow
+
+	// Counts for metrics, which are only exported by WritePrometheus
+	metrics metricCounters
+}
+
+// Gets a snapshot of the counters.
+func (c *Counters) Stats() Stats {
+	stats := Stats{
+		Time:    time.Now(),
+		Packets: c.packets.Load(),
+		Bytes:   c.bytes.Load(),
+		Reassembly: ReassemblyStats{
+			QueuedPackets:  c.queuedPackets.Load(),
+			QueuedBytes:    c.queuedBytes.Load(),
+			OverlapPackets: c.overlapPackets.Load(),
+			OverlapBytes:   c.overlapBytes.Load(),
+		},
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readPcap()
+
+	if c.pcap != nil {
+		pcapStats := *c.pcap
+		stats.Pcap = &pcapStats
+	}
+
+	if c.options != nil {
+		options := *c.options
+		stats.Options = &options
+	}
+
+	if c.queues != nil {
+		stats.Queues = c.queues.queueStats()
+	}
+
+	stats.ActiveStreams = c.activeStreams
+	stats.TotalStreams = c.totalStreams
+	stats.Totals = c.closed
+	stats.Flows = make([]FlowStats, 0, len(c.flows))
+
+	for flow := range c.flows {
+		counts := flow.counters.load()
+		stats.Totals.add(counts)
+		stats.Flows = append(stats.Flows, FlowStats{Src: flow.Src, Dst: flow.Dst, FlowCounts: counts})
+	}
+
+	sort.Slice(stats.Flows, func(i, j int) bool {
+		a, b := stats.Flows[i], stats.Flows[j]
+		if a.Src != b.Src {
+			return addrPortLess(a.Src, b.Src)
+		}
+
+		return addrPortLess(a.Dst, b.Dst)
+	})
+
+	return stats
+}
+
+// Reports whether a sorts before b.
+func addrPortLess(a, b netip.AddrPort) bool {
+	if a.Addr() != b.Addr() {
+		return a.Addr().Less(b.Addr())
+	}
+
+	return a.Port() < b.Port()
+}
+
+// Reads the packet counts from the handle, if there is one. c.mu must be held.
+func (c *Counters) readPcap() {
+	if c.handle == nil {
+		return
+	}
+
+	ps, err := c.handle.Stats()
+	if err != nil {
+		return
+	}
+
+	c.pcap = &PcapStats{
+		Received:  ps.PacketsReceived,
+		Dropped:   ps.PacketsDropped,
+		IfDropped: ps.PacketsIfDropped,
+	}
+}
+
+// Records the options that the capture is running with.
+func (c *Counters) setOptions(opts Options) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.options = &opts
+}
+
+// Sets the queues to report the stats of.
+func (c *Counters) setQueues(queues *outputQueues) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.queues = queues
+}
+
+// Starts or stops reading packet counts from a handle. When stopping,
+// the last counts are kept.
+func (c *Counters) setHandle(handle pcapStatser) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if handle == nil {
+		c.readPcap()
+	}
+
+	c.handle = handle
+}
+
+// Counts a packet that passed the capture filter.
+func (c *Counters) addPacket(length int) {
+	c.packets.Add(1)
+	c.bytes.Add(uint64(length))
+}
+
+// Counts the reassembler's buffering for some reassembled data.
+func (c *Counters) addReassembly(stats reassembly.TCPAssemblyStats) {
+	c.queuedPackets.Add(uint64(stats.QueuedPackets))
+	c.queuedBytes.Add(uint64(stats.QueuedBytes))
+	c.overlapPackets.Add(uint64(stats.OverlapPackets))
+	c.overlapBytes.Add(uint64(stats.OverlapBytes))
+}
+
+// Counts a new connection and its flows.
+func (c *Counters) openStream(flows ...*tcpFlow) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.flows == nil {
+		c.flows = make(map[*tcpFlow]struct{})
+	}
+
+	c.activeStreams++
+	c.totalStreams++
+
+	for _, flow := range flows {
+		c.flows[flow] = struct{}{}
+	}
+}
+
+// Counts the end of a connection.
+func (c *Counters) closeStream() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.activeStreams--
+}
+
+// Moves a flow's counts into the totals once it has finished decoding.
+func (c *Counters) closeFlow(flow *tcpFlow) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.flows[flow]; !ok {
+		return
+	}
+
+	delete(c.flows, flow)
+	c.closed.add(flow.counters.load())
+}
+
+// Logs a summary of the stats.
+func logStats(stats *Stats, msg string) {
+	fields := log.Fields{
+		"packets":        stats.Packets,
+		"bytes":          stats.Bytes,
+		"activeStreams":  stats.ActiveStreams,
+		"totalStreams":   stats.TotalStreams,
+		"bundles":        stats.Totals.Bundles,
+		"lostBytes":      stats.Totals.LostBytes,
+		"discardedBytes": stats.Totals.DiscardedBytes,
+		"decodeErrors":   stats.Totals.DecodeErrors,
+	}
+
+	var dropped uint64
+	for _, q := range stats.Queues {
+		dropped += q.Dropped
+	}
+
+	fields["outputDropped"] = dropped
+
+	if stats.Pcap != nil {
+		fields["pcapDropped"] = stats.Pcap.Dropped
+		fields["pcapIfDropped"] = stats.Pcap.IfDropped
+	}
+
+	log.WithFields(fields).Info(msg)
+}
@@ -0,0 +1,65 @@
+package net
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/google/gopacket/reassembly"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounters(t *testing.T) {
+	t.Parallel()
+
+	client := netip.MustParseAddrPort("192.168.1.2:50000")
+	server := netip.MustParseAddrPort("204.2.29.8:55006")
+
+	toClient := &tcpFlow{Src: server, Dst: client}
+	toServer := &tcpFlow{Src: client, Dst: server}
+
+	var counters Counters
+	counters.addPacket(100)
+	counters.addPacket(60)
+	counters.addReassembly(reassembly.TCPAssemblyStats{QueuedPackets: 1, QueuedBytes: 40})
+	counters.openStream(toClient, toServer)
+
+	toClient.counters.bytes.Add(500)
+	toClient.counters.bundles.Add(2)
+	toServer.counters.lostBytes.Add(10)
+	toServer.counters.discardedBytes.Add(7)
+	toServer.counters.decodeErrors.Add(1)
+
+	stats := counters.Stats()
+	assert.Equal(t, uint64(2), stats.Packets)
+	assert.Equal(t, uint64(160), stats.Bytes)
+	assert.Nil(t, stats.Pcap)
+	assert.Equal(t, 1, stats.ActiveStreams)
+	assert.Equal(t, uint64(1), stats.TotalStreams)
+	assert.Equal(t, ReassemblyStats{QueuedPackets: 1, QueuedBytes: 40}, stats.Reassembly)
+	assert.Equal(t, FlowCounts{
+		Bytes:          500,
+		LostBytes:      10,
+		Bundles:        2,
+		DiscardedBytes: 7,
+		DecodeErrors:   1,
+	}, stats.Totals)
+
+	// Flows are sorted by their endpoints
+	if assert.Len(t, stats.Flows, 2) {
+		assert.Equal(t, client, stats.Flows[0].Src)
+		assert.Equal(t, server, stats.Flows[1].Src)
+		assert.Equal(t, uint64(500), stats.Flows[1].Bytes)
+	}
+
+	// Closed flows still count towards the totals
+	counters.closeStream()
+	counters.closeFlow(toClient)
+	counters.closeFlow(toServer)
+	counters.closeFlow(toServer)
+
+	closed := counters.Stats()
+	assert.Equal(t, 0, closed.ActiveStreams)
+	assert.Equal(t, uint64(1), closed.TotalStreams)
+	assert.Empty(t, closed.Flows)
+	assert.Equal(t, stats.Totals, closed.Totals)
+}
@@ -1,12 +1,13 @@
 package net
 
 import (
-	"bufio"
-	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/netip"
 	"sync"
-	"sync/atomic"
+	"time"
 
 	"github.com/djherbis/buffer"
 	"github.com/djherbis/nio/v3"
@@ -16,36 +17,94 @@ import (
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/reassembly"
 	"github.com/sparta142/goblade/ffxiv"
+	"github.com/sparta142/goblade/oodle"
 )
 
 // The number of bytes in one kibibyte (1 KiB).
 const kibibytes = 1024
 
 type tcpStream struct {
+	id                 uint64 // Unique within the capture
 	fsm                reassembly.TCPSimpleFSM
+	client, server     netip.AddrPort
 	toClient, toServer *tcpFlow
+	reversed           bool // Whether the reassembler's client is the server
+
+	// Lobby encryption state, shared by both flows, which are decoded
+	// in the order they were reassembled so that it starts in time
+	session ffxiv.LobbySession
+	order   *decodeOrder
+
+	// Keep-alive exchanges, shared by both flows
+	latency *latencyTracker
+
+	// When the connection was seen, and how many of its flows are still decoding
+	times connectionTimes
+
+	// The capture's outputs and counters
+	queues   *outputQueues
+	counters *Counters
 }
 
 type tcpFlow struct {
-	// Whether the reassembler missed a TCP segment
-	lostData atomic.Bool
+	reader  *nio.PipeReader
+	writer  *nio.PipeWriter
+	decoder *ffxiv.Decoder
+	oodle   oodle.Decoder // Released by Run, once the pipe is drained
+	written int64         // The number of bytes written to the pipe so far
+	closed  bool          // Whether writing to the pipe failed
 
-	reader *nio.PipeReader
-	writer *nio.PipeWriter
+	// Gaps in the data that the decoder hasn't reached yet, in order
+	gapsMu sync.Mutex
+	gaps   []*DataGap
 
-	bundles chan<- ffxiv.Bundle
+	counters   flowCounters
+	fromServer bool // Whether the flow is from the server to the client
+
+	stream   *tcpStream
+	queues   *outputQueues
+	resolver *ffxiv.Resolver
+	opcodes  *ffxiv.OpcodeStore
 
 	Src, Dst netip.AddrPort
 }
 
-func newTCPFlow(src, dst netip.AddrPort, bundles chan<- ffxiv.Bundle) *tcpFlow {
+func newTCPFlow(
+	src, dst netip.AddrPort,
+	fromServer bool,
+	stream *tcpStream,
+	queues *outputQueues,
+	opts Options,
+) *tcpFlow {
 	flow := &tcpFlow{
-		bundles: bundles,
-		Src:     src,
-		Dst:     dst,
+		fromServer: fromServer,
+		stream:     stream,
+		queues:     queues,
+		resolver:   ffxiv.DefaultResolver(),
+		opcodes:    opts.Opcodes,
+		Src:        src,
+		Dst:        dst,
+	}
+	flow.reader, flow.writer = nio.Pipe(buffer.New(int64(opts.PipeBufferSize)))
+
+	var input io.Reader = &clientReader{r: flow.reader, order: stream.order}
+	if fromServer {
+		input = &serverReader{r: flow.reader, order: stream.order}
+	}
+
+	flow.decoder = ffxiv.NewDecoder(input)
+	flow.decoder.SetSession(&stream.session)
+	flow.decoder.SetDeferOodle(opts.DeferOodle)
+	flow.decoder.SetMaxBundleSize(opts.MaxBundleSize)
+	flow.decoder.SetDecompressObserver(stream.counters.observeDecompress)
+
+	// Give each flow its own Oodle state, so that flows don't wait on each other
+	if od, err := oodle.NewDecoder(); err != nil {
+		log.WithError(err).Warnf("Failed to create Oodle decoder for %s", flow)
+	} else {
+		flow.oodle = od
+		flow.decoder.SetOodle(od)
 	}
-	flow.lostData.Store(false)
-	flow.reader, flow.writer = nio.Pipe(buffer.New(2 * kibibytes))
 
 	log.Debugf("Created TCP flow for %s", flow)
 
@@ -54,7 +113,7 @@ func newTCPFlow(src, dst netip.AddrPort, bundles chan<- ffxiv.Bundle) *tcpFlow {
 
 func (stream *tcpStream) Accept(
 	tcp *layers.TCP,
-	_ gopacket.CaptureInfo,
+	ci gopacket.CaptureInfo,
 	dir reassembly.TCPFlowDirection,
 	_ reassembly.Sequence,
 	start *bool,
@@ -66,31 +125,66 @@ func (stream *tcpStream) Accept(
 	}
 
 	*start = true
+	stream.times.seen(ci.Timestamp)
 
 	return true
 }
 
-func (stream *tcpStream) ReassembledSG(sg reassembly.ScatterGather, _ reassembly.AssemblerContext) {
+func (stream *tcpStream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.AssemblerContext) {
 	available, _ := sg.Lengths()
 	if available == 0 {
 		return
 	}
 
 	direction, _, _, skip := sg.Info()
-	flow := stream.getFlow(direction)
+	stream.counters.addReassembly(sg.Stats())
 
-	if skip > 0 {
-		flow.lostData.Store(true)
-		log.Warnf("Lost %d bytes in stream", skip)
+	// Flushed data has no context
+	var captured time.Time
+	if ac != nil {
+		captured = ac.GetCaptureInfo().Timestamp
+	}
+
+	stream.reassembled(stream.getFlow(direction), sg.Fetch(available), skip, captured)
+}
 
+// Passes reassembled data, which skipped the bytes before it, to one of the stream's flows.
+func (stream *tcpStream) reassembled(flow *tcpFlow, data []byte, skip int, captured time.Time) {
+	if flow.closed {
 		return
 	}
 
+	if skip > 0 {
+		flow.counters.lostBytes.Add(uint64(skip))
+		flow.decoder.MarkDataLost(flow.written)
+		flow.addGap(skip, captured)
+	}
+
+	if !captured.IsZero() {
+		flow.decoder.SetCaptureTime(flow.written, captured)
+	}
+
 	// Queue the packets to the Bundle reading logic
-	p := sg.Fetch(available)
+	stream.write(flow, data)
+}
+
+// Writes reassembled data to one of the stream's flows.
+func (stream *tcpStream) write(flow *tcpFlow, p []byte) {
+	// Don't decode the server's data before the client's that came first
+	if flow.fromServer {
+		stream.order.mark(flow.written, stream.toServer.written)
+	}
+
 	if _, err := flow.writer.Write(p); err != nil {
-		log.WithError(err).Fatal("Failed to write data to TCP flow")
+		// Stop feeding the flow, since its reader is gone
+		flow.closed = true
+		flow.report("write stream", true, err)
+
+		return
 	}
+
+	flow.written += int64(len(p))
+	flow.counters.bytes.Add(uint64(len(p)))
 }
 
 func (stream *tcpStream) ReassemblyComplete(_ reassembly.AssemblerContext) bool {
@@ -98,11 +192,16 @@ func (stream *tcpStream) ReassemblyComplete(_ reassembly.AssemblerContext) bool
 
 	stream.toClient.writer.Close()
 	stream.toServer.writer.Close()
+	stream.counters.closeStream()
 
 	return true
 }
 
 func (stream *tcpStream) getFlow(direction reassembly.TCPFlowDirection) *tcpFlow {
+	if stream.reversed {
+		direction = direction.Reverse()
+	}
+
 	switch direction {
 	case reassembly.TCPDirServerToClient:
 		return stream.toClient
@@ -122,80 +221,143 @@ func (flow *tcpFlow) Run(wg *sync.WaitGroup) {
 	defer wg.Done()
 	log.Debugf("Starting TCP flow processing for %s", flow)
 
-	scanner := bufio.NewScanner(flow.reader)
-	scanner.Split(flow.splitBundles)
 	defer flow.reader.Close()
+	defer flow.stream.counters.closeFlow(flow)
 
-	var bundle ffxiv.Bundle
+	if !flow.fromServer {
+		defer flow.stream.order.clientDone()
+	}
 
-	for scanner.Scan() {
-		if err := bundle.UnmarshalBinary(scanner.Bytes()); err != nil {
-			log.WithError(err).Fatal("Failed to read bundle")
+	// The last flow of the connection to finish reports that it closed,
+	// now that its totals are final
+	defer func() {
+		if flow.stream.times.flowDone() {
+			flow.stream.reportClosed()
 		}
+	}()
+
+	// Gaps the decoder never reached come before the connection closes
+	defer flow.reportGaps(math.MaxInt64)
 
-		flow.bundles <- bundle
+	// ReassemblyComplete closes the pipe, so the Oodle state is released
+	// here once the decoder has drained it
+	if flow.oodle != nil {
+		defer flow.oodle.Close()
 	}
 
-	if err := scanner.Err(); err != nil {
-		log.WithError(err).Fatal("Failed while scanning for bundle")
+	for {
+		bundle, err := flow.decoder.Next()
+
+		var (
+			resync    *ffxiv.ResyncError
+			decodeErr *ffxiv.DecodeError
+		)
+
+		switch {
+		case err == nil:
+			flow.counters.bundles.Add(1)
+			flow.stream.counters.observeBundle(&bundle, flow.fromServer)
+			flow.reportGaps(flow.decoder.Offset())
+			flow.resolver.ResolveBundle(&bundle)
+			flow.nameIpcs(&bundle)
+			flow.reportBundle(bundle)
+
+			for _, latency := range flow.stream.latency.observe(&bundle, flow.decoder.CaptureTime()) {
+				flow.reportLatency(latency)
+			}
+
+		case errors.Is(err, io.EOF):
+			return
+
+		case errors.As(err, &resync):
+			flow.counters.discardedBytes.Add(uint64(resync.Skipped))
+			flow.stream.counters.observeError(errorKindResync)
+			log.Warnf("Discarded %d bytes in %s: %v", resync.Skipped, flow, resync)
+
+		case errors.As(err, &decodeErr):
+			flow.counters.decodeErrors.Add(1)
+			flow.stream.counters.observeError(decodeErrorKind(err))
+			flow.reportGaps(decodeErr.Offset)
+			flow.report("decode bundle", false, err)
+
+		default:
+			flow.stream.counters.observeError(errorKindStream)
+			flow.report("read stream", true, err)
+			return
+		}
 	}
 }
 
-func (flow *tcpFlow) splitBundles(data []byte, _ bool) (advance int, token []byte, err error) {
-	// There are 3 failure modes to be aware of when considering lost bytes:
-	//     1) The magic header was (partially) lost, so its delimited bundle will
-	//        not be found by the Scanner and *not* cause any issues.
-	//	   2) Part of the payload (i.e., not the magic header) was lost, so the scanner
-	//		  will misinterpret the remaining data as part of the original bundle.
-	//		  This can cause major issues due to "unaligned" bundle decoding and probably
-	//        an error of some sort after a sequence of completely invalid bundles.
-	//	   3) The entire bundle as a whole was sent as one TCP segment, and was completely lost.
-	//		  This will also *not* cause any issues with misinterpreting the data stream.
-	if flow.lostData.Swap(false) {
-		log.Warnf("Discarding all %d bytes in scanner as a safety measure", len(data))
-		return len(data), nil, nil
-	}
-
-	var idx int
-
-	// Find the magic bytes in `data`.
-	// We explicitly check index 0 first since the magic bytes will always
-	// be there unless something went wrong. When we're correct,
-	// this function will run about 11x faster (according to pprof).
-	if bytes.HasPrefix(data, ffxiv.IpcMagicBytes) || bytes.HasPrefix(data, ffxiv.KeepAliveMagicBytes) {
-		idx = 0
-	} else {
-		idx = indexFirst(data, ffxiv.IpcMagicBytes, ffxiv.KeepAliveMagicBytes)
-		if idx == -1 {
-			return 0, nil, nil
-		}
+// Names the IPCs in a Bundle with the current opcode table, if there is one.
+func (flow *tcpFlow) nameIpcs(bundle *ffxiv.Bundle) {
+	if flow.opcodes == nil {
+		return
+	}
+
+	if table := flow.opcodes.Load(); table != nil {
+		table.ResolveBundle(bundle, flow.fromServer)
+	}
+}
+
+// Reports a decoded Bundle to the bundle queues.
+func (flow *tcpFlow) reportBundle(bundle ffxiv.Bundle) {
+	flowBundle := &FlowBundle{
+		ConnectionID: flow.stream.id,
+		Src:          flow.Src,
+		Dst:          flow.Dst,
+		FromServer:   flow.fromServer,
+		Bundle:       bundle,
+	}
+
+	if flow.queues.sendResult(flowBundle, false) {
+		return
 	}
 
-	// The chunk of `data` that starts with the magic bytes (found above)
-	chunk := data[idx:]
+	if flow.queues.flowBundles != nil {
+		flow.queues.flowBundles.push(flowBundle)
+	}
+
+	if flow.queues.bundles != nil {
+		flow.queues.bundles.push(bundle)
+	}
+}
+
+// Reports an error in the flow to the error queue, or the log if there is none.
+func (flow *tcpFlow) report(op string, closed bool, err error) {
+	flowErr := &FlowError{
+		ConnectionID: flow.stream.id,
+		Src:          flow.Src,
+		Dst:          flow.Dst,
+		Op:           op,
+		Closed:       closed,
+		Err:          err,
+	}
+
+	// Errors that close the flow frame the rest of its results
+	if flow.queues.sendResult(flowErr, closed) {
+		return
+	}
 
-	length := ffxiv.PeekBundleLength(chunk) // The (probable) length of the Bundle
-	if length > len(chunk) || length < 0 {
-		return idx, nil, nil
+	if flow.queues.errors == nil {
+		log.WithError(flowErr).Error("Error in TCP flow")
+		return
 	}
 
-	return idx + length, chunk[:length], nil
+	flow.queues.errors.push(flowErr)
 }
 
-// Get the index of the earliest instance of any slice in seps,
-// or -1 if no slice in seps is present in s.
-func indexFirst(s []byte, seps ...[]byte) int {
-	idx := -1
-	for _, sep := range seps {
-		index := bytes.Index(s, sep)
+// Reports a latency measurement to the latency queue, or the log if there is none.
+func (flow *tcpFlow) reportLatency(latency *Latency) {
+	if flow.queues.sendResult(latency, false) {
+		return
+	}
 
-		// Did we match and is it earlier than what we already matched, if any?
-		if index != -1 && (idx == -1 || index < idx) {
-			idx = index
-		}
+	if flow.queues.latency == nil {
+		log.Debugf("Round-trip time to %s is %s", latency.Server, latency.RTT)
+		return
 	}
 
-	return idx
+	flow.queues.latency.push(latency)
 }
 
 var _ reassembly.Stream = (*tcpStream)(nil)
@@ -11,10 +11,7 @@ import (
 	"golang.org/x/sys/windows"
 )
 
-var (
-	ErrExeNotFound     = errors.New("oodle: game executable not found")
-	ErrProcessNotFound = errors.New("oodle: game process not found")
-)
+var ErrProcessNotFound = errors.New("oodle: game process not found")
 
 // The game executable filename (and process name).
 const exeName = "ffxiv_dx11.exe"
@@ -25,8 +22,24 @@ var exePaths = [...]string{
 	"${ProgramFiles(x86)}\\Steam\\steamapps\\common\\FINAL FANTASY XIV Online\\game\\" + exeName, // Steam
 }
 
-// Gets the absolute path of the game's executable.
-func findGameExe() (string, error) {
+// Gets the absolute path of the game's executable,
+// which is the configured one if there is one.
+func findGameExe(cfg Config) (string, error) {
+	if cfg.GameExe != "" {
+		info, err := os.Stat(cfg.GameExe)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrExeNotFound, err) //nolint:errorlint
+		}
+
+		if info.IsDir() {
+			return "", fmt.Errorf("%w: %s is a directory", ErrExeNotFound, cfg.GameExe)
+		}
+
+		log.Infof("Using configured game executable: %s", cfg.GameExe)
+
+		return cfg.GameExe, nil
+	}
+
 	if filename, err := getProcess(exeName); err == nil {
 		log.Infof("Game is currently running from %s", filename)
 		return filename, nil
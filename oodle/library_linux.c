@@ -0,0 +1,163 @@
+#include <dlfcn.h>  // dlclose, dlerror, dlopen, dlsym
+#include <stdio.h>  // snprintf
+#include <stdlib.h> // aligned_alloc, calloc, free
+#include <string.h> // memcpy, memset
+
+#include "library_linux.h"
+
+#define HASHTABLE_BITS 19
+#define WINDOW_SIZE 0x16000
+
+// The alignment that Oodle expects of its buffers, the same as __m128.
+#define OODLE_ALIGNMENT 16
+
+struct library
+{
+    void *handle;
+
+    int64_t (*OodleNetwork1UDP_State_Size)(void);
+    int64_t (*OodleNetwork1_Shared_Size)(int32_t htbits);
+    void (*OodleNetwork1_Shared_SetWindow)(void *data, int32_t htbits, const void *window, int32_t window_size);
+    void (*OodleNetwork1UDP_Train)(
+        void *state,
+        const void *shared,
+        const void **training_packet_pointers,
+        const int32_t *training_packet_sizes,
+        int32_t num_training_packets);
+    int32_t (*OodleNetwork1UDP_Decode)(
+        const void *state,
+        const void *shared,
+        const void *comp,
+        int64_t compLen,
+        void *raw,
+        int64_t rawLen);
+};
+
+struct decoder
+{
+    const library *lib;
+
+    void *window;
+    void *state;
+    void *shared;
+};
+
+/**
+ * @brief Allocates a zero-initialized, aligned buffer of at least the specified size.
+ *
+ * @param size The number of bytes to allocate.
+ * @return `void*` The pointer to the beginning of newly allocated memory, or NULL on failure.
+ */
+static void *aligned_calloc(size_t size)
+{
+    // aligned_alloc requires a multiple of the alignment
+    size = (size + OODLE_ALIGNMENT - 1) / OODLE_ALIGNMENT * OODLE_ALIGNMENT;
+
+    void *const p = aligned_alloc(OODLE_ALIGNMENT, size);
+    return p ? memset(p, 0, size) : NULL;
+}
+
+/**
+ * @brief Looks up a symbol in the library, writing an error message if it is missing.
+ */
+static void *lookup(library *lib, const char *name, char *err, int64_t errLen)
+{
+    void *const sym = dlsym(lib->handle, name);
+    if (!sym)
+        snprintf(err, (size_t)errLen, "missing symbol %s", name);
+
+    return sym;
+}
+
+library *library_open(const char *filename, char *err, int64_t errLen)
+{
+    library *const lib = calloc(1, sizeof(library));
+    if (!lib)
+    {
+        snprintf(err, (size_t)errLen, "out of memory");
+        return NULL;
+    }
+
+    lib->handle = dlopen(filename, RTLD_NOW | RTLD_LOCAL);
+    if (!lib->handle)
+    {
+        snprintf(err, (size_t)errLen, "%s", dlerror());
+        free(lib);
+        return NULL;
+    }
+
+    if (!(lib->OodleNetwork1UDP_State_Size = lookup(lib, "OodleNetwork1UDP_State_Size", err, errLen)) ||
+        !(lib->OodleNetwork1_Shared_Size = lookup(lib, "OodleNetwork1_Shared_Size", err, errLen)) ||
+        !(lib->OodleNetwork1_Shared_SetWindow = lookup(lib, "OodleNetwork1_Shared_SetWindow", err, errLen)) ||
+        !(lib->OodleNetwork1UDP_Train = lookup(lib, "OodleNetwork1UDP_Train", err, errLen)) ||
+        !(lib->OodleNetwork1UDP_Decode = lookup(lib, "OodleNetwork1UDP_Decode", err, errLen)))
+    {
+        library_close(lib);
+        return NULL;
+    }
+
+    return lib;
+}
+
+void library_close(library *lib)
+{
+    if (!lib)
+        return;
+
+    dlclose(lib->handle);
+    free(lib);
+}
+
+decoder *decoder_new(const library *lib)
+{
+    decoder *const dec = calloc(1, sizeof(decoder));
+    if (!dec)
+        return NULL;
+
+    dec->lib = lib;
+
+    // These *must* be zero-initialized and aligned,
+    // otherwise it will mysteriously crash.
+    dec->window = aligned_calloc(WINDOW_SIZE);
+    dec->state = aligned_calloc((size_t)lib->OodleNetwork1UDP_State_Size());
+    dec->shared = aligned_calloc((size_t)lib->OodleNetwork1_Shared_Size(HASHTABLE_BITS));
+
+    if (!dec->window || !dec->state || !dec->shared)
+    {
+        decoder_free(dec);
+        return NULL;
+    }
+
+    lib->OodleNetwork1_Shared_SetWindow(dec->shared, HASHTABLE_BITS, dec->window, WINDOW_SIZE);
+    lib->OodleNetwork1UDP_Train(dec->state, dec->shared, NULL, NULL, 0);
+
+    return dec;
+}
+
+void decoder_free(decoder *dec)
+{
+    if (!dec)
+        return;
+
+    free(dec->shared);
+    free(dec->state);
+    free(dec->window);
+    free(dec);
+}
+
+bool decoder_decode(const decoder *dec, const void *comp, int64_t compLen, void *raw, int64_t rawLen)
+{
+    // Copy the compressed data into aligned storage, which is on the heap
+    // since bundles can be larger than is reasonable for the stack
+    void *const compAligned = aligned_calloc((size_t)compLen);
+    if (!compAligned)
+        return false;
+
+    memcpy(compAligned, comp, (size_t)compLen);
+
+    const bool success = dec->lib->OodleNetwork1UDP_Decode(dec->state, dec->shared, compAligned, compLen, raw, rawLen);
+
+    free(compAligned);
+
+    return success;
+}
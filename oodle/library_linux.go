@@ -0,0 +1,144 @@
+//go:build cgo
+
+package oodle
+
+/*
+#cgo linux LDFLAGS: -ldl
+#cgo linux CFLAGS: --std=c17 -Wall -Wextra
+
+#include <stdlib.h>
+#include "library_linux.h"
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+var (
+	ErrLibraryClosed = errors.New("oodle: library closed")
+	ErrOutOfMemory   = errors.New("oodle: out of memory")
+)
+
+// The size of the buffer for error messages from native code.
+const errorMessageSize = 256
+
+// Library is a Backend that decompresses with a shared library exporting
+// the OodleNetwork1 API, such as one from the Oodle SDK.
+type Library struct {
+	filename string
+	mu       sync.Mutex
+	lib      *C.library
+	decoders int  // The number of open decoders
+	closed   bool // Whether Close has been called
+}
+
+// Loads a shared library that exports the OodleNetwork1 API.
+// The Library should be closed when it's no longer needed.
+func OpenLibrary(filename string) (*Library, error) {
+	cfilename := C.CString(filename)
+	defer C.free(unsafe.Pointer(cfilename))
+
+	var msg [errorMessageSize]C.char
+
+	lib := C.library_open(cfilename, &msg[0], C.int64_t(len(msg)))
+	if lib == nil {
+		return nil, fmt.Errorf("oodle: open library %s: %s", filename, C.GoString(&msg[0])) //nolint:goerr113
+	}
+
+	return &Library{filename: filename, lib: lib}, nil
+}
+
+// Creates a Decoder with its own state. The library stays loaded
+// until it and all of its Decoders are closed.
+func (l *Library) NewDecoder() (Decoder, error) { //nolint:ireturn
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return nil, ErrLibraryClosed
+	}
+
+	dec := C.decoder_new(l.lib)
+	if dec == nil {
+		return nil, ErrOutOfMemory
+	}
+
+	l.decoders++
+
+	return &libraryDecoder{lib: l, dec: dec}, nil
+}
+
+func (l *Library) String() string {
+	return "shared library " + l.filename
+}
+
+// Closes the library, which is unloaded once all of its Decoders are closed.
+// Creating Decoders afterwards fails with ErrLibraryClosed.
+func (l *Library) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	l.unloadIfUnused()
+
+	return nil
+}
+
+// Unloads the library if it and all of its Decoders are closed. l.mu must be held.
+func (l *Library) unloadIfUnused() {
+	if l.closed && l.decoders == 0 && l.lib != nil {
+		C.library_close(l.lib)
+		l.lib = nil
+	}
+}
+
+type libraryDecoder struct {
+	lib *Library
+	dec *C.decoder
+}
+
+func (d *libraryDecoder) Decode(comp, raw []byte) error {
+	if d.dec == nil {
+		return ErrDecoderClosed
+	}
+
+	if len(comp) == 0 || len(raw) == 0 {
+		return fmt.Errorf("%w: empty buffer", ErrDecompressionFailed)
+	}
+
+	if C.decoder_decode(
+		d.dec,
+		unsafe.Pointer(&comp[0]), C.int64_t(len(comp)),
+		unsafe.Pointer(&raw[0]), C.int64_t(len(raw)),
+	) {
+		return nil
+	}
+
+	return ErrDecompressionFailed
+}
+
+func (d *libraryDecoder) Close() error {
+	if d.dec == nil {
+		return nil
+	}
+
+	C.decoder_free(d.dec)
+	d.dec = nil
+
+	d.lib.mu.Lock()
+	defer d.lib.mu.Unlock()
+
+	d.lib.decoders--
+	d.lib.unloadIfUnused()
+
+	return nil
+}
+
+var (
+	_ Backend = (*Library)(nil)
+	_ Decoder = (*libraryDecoder)(nil)
+)
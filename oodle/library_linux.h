@@ -0,0 +1,24 @@
+#ifndef GOBLADE_OODLE_LIBRARY_LINUX_H
+#define GOBLADE_OODLE_LIBRARY_LINUX_H
+
+#include <stdbool.h> // bool
+#include <stdint.h>  // int64_t
+
+/**
+ * @brief An Oodle library loaded with dlopen.
+ */
+typedef struct library library;
+
+/**
+ * @brief The state for decoding one connection's data with a library.
+ */
+typedef struct decoder decoder;
+
+library *library_open(const char *filename, char *err, int64_t errLen);
+void library_close(library *lib);
+
+decoder *decoder_new(const library *lib);
+void decoder_free(decoder *dec);
+bool decoder_decode(const decoder *dec, const void *comp, int64_t compLen, void *raw, int64_t rawLen);
+
+#endif
@@ -0,0 +1,86 @@
+//go:build cgo
+
+package oodle_test
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/sparta142/goblade/oodle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Builds the fake Oodle library in testdata, skipping the test if there is no C compiler.
+func buildFakeLibrary(t *testing.T) string {
+	t.Helper()
+
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no C compiler to build the fake Oodle library")
+	}
+
+	lib := filepath.Join(t.TempDir(), "libfakeoodle.so")
+	out, err := exec.Command(cc, "-shared", "-fPIC", "-o", lib, filepath.Join("testdata", "fake_oodle.c")).CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	return lib
+}
+
+func TestOpenLibrary(t *testing.T) {
+	t.Parallel()
+
+	lib, err := oodle.OpenLibrary(buildFakeLibrary(t))
+	require.NoError(t, err)
+
+	first, err := lib.NewDecoder()
+	require.NoError(t, err)
+
+	second, err := lib.NewDecoder()
+	require.NoError(t, err)
+
+	raw := make([]byte, 3)
+	require.NoError(t, first.Decode([]byte{0x0f}, raw))
+	assert.Equal(t, []byte{0xf0, 0xf0, 0xf0}, raw)
+
+	assert.ErrorIs(t, first.Decode([]byte{0x00, 0x01}, raw), oodle.ErrDecompressionFailed)
+	assert.ErrorIs(t, first.Decode(nil, raw), oodle.ErrDecompressionFailed)
+
+	// Closing one decoder doesn't affect another
+	require.NoError(t, first.Close())
+	assert.ErrorIs(t, first.Decode([]byte{0x0f}, raw), oodle.ErrDecoderClosed)
+
+	// Nor does closing the library, which waits for its decoders
+	require.NoError(t, lib.Close())
+	require.NoError(t, second.Decode([]byte{0x01, 0x02, 0x03}, raw))
+	assert.Equal(t, []byte{0x01, 0x02, 0x03}, raw)
+	require.NoError(t, second.Close())
+
+	_, err = lib.NewDecoder()
+	assert.ErrorIs(t, err, oodle.ErrLibraryClosed)
+}
+
+func TestSelfTest_Library(t *testing.T) {
+	t.Parallel()
+
+	lib, err := oodle.OpenLibrary(buildFakeLibrary(t))
+	require.NoError(t, err)
+	defer lib.Close()
+
+	require.NoError(t, oodle.SelfTest(lib))
+	assert.Contains(t, lib.String(), "libfakeoodle.so")
+
+	// Samples that are really compressed are checked against what they decode to
+	require.NoError(t, oodle.SelfTest(lib, oodle.Sample{Compressed: []byte{0x0f}, Raw: []byte{0xf0, 0xf0, 0xf0}}))
+
+	err = oodle.SelfTest(lib, oodle.Sample{Compressed: []byte{0x0f}, Raw: []byte{0x0f, 0x0f, 0x0f}})
+	assert.ErrorIs(t, err, oodle.ErrSelfTestFailed)
+}
+
+func TestOpenLibrary_Missing(t *testing.T) {
+	t.Parallel()
+
+	_, err := oodle.OpenLibrary(filepath.Join(t.TempDir(), "missing.so"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,27 @@
+//go:build !linux || !cgo
+
+package oodle
+
+// Library is a Backend that decompresses with a shared library exporting
+// the OodleNetwork1 API. It is only supported on Linux.
+type Library struct{}
+
+// Loading shared libraries is only supported on Linux,
+// so OpenLibrary fails with ErrPlatformNotSupported.
+func OpenLibrary(string) (*Library, error) {
+	return nil, ErrPlatformNotSupported
+}
+
+func (*Library) NewDecoder() (Decoder, error) { //nolint:ireturn
+	return nil, ErrPlatformNotSupported
+}
+
+func (*Library) String() string {
+	return "shared library (unsupported)"
+}
+
+func (*Library) Close() error {
+	return nil
+}
+
+var _ Backend = (*Library)(nil)
@@ -1,19 +1,135 @@
-//go:build !cgo || !(windows && amd64)
-
 package oodle
 
-import "errors"
+import (
+	"errors"
+	"sync"
+)
 
-var ErrPlatformNotSupported = errors.New("oodle: platform not supported")
+var (
+	ErrPlatformNotSupported = errors.New("oodle: platform not supported")
+	ErrDecompressionFailed  = errors.New("oodle: decompression failed in native code")
+	ErrDecoderClosed        = errors.New("oodle: decoder closed")
+)
 
-func Decode(_, _ []byte) error {
-	return ErrPlatformNotSupported
+// Decompressor decodes Oodle-compressed network data.
+type Decompressor interface {
+	// Decompresses comp into raw, which must be exactly as long as the
+	// decompressed data.
+	Decode(comp, raw []byte) error
+}
+
+// Decoder is a Decompressor with its own Oodle state, such as for one
+// connection. It is not safe for concurrent use, and should be closed
+// to release its state when it's no longer needed.
+type Decoder interface {
+	Decompressor
+	Close() error
+}
+
+// Backend is a source of Oodle decompression, such as the game executable
+// or a shared library.
+type Backend interface {
+	// Creates a Decoder with newly initialized state.
+	NewDecoder() (Decoder, error)
+}
+
+// The Backend used until another is set up.
+type unsupported struct{}
+
+func (unsupported) NewDecoder() (Decoder, error) { //nolint:ireturn
+	return unsupported{}, nil
 }
 
-func Setup() error {
+func (unsupported) String() string {
+	return "none"
+}
+
+func (unsupported) Decode(_, _ []byte) error {
 	return ErrPlatformNotSupported
 }
 
-func Shutdown() {
-	// Do nothing
+func (unsupported) Close() error {
+	return nil
+}
+
+var (
+	mu      sync.Mutex
+	backend Backend = unsupported{}
+
+	// The Decoder used by Decode, created from the backend when first needed.
+	shared Decoder
+)
+
+// Selects the Backend used by NewDecoder and Decode, replacing any set up
+// by Setup. If b is nil, decoding fails with ErrPlatformNotSupported.
+func Use(b Backend) {
+	if b == nil {
+		b = unsupported{}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if shared != nil {
+		_ = shared.Close()
+		shared = nil
+	}
+
+	backend = b
+}
+
+// Gets the Backend used by NewDecoder and Decode.
+func CurrentBackend() Backend { //nolint:ireturn
+	mu.Lock()
+	defer mu.Unlock()
+
+	return backend
+}
+
+// Whether a Backend other than the unsupported default is in use.
+func inUse() bool {
+	_, ok := CurrentBackend().(unsupported)
+	return !ok
+}
+
+// Creates a Decoder from the current Backend.
+func NewDecoder() (Decoder, error) { //nolint:ireturn
+	return CurrentBackend().NewDecoder() //nolint:wrapcheck
+}
+
+// Decompresses comp into raw with a Decoder shared by every caller,
+// which is created from the current Backend when first needed.
+// Callers that decode independent connections should each use their own
+// Decoder from NewDecoder instead, so that they don't wait on each other.
+func Decode(comp, raw []byte) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if shared == nil {
+		d, err := backend.NewDecoder()
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		shared = d
+	}
+
+	return shared.Decode(comp, raw) //nolint:wrapcheck
+}
+
+// Gets a Decompressor that decodes with Decode.
+func Current() Decompressor { //nolint:ireturn
+	return sharedDecompressor{}
 }
+
+type sharedDecompressor struct{}
+
+func (sharedDecompressor) Decode(comp, raw []byte) error {
+	return Decode(comp, raw)
+}
+
+var (
+	_ Backend      = unsupported{}
+	_ Decoder      = unsupported{}
+	_ Decompressor = sharedDecompressor{}
+)
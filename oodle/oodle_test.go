@@ -0,0 +1,111 @@
+package oodle_test
+
+import (
+	"testing"
+
+	"github.com/sparta142/goblade/oodle"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A Backend whose decoders copy their input, and count how many are open.
+type fakeBackend struct {
+	open int
+}
+
+func (b *fakeBackend) NewDecoder() (oodle.Decoder, error) { //nolint:ireturn
+	b.open++
+	return &fakeDecoder{backend: b}, nil
+}
+
+type fakeDecoder struct {
+	backend *fakeBackend
+}
+
+func (d *fakeDecoder) Decode(comp, raw []byte) error {
+	copy(raw, comp)
+	return nil
+}
+
+func (d *fakeDecoder) Close() error {
+	d.backend.open--
+	return nil
+}
+
+//nolint:paralleltest // Changes the current backend
+func TestUse(t *testing.T) {
+	raw := make([]byte, 2)
+	assert.ErrorIs(t, oodle.Decode([]byte{1, 2}, raw), oodle.ErrPlatformNotSupported)
+
+	backend := &fakeBackend{}
+	oodle.Use(backend)
+
+	// Decode shares one decoder between every call
+	require.NoError(t, oodle.Decode([]byte{1, 2}, raw))
+	require.NoError(t, oodle.Current().Decode([]byte{3, 4}, raw))
+	assert.Equal(t, []byte{3, 4}, raw)
+	assert.Equal(t, 1, backend.open)
+
+	// Whereas each NewDecoder has its own
+	dec, err := oodle.NewDecoder()
+	require.NoError(t, err)
+	assert.Equal(t, 2, backend.open)
+	require.NoError(t, dec.Close())
+
+	// Replacing the backend closes the shared decoder
+	oodle.Use(nil)
+	assert.Equal(t, 0, backend.open)
+	assert.ErrorIs(t, oodle.Decode([]byte{1, 2}, raw), oodle.ErrPlatformNotSupported)
+}
+
+// A Backend whose decoders corrupt their output.
+type corruptBackend struct{}
+
+func (corruptBackend) NewDecoder() (oodle.Decoder, error) { //nolint:ireturn
+	return corruptDecoder{}, nil
+}
+
+type corruptDecoder struct{}
+
+func (corruptDecoder) Decode(_, raw []byte) error {
+	for i := range raw {
+		raw[i] = 0
+	}
+
+	return nil
+}
+
+func (corruptDecoder) Close() error {
+	return nil
+}
+
+func TestSelfTest(t *testing.T) {
+	t.Parallel()
+
+	backend := &fakeBackend{}
+	require.NoError(t, oodle.SelfTest(backend))
+	assert.Equal(t, 0, backend.open)
+
+	assert.ErrorIs(t, oodle.SelfTest(corruptBackend{}), oodle.ErrSelfTestFailed)
+
+	// Copying isn't enough for a sample that is compressed
+	err := oodle.SelfTest(backend, oodle.Sample{Compressed: []byte{1}, Raw: []byte{1, 1}})
+	assert.ErrorIs(t, err, oodle.ErrSelfTestFailed)
+}
+
+func TestSetupError(t *testing.T) {
+	t.Parallel()
+
+	err := error(&oodle.SetupError{
+		Step:   oodle.StepScan,
+		Exe:    `C:\game\ffxiv_dx11.exe`,
+		Symbol: "OodleNetwork1UDP_Decode",
+	})
+
+	assert.ErrorIs(t, err, oodle.ErrSetupFailed)
+	assert.Contains(t, err.Error(), "OodleNetwork1UDP_Decode")
+
+	err = &oodle.SetupError{Step: oodle.StepFind, Err: oodle.ErrExeNotFound}
+	assert.ErrorIs(t, err, oodle.ErrSetupFailed)
+	assert.ErrorIs(t, err, oodle.ErrExeNotFound)
+}
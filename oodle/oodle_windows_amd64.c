@@ -6,6 +6,7 @@
 #include <stddef.h>   // size_t
 #include <stdint.h>   // int32_t, int64_t
 #include <stdio.h>    // sprintf_s
+#include <stdlib.h>   // calloc, free
 #include <string.h>   // memset
 
 #define WIN32_LEAN_AND_MEAN
@@ -17,7 +18,12 @@
 
 #define HASHTABLE_BITS 19
 #define WINDOW_SIZE 0x16000
-#define MAX_DECOMPRESSED_SIZE (1 << 16)
+
+// The results of setup
+#define SETUP_OK 0
+#define SETUP_LOAD_FAILED 1
+#define SETUP_IMPORTS_FAILED 2
+#define SETUP_SCAN_FAILED 3
 
 static int64_t (*OodleNetworkUDP_State_Size)() = NULL;
 
@@ -181,32 +187,60 @@ static void *aligned_calloc(const size_t size)
 static HMODULE hModule = NULL;
 
 /**
- * @brief A critical section object guarding the use of OodleNetwork1UDP_Decode.
+ * @brief The state for decoding one connection's data.
  */
-static CRITICAL_SECTION criticalSection;
+typedef struct decoder
+{
+    void *window;
+    void *state;
+    void *shared;
+} decoder;
 
-static void *window = NULL;
-static void *state = NULL;
-static void *shared = NULL;
+void decoder_free(decoder *dec);
 
-DWORD setup(const LPCSTR lpLibFileName)
+void shutdown()
+{
+    OodleNetwork1UDP_Decode = NULL;
+    OodleNetwork1UDP_Train = NULL;
+    OodleNetwork1_Shared_SetWindow = NULL;
+    OodleNetwork1_Shared_Size = NULL;
+    OodleNetworkUDP_State_Size = NULL;
+
+    FreeLibrary(hModule);
+    hModule = NULL;
+}
+
+/**
+ * @brief Unloads the game executable after a failed setup.
+ *
+ * @param status The status to return.
+ * @return `int` The status.
+ */
+static int setup_failed(const int status)
+{
+    shutdown();
+    return status;
+}
+
+int setup(const LPCSTR lpLibFileName, DWORD *lastError, int *failedScan)
 {
     assert(lpLibFileName != NULL);
 
     if (hModule != NULL)
-        return 0;
-
-    InitializeCriticalSectionAndSpinCount(&criticalSection, 0x400);
+        return SETUP_OK;
 
     // Load the game executable as a library (this is cursed!)
     hModule = LoadLibraryExA(lpLibFileName, NULL, LOAD_LIBRARY_REQUIRE_SIGNED_TARGET);
     if (!hModule)
-        return GetLastError();
+    {
+        *lastError = GetLastError();
+        return SETUP_LOAD_FAILED;
+    }
 
     // Patch the import table (in memory) for the game image,
     // so that it can call imported functions image without crashing.
     if (!fixup_imports(hModule))
-        return 3;
+        return setup_failed(SETUP_IMPORTS_FAILED);
 
     // B8 ?? ?? ?? ?? C3 CC CC CC CC CC CC CC CC CC CC 40 55 56
     OodleNetworkUDP_State_Size = scan_image(hModule, (int[]){0xB8, -1, -1, -1, -1, 0xC3, 0xCC, 0xCC, 0xCC, 0xCC, 0xCC, 0xCC, 0xCC, 0xCC, 0xCC, 0xCC, 0x40, 0x55, 0x56}, 19);
@@ -223,64 +257,85 @@ DWORD setup(const LPCSTR lpLibFileName)
     // 40 53 48 83 EC 30 48 8B 44 24 ?? 49 8B D9 48 85 C0
     OodleNetwork1UDP_Decode = scan_image(hModule, (int[]){0x40, 0x53, 0x48, 0x83, 0xEC, 0x30, 0x48, 0x8B, 0x44, 0x24, -1, 0x49, 0x8B, 0xD9, 0x48, 0x85, 0xC0}, 17);
 
-    if (!OodleNetworkUDP_State_Size || !OodleNetwork1_Shared_Size || !OodleNetwork1_Shared_SetWindow || !OodleNetwork1UDP_Train || !OodleNetwork1UDP_Decode)
-        return 1;
+    // Report the first function that wasn't found, in the order above
+    const void *const functions[] = {
+        OodleNetworkUDP_State_Size,
+        OodleNetwork1_Shared_Size,
+        OodleNetwork1_Shared_SetWindow,
+        OodleNetwork1UDP_Train,
+        OodleNetwork1UDP_Decode,
+    };
+
+    for (int i = 0; i < (int)(sizeof(functions) / sizeof(functions[0])); ++i)
+    {
+        if (!functions[i])
+        {
+            *failedScan = i;
+            return setup_failed(SETUP_SCAN_FAILED);
+        }
+    }
+
+    return SETUP_OK;
+}
+
+
+decoder *decoder_new()
+{
+    decoder *const dec = calloc(1, sizeof(decoder));
+    if (!dec)
+        return NULL;
 
     // Allocate memory for Oodle operations.
     // These *must* be zero-initialized and aligned,
     // otherwise it will mysteriously crash.
-    window = aligned_calloc(WINDOW_SIZE);
-    state = aligned_calloc(OodleNetworkUDP_State_Size());
-    shared = aligned_calloc(OodleNetwork1_Shared_Size(HASHTABLE_BITS));
+    dec->window = aligned_calloc(WINDOW_SIZE);
+    dec->state = aligned_calloc(OodleNetworkUDP_State_Size());
+    dec->shared = aligned_calloc(OodleNetwork1_Shared_Size(HASHTABLE_BITS));
 
-    if (!window || !state || !shared)
-        return 2;
+    if (!dec->window || !dec->state || !dec->shared)
+    {
+        decoder_free(dec);
+        return NULL;
+    }
 
     // Set up Oodle
-    EnterCriticalSection(&criticalSection);
-    OodleNetwork1_Shared_SetWindow(shared, HASHTABLE_BITS, window, WINDOW_SIZE);
-    OodleNetwork1UDP_Train(state, shared, NULL, NULL, 0);
-    LeaveCriticalSection(&criticalSection);
+    OodleNetwork1_Shared_SetWindow(dec->shared, HASHTABLE_BITS, dec->window, WINDOW_SIZE);
+    OodleNetwork1UDP_Train(dec->state, dec->shared, NULL, NULL, 0);
 
-    return 0;
+    return dec;
 }
 
-void shutdown()
+void decoder_free(decoder *dec)
 {
-    _aligned_free(shared);
-    shared = NULL;
+    if (!dec)
+        return;
 
-    _aligned_free(state);
-    state = NULL;
-
-    _aligned_free(window);
-    window = NULL;
-
-    OodleNetwork1UDP_Decode = NULL;
-    OodleNetwork1UDP_Train = NULL;
-    OodleNetwork1_Shared_SetWindow = NULL;
-    OodleNetwork1_Shared_Size = NULL;
-    OodleNetworkUDP_State_Size = NULL;
-
-    FreeLibrary(hModule);
-    hModule = NULL;
-
-    DeleteCriticalSection(&criticalSection);
+    _aligned_free(dec->shared);
+    _aligned_free(dec->state);
+    _aligned_free(dec->window);
+    free(dec);
 }
 
-bool decode(const void *comp, const int64_t compLen, void *raw, const int64_t rawLen)
+bool decoder_decode(const decoder *dec, const void *comp, const int64_t compLen, void *raw, const int64_t rawLen)
 {
-    assert(rawLen <= MAX_DECOMPRESSED_SIZE);
+    assert(compLen > 0 && rawLen > 0);
+
+    // Copy the compressed data into aligned storage, which is on the heap
+    // since bundles can be larger than is reasonable for the stack
+    void *const compAligned = _aligned_malloc((size_t)compLen, alignof(__m128));
+    if (!compAligned)
+        return false;
 
-    // Copy the compressed data into aligned storage
-    alignas(__m128) unsigned char compAligned[MAX_DECOMPRESSED_SIZE];
-    if (memcpy_s(compAligned, MAX_DECOMPRESSED_SIZE, comp, compLen) != 0)
+    if (memcpy_s(compAligned, (size_t)compLen, comp, (size_t)compLen) != 0)
+    {
+        _aligned_free(compAligned);
         return false;
+    }
 
     // Decompress the data
-    EnterCriticalSection(&criticalSection);
-    const bool success = OodleNetwork1UDP_Decode(state, shared, compAligned, compLen, raw, rawLen);
-    LeaveCriticalSection(&criticalSection);
+    const bool success = OodleNetwork1UDP_Decode(dec->state, dec->shared, compAligned, compLen, raw, rawLen);
+
+    _aligned_free(compAligned);
 
     return success;
 }
@@ -7,54 +7,183 @@ package oodle
 #include <minwindef.h>
 #include <stdbool.h>
 #include <stdint.h>
+#include <stdlib.h>
 
-DWORD setup(const LPCSTR lpLibFileName);
+typedef struct decoder decoder;
+
+#define SETUP_OK 0
+#define SETUP_LOAD_FAILED 1
+#define SETUP_IMPORTS_FAILED 2
+#define SETUP_SCAN_FAILED 3
+
+int setup(const LPCSTR lpLibFileName, DWORD *lastError, int *failedScan);
 void shutdown();
-bool decode(const void *comp, const int64_t compLen, void* raw, const int64_t rawLen);
+decoder *decoder_new();
+void decoder_free(decoder *dec);
+bool decoder_decode(const decoder *dec, const void *comp, const int64_t compLen, void *raw, const int64_t rawLen);
 */
 import "C"
 
 import (
 	"errors"
 	"fmt"
+	"sync"
+	"syscall"
 	"unsafe"
 
 	log "github.com/sirupsen/logrus"
 )
 
-var (
-	ErrDecompressionFailed = errors.New("oodle: decompression failed in native code")
-	ErrSetupFailed         = errors.New("oodle: setup failed in native code")
-)
+var ErrOutOfMemory = errors.New("oodle: out of memory")
+
+// The Oodle functions that setup scans the game executable for, in order.
+var scannedSymbols = [...]string{
+	"OodleNetworkUDP_State_Size",
+	"OodleNetwork1_Shared_Size",
+	"OodleNetwork1_Shared_SetWindow",
+	"OodleNetwork1UDP_Train",
+	"OodleNetwork1UDP_Decode",
+}
+
+// Decompresses with the Oodle functions in the game executable.
+// The executable stays loaded until Shutdown and all Decoders are closed.
+type gameExe struct {
+	exe      string // The path of the game executable
+	mu       sync.Mutex
+	decoders int  // The number of open decoders
+	closed   bool // Whether Shutdown has been called
+}
+
+func (g *gameExe) NewDecoder() (Decoder, error) { //nolint:ireturn
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.closed {
+		return nil, ErrDecoderClosed
+	}
+
+	dec := C.decoder_new()
+	if dec == nil {
+		return nil, ErrOutOfMemory
+	}
+
+	g.decoders++
+
+	return &gameExeDecoder{exe: g, dec: dec}, nil
+}
+
+func (g *gameExe) String() string {
+	return "game executable " + g.exe
+}
+
+// Closes the backend, which unloads the game executable once all of its
+// Decoders are closed.
+func (g *gameExe) close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.closed = true
+	g.unloadIfUnused()
+}
+
+// Unloads the game executable if the backend and all of its Decoders are closed.
+// g.mu must be held.
+func (g *gameExe) unloadIfUnused() {
+	if g.closed && g.decoders == 0 {
+		C.shutdown()
+	}
+}
+
+type gameExeDecoder struct {
+	exe *gameExe
+	dec *C.decoder
+}
 
-func Decode(comp, raw []byte) error {
-	if C.decode(unsafe.Pointer(&comp[0]), C.longlong(len(comp)), unsafe.Pointer(&raw[0]), C.longlong(len(raw))) {
+func (d *gameExeDecoder) Decode(comp, raw []byte) error {
+	if d.dec == nil {
+		return ErrDecoderClosed
+	}
+
+	if len(comp) == 0 || len(raw) == 0 {
+		return fmt.Errorf("%w: empty buffer", ErrDecompressionFailed)
+	}
+
+	if C.decoder_decode(d.dec, unsafe.Pointer(&comp[0]), C.int64_t(len(comp)), unsafe.Pointer(&raw[0]), C.int64_t(len(raw))) {
 		return nil
 	}
 
 	return ErrDecompressionFailed
 }
 
+func (d *gameExeDecoder) Close() error {
+	if d.dec == nil {
+		return nil
+	}
+
+	C.decoder_free(d.dec)
+	d.dec = nil
+
+	d.exe.mu.Lock()
+	defer d.exe.mu.Unlock()
+
+	d.exe.decoders--
+	d.exe.unloadIfUnused()
+
+	return nil
+}
+
+// Sets up decompression with the game executable and uses it,
+// unless another Backend is already in use. Failures are reported
+// as a *SetupError.
 func Setup() error {
+	if inUse() {
+		return nil
+	}
+
 	// Get the location of the game executable
-	exe, err := findGameExe()
+	exe, err := findGameExe(currentConfig())
 	if err != nil {
-		return fmt.Errorf("oodle setup: %w", err)
+		return &SetupError{Step: StepFind, Err: err}
 	}
 
-	log.Debugf("Loaded game executable at: %s", exe)
-
 	// Pass the exe path to native code for setup
 	cstr := C.CString(exe)
 	defer C.free(unsafe.Pointer(cstr))
 
-	if status := C.setup(cstr); status != 0 {
-		return fmt.Errorf("%w (status %d)", ErrSetupFailed, status)
+	var (
+		lastError  C.DWORD
+		failedScan C.int
+	)
+
+	switch C.setup(cstr, &lastError, &failedScan) {
+	case C.SETUP_OK:
+	case C.SETUP_LOAD_FAILED:
+		return &SetupError{Step: StepLoad, Exe: exe, Err: syscall.Errno(lastError)}
+	case C.SETUP_IMPORTS_FAILED:
+		return &SetupError{Step: StepImports, Exe: exe}
+	case C.SETUP_SCAN_FAILED:
+		return &SetupError{Step: StepScan, Exe: exe, Symbol: scannedSymbols[failedScan]}
+	default:
+		return &SetupError{Step: StepLoad, Exe: exe, Err: ErrSetupFailed}
 	}
 
+	log.Debugf("Loaded game executable at: %s", exe)
+
+	Use(&gameExe{exe: exe})
+
 	return nil
 }
 
+// Shuts down decompression with the game executable, if it is in use.
+// The executable is unloaded once all of its Decoders are closed.
 func Shutdown() {
-	C.shutdown()
+	if g, ok := CurrentBackend().(*gameExe); ok {
+		Use(nil)
+		g.close()
+	}
 }
+
+var (
+	_ Backend = (*gameExe)(nil)
+	_ Decoder = (*gameExeDecoder)(nil)
+)
@@ -0,0 +1,125 @@
+package oodle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrSetupFailed    = errors.New("oodle: setup failed")
+	ErrExeNotFound    = errors.New("oodle: game executable not found")
+	ErrSelfTestFailed = errors.New("oodle: self-test decoded the sample incorrectly")
+)
+
+// Config holds the settings used by Setup.
+type Config struct {
+	// The path of the game executable to decompress with.
+	// If empty, the running game process and the default install
+	// locations are tried instead.
+	GameExe string
+}
+
+var (
+	configMu sync.Mutex
+	config   Config
+)
+
+// Sets the Config used by subsequent calls to Setup.
+func Configure(cfg Config) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	config = cfg
+}
+
+// Gets the Config used by Setup.
+func currentConfig() Config {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	return config
+}
+
+// SetupStep is a step of setting up decompression with the game executable.
+type SetupStep string
+
+const (
+	StepFind    SetupStep = "find"    // Locating the game executable
+	StepLoad    SetupStep = "load"    // Loading the game executable as a library
+	StepImports SetupStep = "imports" // Patching the game executable's imports
+	StepScan    SetupStep = "scan"    // Scanning the game executable for an Oodle function
+)
+
+// SetupError describes which step of Setup failed. It matches ErrSetupFailed
+// with errors.Is, and unwraps to the underlying error, if any.
+type SetupError struct {
+	Step   SetupStep
+	Exe    string // The game executable, if one was found
+	Symbol string // The Oodle function whose signature wasn't found, for StepScan
+	Err    error  // The underlying error, if any
+}
+
+func (e *SetupError) Error() string {
+	switch e.Step {
+	case StepFind:
+		return fmt.Sprintf("oodle: find game executable: %v", e.Err)
+	case StepLoad:
+		return fmt.Sprintf("oodle: load game executable %s: %v", e.Exe, e.Err)
+	case StepImports:
+		return fmt.Sprintf("oodle: fix up imports of game executable %s", e.Exe)
+	case StepScan:
+		return fmt.Sprintf("oodle: scan game executable %s: signature of %s not found", e.Exe, e.Symbol)
+	default:
+		return fmt.Sprintf("oodle: setup failed at step %q", e.Step)
+	}
+}
+
+func (e *SetupError) Unwrap() error {
+	return e.Err
+}
+
+func (e *SetupError) Is(target error) bool {
+	return target == ErrSetupFailed //nolint:errorlint,goerr113
+}
+
+// Sample is a packet compressed with OodleNetwork, and what it decodes to.
+type Sample struct {
+	Compressed []byte
+	Raw        []byte
+}
+
+// The sample that SelfTest always decodes. OodleNetwork stores packets that
+// don't compress as-is, and decodes any packet whose compressed and raw
+// lengths are equal by copying it, so this only checks that the backend can
+// be called, not that it decompresses correctly. No packet compressed by the
+// game is embedded yet, so that needs a sample from a capture.
+var storedSample = Sample{
+	Compressed: []byte("goblade oodle self-test: \x00\x01\x02\x03\xfc\xfd\xfe\xff"),
+	Raw:        []byte("goblade oodle self-test: \x00\x01\x02\x03\xfc\xfd\xfe\xff"),
+}
+
+// Decodes an embedded sample, then each of the given samples, with a new
+// Decoder from b to check that the backend is usable. Samples compressed
+// by the game, such as from a capture, check that it decompresses correctly.
+func SelfTest(b Backend, samples ...Sample) error {
+	dec, err := b.NewDecoder()
+	if err != nil {
+		return fmt.Errorf("create decoder: %w", err)
+	}
+	defer dec.Close()
+
+	for i, sample := range append([]Sample{storedSample}, samples...) {
+		raw := make([]byte, len(sample.Raw))
+		if err := dec.Decode(sample.Compressed, raw); err != nil {
+			return fmt.Errorf("decode sample %d: %w", i, err)
+		}
+
+		if !bytes.Equal(raw, sample.Raw) {
+			return fmt.Errorf("%w: sample %d", ErrSelfTestFailed, i)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !cgo || !(windows && amd64)
+
+package oodle
+
+// There is no built-in Backend on this platform, so unless another is
+// already in use, Setup fails with ErrPlatformNotSupported.
+// The Config set by Configure is ignored.
+func Setup() error {
+	if inUse() {
+		return nil
+	}
+
+	return ErrPlatformNotSupported
+}
+
+func Shutdown() {
+	// Do nothing
+}
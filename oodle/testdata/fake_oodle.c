@@ -0,0 +1,74 @@
+// A fake Oodle library for testing. Like OodleNetwork, it decodes packets
+// whose compressed and raw lengths are equal by copying them, and otherwise
+// "decodes" a single byte by inverting its bits and repeating it.
+// It exports the OodleNetwork1 API used by goblade, and checks that it is
+// called in the right order with the buffers it asked for.
+
+#include <stdint.h>
+
+static int trained = 0;
+
+int64_t OodleNetwork1UDP_State_Size(void)
+{
+    return 1024;
+}
+
+int64_t OodleNetwork1_Shared_Size(int32_t htbits)
+{
+    return (int64_t)1 << htbits;
+}
+
+void OodleNetwork1_Shared_SetWindow(void *data, int32_t htbits, const void *window, int32_t window_size)
+{
+    (void)data;
+    (void)htbits;
+    (void)window;
+    (void)window_size;
+}
+
+void OodleNetwork1UDP_Train(
+    void *state,
+    const void *shared,
+    const void **training_packet_pointers,
+    const int32_t *training_packet_sizes,
+    int32_t num_training_packets)
+{
+    (void)shared;
+    (void)training_packet_pointers;
+    (void)training_packet_sizes;
+    (void)num_training_packets;
+
+    *(int *)state = 1;
+    trained = 1;
+}
+
+int32_t OodleNetwork1UDP_Decode(
+    const void *state,
+    const void *shared,
+    const void *comp,
+    int64_t compLen,
+    void *raw,
+    int64_t rawLen)
+{
+    (void)shared;
+
+    if (!trained || *(const int *)state != 1)
+        return 0;
+
+    if (compLen == rawLen)
+    {
+        for (int64_t i = 0; i < rawLen; ++i)
+            ((unsigned char *)raw)[i] = ((const unsigned char *)comp)[i];
+    }
+    else if (compLen == 1)
+    {
+        for (int64_t i = 0; i < rawLen; ++i)
+            ((unsigned char *)raw)[i] = ~*(const unsigned char *)comp;
+    }
+    else
+    {
+        return 0;
+    }
+
+    return 1;
+}
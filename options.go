@@ -0,0 +1,193 @@
+package goblade
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/sparta142/goblade/ffxiv"
+	"github.com/sparta142/goblade/net"
+	"github.com/sparta142/goblade/oodle"
+)
+
+var ErrNilReader = errors.New("goblade: reader is nil")
+
+// Option configures a Capture. Options are applied in order, so later
+// ones override earlier ones.
+type Option func(c *Capture) error
+
+// Captures from a network interface in real time, or from the default
+// network interface if device is empty. This is the default source.
+func WithInterface(device string) Option {
+	return func(c *Capture) error {
+		c.source = &liveSource{device: device}
+		return nil
+	}
+}
+
+// Sets whether to put the network interface into promiscuous mode.
+func WithPromiscuous(promiscuous bool) Option {
+	return func(c *Capture) error {
+		c.promiscuous = promiscuous
+		return nil
+	}
+}
+
+// Captures from a pcap-compatible file, with libpcap.
+func WithFile(path string) Option {
+	return func(c *Capture) error {
+		c.source = &fileSource{path: path}
+		return nil
+	}
+}
+
+// Captures from pcap or pcapng data, such as a file or a pipe from another
+// capture tool. The data is read without libpcap, but it is still needed
+// to compile the filter.
+func WithReader(r io.Reader) Option {
+	return func(c *Capture) error {
+		if r == nil {
+			return ErrNilReader
+		}
+
+		c.source = &readerSource{r: r}
+
+		return nil
+	}
+}
+
+// Replaces the BPF filter that selects the packets to decode.
+// If it is empty, the default filter for the region is used
+// (see net.DefaultFilter).
+func WithFilter(filter string) Option {
+	return func(c *Capture) error {
+		c.opts.Filter = filter
+		return nil
+	}
+}
+
+// Decodes with the opcode table for a region, which is loaded by New.
+func WithRegion(region ffxiv.Region) Option {
+	return func(c *Capture) error {
+		c.region = region
+		return nil
+	}
+}
+
+// Switches to the opcode table for the region of the first connection.
+// Until then, the table for the region from WithRegion is used.
+//
+// Regions are detected from the data center networks of ffxiv.DefaultResolver.
+// The embedded networks are all global, so unless a Resolver with networks
+// in other regions has been set, no other region can be detected, and New
+// logs a warning. The default filter then only captures global traffic;
+// use WithRegion for the other regions instead.
+func WithRegionDetection() Option {
+	return func(c *Capture) error {
+		c.detect = true
+		return nil
+	}
+}
+
+// Keeps the opcode table in store, so that it can be replaced while the
+// capture runs. If the store is empty, or there is a WithRegion option,
+// New stores the table for the region in it.
+func WithOpcodes(store *ffxiv.OpcodeStore) Option {
+	return func(c *Capture) error {
+		c.opcodes = store
+		return nil
+	}
+}
+
+// Loads opcode tables with load instead of ffxiv.GetOpcodes,
+// such as to read them from a file.
+func WithOpcodeLoader(load OpcodeLoader) Option {
+	return func(c *Capture) error {
+		c.loadOpcodes = load
+		return nil
+	}
+}
+
+// Decompresses Oodle with b while the capture runs,
+// instead of the backend set up by oodle.Setup.
+func WithOodle(b oodle.Backend) Option {
+	return func(c *Capture) error {
+		c.oodleBackend = b
+		return nil
+	}
+}
+
+// Replaces all of the net.Options set so far.
+func WithCaptureOptions(opts net.Options) Option {
+	return func(c *Capture) error {
+		c.opts = opts
+		return nil
+	}
+}
+
+// Sets whether to keep Bundles whose Oodle payloads can't be decompressed,
+// with their payloads still compressed. See net.Options.DeferOodle.
+func WithDeferOodle(deferOodle bool) Option {
+	return func(c *Capture) error {
+		c.opts.DeferOodle = deferOodle
+		return nil
+	}
+}
+
+// Reports a StatsEvent this often, and when the capture ends.
+func WithStatsInterval(interval time.Duration) Option {
+	return func(c *Capture) error {
+		c.opts.StatsInterval = interval
+		return nil
+	}
+}
+
+// Sets the most bytes to capture of each packet from a network interface.
+func WithSnaplen(snaplen int) Option {
+	return func(c *Capture) error {
+		c.opts.Snaplen = snaplen
+		return nil
+	}
+}
+
+// Sets the most pages of out-of-order data to buffer for one connection,
+// and for all of them, where zero means no limit and net.UseDefault the
+// default. See net.Options.MaxBufferedPagesPerConnection.
+func WithMaxBufferedPages(perConnection, total int) Option {
+	return func(c *Capture) error {
+		c.opts.MaxBufferedPagesPerConnection = perConnection
+		c.opts.MaxBufferedPagesTotal = total
+
+		return nil
+	}
+}
+
+// Sets the size of the buffer between each flow's reassembly and decoding, in bytes.
+func WithPipeBufferSize(size int) Option {
+	return func(c *Capture) error {
+		c.opts.PipeBufferSize = size
+		return nil
+	}
+}
+
+// Sets the largest Bundle to decode, both as sent and once its payload
+// is decompressed. See net.Options.MaxBundleSize.
+func WithMaxBundleSize(size int) Option {
+	return func(c *Capture) error {
+		c.opts.MaxBundleSize = size
+		return nil
+	}
+}
+
+// Sets the most results of each kind to hold while waiting for Events to
+// be read, and what to do with new results when that many are waiting.
+// An empty policy blocks when reading a file, and drops the oldest
+// results when capturing live (see net.DefaultQueuePolicy).
+func WithQueue(size int, policy net.QueuePolicy) Option {
+	return func(c *Capture) error {
+		c.opts.QueueSize = size
+		c.opts.QueuePolicy = policy
+
+		return nil
+	}
+}
@@ -0,0 +1,107 @@
+package goblade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/google/gopacket/pcap"
+	"github.com/jackpal/gateway"
+	"github.com/sparta142/goblade/net"
+)
+
+// Flag indicating that a network interface is loopback.
+const pcapIfLoopback = uint32(0x00000001)
+
+var ErrNoDefaultInterface = errors.New("goblade: no default interface found")
+
+// Where a Capture reads its packets from.
+type source interface {
+	capture(ctx context.Context, c *Capture, outputs net.Outputs) error
+}
+
+// Captures from a network interface in real time.
+type liveSource struct {
+	device string // The default interface's, if empty
+}
+
+func (s *liveSource) capture(ctx context.Context, c *Capture, outputs net.Outputs) error {
+	device := s.device
+
+	if device == "" {
+		var err error
+		if device, err = DefaultInterface(); err != nil {
+			return err
+		}
+
+		log.Infof("Capturing on default device: %s", device)
+	} else {
+		log.Infof("Capturing on specified device: %s", device)
+	}
+
+	handle, err := net.OpenLive(device, c.promiscuous, c.opts)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+	defer handle.Close()
+
+	return net.CaptureOptions(ctx, handle, outputs, c.opts) //nolint:wrapcheck
+}
+
+// Captures from a pcap-compatible file, with libpcap.
+type fileSource struct {
+	path string
+}
+
+func (s *fileSource) capture(ctx context.Context, c *Capture, outputs net.Outputs) error {
+	handle, err := pcap.OpenOffline(s.path)
+	if err != nil {
+		return fmt.Errorf("open offline pcap file: %w", err)
+	}
+	defer handle.Close()
+
+	log.Infof("Parsing capture file: %s", s.path)
+
+	return net.CaptureOptions(ctx, handle, outputs, c.opts) //nolint:wrapcheck
+}
+
+// Captures from pcap or pcapng data, which is read without libpcap,
+// though libpcap still compiles the filter.
+type readerSource struct {
+	r io.Reader
+}
+
+func (s *readerSource) capture(ctx context.Context, c *Capture, outputs net.Outputs) error {
+	reader, err := net.NewPacketReader(s.r)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	return net.CaptureReader(ctx, reader, outputs, c.opts) //nolint:wrapcheck
+}
+
+// Gets the name of the non-loopback network interface for the default gateway.
+func DefaultInterface() (string, error) {
+	ip, err := gateway.DiscoverInterface()
+	if err != nil {
+		return "", fmt.Errorf("discover default network interface ip: %w", err)
+	}
+
+	devs, err := pcap.FindAllDevs()
+	if err != nil {
+		return "", fmt.Errorf("find all network interfaces: %w", err)
+	}
+
+	for _, iface := range devs {
+		for _, addr := range iface.Addresses {
+			if ip.Equal(addr.IP) && (iface.Flags&pcapIfLoopback) == 0 {
+				return iface.Name, nil
+			}
+		}
+	}
+
+	return "", ErrNoDefaultInterface
+}
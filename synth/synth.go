@@ -0,0 +1,258 @@
+// Package synth generates synthetic FINAL FANTASY XIV network captures.
+//
+// Generated captures contain a single TCP connection (with a complete
+// handshake and teardown) carrying randomized FFXIV bundles, and can
+// optionally simulate capture loss, reordering and retransmission.
+// They are written in the pcapng format and decode like real captures.
+package synth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/netip"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/sparta142/goblade/ffxiv"
+)
+
+var ErrInvalidConfig = errors.New("synth: invalid config")
+
+// The IPC magic value written to every generated Ipc.
+const ipcMagic = 0x0014
+
+// The encoding type written to every generated Bundle.
+const bundleEncoding = ffxiv.EncodingType(1)
+
+// The fraction of bundles that are sent from the server to the client.
+const serverToClientRatio = 0.8
+
+type Config struct {
+	// The client (local) and server (remote) endpoints. The server address
+	// should be in ffxiv.KnownDataCenterCIDRs() to pass goblade's capture filter.
+	Client, Server netip.AddrPort
+
+	// The time of the first packet.
+	Start time.Time
+
+	// The number of bundles to generate.
+	Bundles int
+
+	// The average number of bundles sent per second.
+	Rate float64
+
+	// The IPC opcodes to choose from.
+	Opcodes []uint16
+
+	// The range of IPC payload sizes to choose from, in bytes.
+	MinSize, MaxSize int
+
+	// The maximum number of segments in each bundle.
+	MaxSegments int
+
+	// The actor ID of the player character.
+	ActorID uint32
+
+	// How bundle payloads are compressed. Oodle is not supported.
+	Compression ffxiv.CompressionType
+
+	// The maximum number of bundle bytes carried by each TCP segment.
+	MSS int
+
+	// The probability that each data-carrying TCP segment is missing from
+	// the capture, captured out of order, or captured twice (respectively).
+	Loss, Reorder, Retransmit float64
+
+	// The seed for all random choices, so output can be reproduced.
+	Seed int64
+}
+
+// Summary describes what was written by Generate.
+type Summary struct {
+	Packets       int `json:"packets"`
+	Bundles       int `json:"bundles"`
+	Lost          int `json:"lost"`
+	Reordered     int `json:"reordered"`
+	Retransmitted int `json:"retransmitted"`
+}
+
+// Gets a Config that generates a short, lossless capture.
+func DefaultConfig() Config {
+	return Config{
+		Client:      netip.MustParseAddrPort("192.168.1.100:50432"),
+		Server:      netip.MustParseAddrPort("204.2.229.9:55027"),
+		Start:       time.Date(2023, 2, 25, 12, 0, 0, 0, time.UTC),
+		Bundles:     100,
+		Rate:        20,
+		Opcodes:     []uint16{0x009c, 0x038f},
+		MinSize:     32,
+		MaxSize:     512,
+		MaxSegments: 3,
+		ActorID:     0x106d2563,
+		Compression: ffxiv.CompressionZlib,
+		MSS:         1460,
+		Seed:        1,
+	}
+}
+
+func (c *Config) Validate() error {
+	switch {
+	case !c.Client.Addr().Is4() || !c.Server.Addr().Is4():
+		return fmt.Errorf("%w: endpoints must be IPv4", ErrInvalidConfig)
+	case c.Bundles < 0:
+		return fmt.Errorf("%w: negative bundle count", ErrInvalidConfig)
+	case c.Rate <= 0:
+		return fmt.Errorf("%w: rate must be positive", ErrInvalidConfig)
+	case len(c.Opcodes) == 0:
+		return fmt.Errorf("%w: no opcodes", ErrInvalidConfig)
+	case c.MinSize < 0 || c.MaxSize < c.MinSize:
+		return fmt.Errorf("%w: bad size range %d-%d", ErrInvalidConfig, c.MinSize, c.MaxSize)
+	case c.MaxSegments < 1:
+		return fmt.Errorf("%w: bundles need at least one segment", ErrInvalidConfig)
+	case c.Compression != ffxiv.CompressionNone && c.Compression != ffxiv.CompressionZlib:
+		return fmt.Errorf("%w: unsupported compression %d", ErrInvalidConfig, c.Compression)
+	case c.MSS < 1:
+		return fmt.Errorf("%w: MSS must be positive", ErrInvalidConfig)
+	case !isProbability(c.Loss) || !isProbability(c.Reorder) || !isProbability(c.Retransmit):
+		return fmt.Errorf("%w: probabilities must be in [0, 1]", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+func isProbability(p float64) bool {
+	return p >= 0 && p <= 1
+}
+
+// Generates a capture according to cfg and writes it to w as pcapng.
+func Generate(w io.Writer, cfg Config) (Summary, error) {
+	if err := cfg.Validate(); err != nil {
+		return Summary{}, err
+	}
+
+	writer, err := pcapgo.NewNgWriter(w, layers.LinkTypeEthernet)
+	if err != nil {
+		return Summary{}, fmt.Errorf("create pcapng writer: %w", err)
+	}
+
+	rng := rand.New(rand.NewSource(cfg.Seed)) //nolint:gosec // Reproducibility matters, not security
+	gen := &generator{
+		cfg:  cfg,
+		rng:  rng,
+		conn: newTCPConn(cfg.Client, cfg.Server, rng),
+		out:  &impairer{w: writer, cfg: cfg, rng: rng},
+		now:  cfg.Start,
+	}
+
+	if err := gen.run(); err != nil {
+		return gen.out.summary, err
+	}
+
+	if err := writer.Flush(); err != nil {
+		return gen.out.summary, fmt.Errorf("flush pcapng writer: %w", err)
+	}
+
+	return gen.out.summary, nil
+}
+
+type generator struct {
+	cfg  Config
+	rng  *rand.Rand
+	conn *tcpConn
+	out  *impairer
+	now  time.Time
+}
+
+func (g *generator) run() error {
+	// Open the connection
+	for _, pkt := range g.conn.handshake() {
+		g.tick(time.Millisecond)
+
+		if err := g.out.writeControl(pkt, g.now); err != nil {
+			return err
+		}
+	}
+
+	// Send each bundle, and have the other side acknowledge it
+	interval := time.Duration(float64(time.Second) / g.cfg.Rate)
+
+	for i := 0; i < g.cfg.Bundles; i++ {
+		// Jitter the interval by up to +/-50%
+		g.tick(interval/2 + time.Duration(g.rng.Int63n(int64(interval)+1)))
+
+		toClient := g.rng.Float64() < serverToClientRatio
+
+		data, err := g.bundle(toClient).MarshalBinary()
+		if err != nil {
+			return fmt.Errorf("marshal bundle: %w", err)
+		}
+
+		for _, pkt := range g.conn.send(toClient, data, g.cfg.MSS) {
+			if err := g.out.writeData(pkt, g.now); err != nil {
+				return err
+			}
+		}
+
+		g.tick(time.Millisecond)
+
+		if err := g.out.writeControl(g.conn.ack(toClient), g.now); err != nil {
+			return err
+		}
+
+		g.out.summary.Bundles++
+	}
+
+	// Close the connection
+	for _, pkt := range g.conn.teardown() {
+		g.tick(time.Millisecond)
+
+		if err := g.out.writeControl(pkt, g.now); err != nil {
+			return err
+		}
+	}
+
+	return g.out.flush()
+}
+
+// Advances the generator's clock.
+func (g *generator) tick(d time.Duration) {
+	g.now = g.now.Add(d)
+}
+
+// Builds a random Bundle sent at the current time.
+func (g *generator) bundle(toClient bool) *ffxiv.Bundle {
+	bundle := &ffxiv.Bundle{
+		Epoch:       uint64(g.now.UnixMilli()),
+		Encoding:    bundleEncoding,
+		Compression: g.cfg.Compression,
+		Segments:    make([]ffxiv.Segment, 1+g.rng.Intn(g.cfg.MaxSegments)),
+	}
+
+	for i := range bundle.Segments {
+		data := make([]byte, g.cfg.MinSize+g.rng.Intn(g.cfg.MaxSize-g.cfg.MinSize+1))
+		_, _ = g.rng.Read(data)
+
+		// Clients only talk about themselves, but servers talk about everyone
+		source := g.cfg.ActorID
+		if toClient && g.rng.Intn(2) == 0 {
+			source = g.rng.Uint32()
+		}
+
+		bundle.Segments[i] = ffxiv.Segment{
+			Source: source,
+			Target: g.cfg.ActorID,
+			Type:   ffxiv.SegmentIpc,
+			Payload: &ffxiv.Ipc{
+				Magic: ipcMagic,
+				Type:  g.cfg.Opcodes[g.rng.Intn(len(g.cfg.Opcodes))],
+				Epoch: uint32(g.now.Unix()),
+				Data:  data,
+			},
+		}
+	}
+
+	return bundle
+}
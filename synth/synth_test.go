@@ -0,0 +1,165 @@
+package synth_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/sparta142/goblade/ffxiv"
+	"github.com/sparta142/goblade/synth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Reads a generated capture back, returning the reassembled
+// byte stream sent by each side of the connection.
+func readStreams(t *testing.T, r io.Reader) (toClient, toServer []byte) {
+	t.Helper()
+
+	reader, err := pcapgo.NewNgReader(r, pcapgo.DefaultNgReaderOptions)
+	require.NoError(t, err)
+
+	// Keep the first copy of each segment by sequence number,
+	// and the initial sequence number of each side
+	segments := map[layers.TCPPort]map[uint32][]byte{}
+	isn := map[layers.TCPPort]uint32{}
+
+	for {
+		data, _, err := reader.ReadPacketData()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+
+		packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+		tcp := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+
+		if tcp.SYN {
+			isn[tcp.SrcPort] = tcp.Seq
+		}
+
+		if len(tcp.Payload) == 0 {
+			continue
+		}
+
+		if segments[tcp.SrcPort] == nil {
+			segments[tcp.SrcPort] = map[uint32][]byte{}
+		}
+
+		if _, ok := segments[tcp.SrcPort][tcp.Seq]; !ok {
+			segments[tcp.SrcPort][tcp.Seq] = tcp.Payload
+		}
+	}
+
+	concat := func(port uint16) []byte {
+		m, base := segments[layers.TCPPort(port)], isn[layers.TCPPort(port)]
+
+		seqs := make([]uint32, 0, len(m))
+		for seq := range m {
+			seqs = append(seqs, seq)
+		}
+
+		// Sort relative to the ISN, in case the sequence numbers wrapped around
+		sort.Slice(seqs, func(i, j int) bool { return seqs[i]-base < seqs[j]-base })
+
+		var buf []byte
+		for _, seq := range seqs {
+			buf = append(buf, m[seq]...)
+		}
+
+		return buf
+	}
+
+	cfg := synth.DefaultConfig()
+
+	return concat(cfg.Server.Port()), concat(cfg.Client.Port())
+}
+
+// Decodes all bundles in a byte stream.
+func readBundles(t *testing.T, stream []byte) []ffxiv.Bundle {
+	t.Helper()
+
+	var bundles []ffxiv.Bundle
+
+	for len(stream) > 0 {
+		length := ffxiv.PeekBundleLength(stream)
+		require.Positive(t, length)
+
+		var bundle ffxiv.Bundle
+		require.NoError(t, bundle.UnmarshalBinary(stream[:length]))
+
+		bundles = append(bundles, bundle)
+		stream = stream[length:]
+	}
+
+	return bundles
+}
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	for _, compression := range []ffxiv.CompressionType{ffxiv.CompressionNone, ffxiv.CompressionZlib} {
+		compression := compression
+
+		t.Run(compression.String(), func(t *testing.T) {
+			t.Parallel()
+
+			cfg := synth.DefaultConfig()
+			cfg.Compression = compression
+			cfg.MSS = 100 // Split most bundles across segments
+			cfg.Reorder = 0.1
+			cfg.Retransmit = 0.1
+
+			var buf bytes.Buffer
+			summary, err := synth.Generate(&buf, cfg)
+			require.NoError(t, err)
+			assert.Equal(t, cfg.Bundles, summary.Bundles)
+			assert.Positive(t, summary.Reordered)
+			assert.Positive(t, summary.Retransmitted)
+			assert.Zero(t, summary.Lost)
+
+			toClient, toServer := readStreams(t, &buf)
+			bundles := append(readBundles(t, toClient), readBundles(t, toServer)...)
+			require.Len(t, bundles, cfg.Bundles)
+
+			for _, bundle := range bundles {
+				assert.Equal(t, compression, bundle.Compression)
+
+				for _, segment := range bundle.Segments {
+					assert.Equal(t, cfg.ActorID, segment.Target)
+					assert.Contains(t, cfg.Opcodes, segment.Payload.(*ffxiv.Ipc).Type)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerate_Reproducible(t *testing.T) {
+	t.Parallel()
+
+	cfg := synth.DefaultConfig()
+	cfg.Loss = 0.05
+
+	var a, b bytes.Buffer
+	_, err := synth.Generate(&a, cfg)
+	require.NoError(t, err)
+	_, err = synth.Generate(&b, cfg)
+	require.NoError(t, err)
+
+	assert.Equal(t, a.Bytes(), b.Bytes())
+}
+
+func TestConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	cfg := synth.DefaultConfig()
+	cfg.Compression = ffxiv.CompressionOodle
+
+	_, err := synth.Generate(io.Discard, cfg)
+	assert.ErrorIs(t, err, synth.ErrInvalidConfig)
+}
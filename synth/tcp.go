@@ -0,0 +1,224 @@
+package synth
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+const (
+	ipTTL     = 64
+	tcpWindow = 65535
+)
+
+// Locally-administered MAC addresses for the client and its gateway.
+var (
+	clientMAC  = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	gatewayMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+)
+
+type tcpFlags struct {
+	syn, ack, psh, fin bool
+}
+
+// A TCP connection between the client and server, which produces
+// serialized Ethernet frames for each direction.
+type tcpConn struct {
+	client, server       netip.AddrPort
+	clientSeq, serverSeq uint32 // The next sequence number each side will send
+	ipID                 uint16
+}
+
+func newTCPConn(client, server netip.AddrPort, rng *rand.Rand) *tcpConn {
+	return &tcpConn{
+		client:    client,
+		server:    server,
+		clientSeq: rng.Uint32(),
+		serverSeq: rng.Uint32(),
+		ipID:      uint16(rng.Uint32()),
+	}
+}
+
+// Gets the frames of a three-way handshake initiated by the client.
+func (c *tcpConn) handshake() [][]byte {
+	syn := c.frame(true, tcpFlags{syn: true}, nil)
+	c.clientSeq++
+
+	synAck := c.frame(false, tcpFlags{syn: true, ack: true}, nil)
+	c.serverSeq++
+
+	ack := c.frame(true, tcpFlags{ack: true}, nil)
+
+	return [][]byte{syn, synAck, ack}
+}
+
+// Gets the frames of a graceful close initiated by the client.
+func (c *tcpConn) teardown() [][]byte {
+	clientFin := c.frame(true, tcpFlags{fin: true, ack: true}, nil)
+	c.clientSeq++
+
+	serverFin := c.frame(false, tcpFlags{fin: true, ack: true}, nil)
+	c.serverSeq++
+
+	ack := c.frame(true, tcpFlags{ack: true}, nil)
+
+	return [][]byte{clientFin, serverFin, ack}
+}
+
+// Gets the frames that carry data in one direction, split into segments of at most mss bytes.
+func (c *tcpConn) send(toClient bool, data []byte, mss int) [][]byte {
+	var frames [][]byte
+
+	for len(data) > 0 {
+		n := mss
+		if n > len(data) {
+			n = len(data)
+		}
+
+		frames = append(frames, c.frame(!toClient, tcpFlags{ack: true, psh: n == len(data)}, data[:n]))
+		data = data[n:]
+	}
+
+	return frames
+}
+
+// Gets a pure acknowledgement frame.
+func (c *tcpConn) ack(fromClient bool) []byte {
+	return c.frame(fromClient, tcpFlags{ack: true}, nil)
+}
+
+func (c *tcpConn) frame(fromClient bool, flags tcpFlags, payload []byte) []byte {
+	eth := &layers.Ethernet{EthernetType: layers.EthernetTypeIPv4}
+	ip := &layers.IPv4{Version: 4, TTL: ipTTL, Protocol: layers.IPProtocolTCP, Id: c.ipID}
+	tcp := &layers.TCP{
+		SYN:    flags.syn,
+		ACK:    flags.ack,
+		PSH:    flags.psh,
+		FIN:    flags.fin,
+		Window: tcpWindow,
+	}
+
+	src, dst := c.client, c.server
+	eth.SrcMAC, eth.DstMAC = clientMAC, gatewayMAC
+	tcp.Seq, tcp.Ack = c.clientSeq, c.serverSeq
+
+	if !fromClient {
+		src, dst = dst, src
+		eth.SrcMAC, eth.DstMAC = eth.DstMAC, eth.SrcMAC
+		tcp.Seq, tcp.Ack = c.serverSeq, c.clientSeq
+	}
+
+	if !flags.ack {
+		tcp.Ack = 0
+	}
+
+	ip.SrcIP, ip.DstIP = src.Addr().AsSlice(), dst.Addr().AsSlice()
+	tcp.SrcPort, tcp.DstPort = layers.TCPPort(src.Port()), layers.TCPPort(dst.Port())
+	_ = tcp.SetNetworkLayerForChecksum(ip) // Only fails for non-IP layers
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+
+	// Serialization only fails for invalid layers, which would be a bug here
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, tcp, gopacket.Payload(payload)); err != nil {
+		panic(fmt.Sprintf("serialize frame: %v", err))
+	}
+
+	// Advance the sender's state
+	c.ipID++
+
+	if fromClient {
+		c.clientSeq += uint32(len(payload))
+	} else {
+		c.serverSeq += uint32(len(payload))
+	}
+
+	return buf.Bytes()
+}
+
+// Writes frames to a pcapng file, applying the configured
+// loss, reordering and retransmission to data frames.
+type impairer struct {
+	w   *pcapgo.NgWriter
+	cfg Config
+	rng *rand.Rand
+
+	held    []byte    // A frame being held back to simulate reordering
+	last    time.Time // The timestamp of the last frame written
+	summary Summary
+}
+
+// Writes a connection control frame, which is never impaired.
+func (imp *impairer) writeControl(frame []byte, t time.Time) error {
+	return imp.write(frame, t)
+}
+
+// Writes a data frame, possibly losing, reordering or duplicating it.
+func (imp *impairer) writeData(frame []byte, t time.Time) error {
+	if imp.rng.Float64() < imp.cfg.Loss {
+		imp.summary.Lost++
+		return nil
+	}
+
+	if imp.held == nil && imp.rng.Float64() < imp.cfg.Reorder {
+		imp.held = frame
+		imp.summary.Reordered++
+
+		return nil
+	}
+
+	if err := imp.write(frame, t); err != nil {
+		return err
+	}
+
+	if err := imp.flush(); err != nil {
+		return err
+	}
+
+	if imp.rng.Float64() < imp.cfg.Retransmit {
+		imp.summary.Retransmitted++
+		return imp.write(frame, t)
+	}
+
+	return nil
+}
+
+// Writes the held-back frame, if any.
+func (imp *impairer) flush() error {
+	if imp.held == nil {
+		return nil
+	}
+
+	frame := imp.held
+	imp.held = nil
+
+	return imp.write(frame, imp.last)
+}
+
+func (imp *impairer) write(frame []byte, t time.Time) error {
+	// Keep timestamps strictly increasing, like a real capture
+	if !t.After(imp.last) {
+		t = imp.last.Add(time.Microsecond)
+	}
+
+	imp.last = t
+
+	err := imp.w.WritePacket(gopacket.CaptureInfo{
+		Timestamp:     t,
+		CaptureLength: len(frame),
+		Length:        len(frame),
+	}, frame)
+	if err != nil {
+		return fmt.Errorf("write packet: %w", err)
+	}
+
+	imp.summary.Packets++
+
+	return nil
+}